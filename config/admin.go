@@ -0,0 +1,10 @@
+package config
+
+import "os"
+
+// GetAdminToken returns the bearer token that protects admin endpoints.
+// Configurable via PIXERVE_ADMIN_TOKEN environment variable.
+// An empty value disables the admin endpoints entirely.
+func GetAdminToken() string {
+	return os.Getenv("PIXERVE_ADMIN_TOKEN")
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pixerve/logger"
+)
+
+// DefaultCacheControl is sent for served files unless PIXERVE_CACHE_CONTROL is set.
+// Output names start with the content hash of the upload, so such a name never changes content.
+const DefaultCacheControl = "public, max-age=31536000, immutable"
+
+// RevalidateCacheControl replaces rules with immutable for files whose names do not start with a
+// content hash, such as files placed in the serve directory by other means, since they may be
+// overwritten. Caches keep them but check the ETag before reuse.
+const RevalidateCacheControl = "public, no-cache"
+
+// CacheRule is the Cache-Control value for served files below a path prefix
+type CacheRule struct {
+	Prefix       string // path below /files/, starting with "/"
+	CacheControl string // empty sends no Cache-Control header
+}
+
+// GetCacheRules returns the Cache-Control rules for served files, longest prefix first.
+// Configurable via PIXERVE_CACHE_CONTROL as ";" separated <prefix>=<value> pairs, e.g.
+// "/=public, max-age=31536000, immutable;/drafts/=no-cache". A file uses the rule with the
+// longest matching prefix; an empty value sends no header.
+func GetCacheRules() []CacheRule {
+	env := os.Getenv("PIXERVE_CACHE_CONTROL")
+	if env == "" {
+		return []CacheRule{{Prefix: "/", CacheControl: DefaultCacheControl}}
+	}
+
+	var rules []CacheRule
+	for _, entry := range strings.Split(env, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			logger.Warnf("Ignoring invalid PIXERVE_CACHE_CONTROL value: %s", entry)
+			continue
+		}
+		rules = append(rules, CacheRule{Prefix: prefix, CacheControl: strings.TrimSpace(value)})
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Prefix) > len(rules[j].Prefix)
+	})
+	return rules
+}
+
+// GetCacheControl returns the Cache-Control value for the served file at urlPath (below /files/)
+func GetCacheControl(urlPath string) string {
+	for _, rule := range GetCacheRules() {
+		if strings.HasPrefix(urlPath, rule.Prefix) {
+			return rule.CacheControl
+		}
+	}
+	return ""
+}
+
+// IsDirectoryListingEnabled reports whether /files/ lists directory contents.
+// Configurable via PIXERVE_DIRECTORY_LISTING (default: true); directories return 404 when false.
+func IsDirectoryListingEnabled() bool {
+	env := os.Getenv("PIXERVE_DIRECTORY_LISTING")
+	if env == "" {
+		return true
+	}
+	enabled, err := strconv.ParseBool(env)
+	if err != nil {
+		logger.Warnf("Ignoring invalid PIXERVE_DIRECTORY_LISTING value: %s", env)
+		return true
+	}
+	return enabled
+}
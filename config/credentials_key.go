@@ -0,0 +1,47 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MasterKeySize is the required length of the credentials master key (AES-256)
+const MasterKeySize = 32
+
+// GetCredentialsMasterKey returns the master key used to encrypt stored credentials.
+// Priority: PIXERVE_CREDENTIALS_KEY environment variable > PIXERVE_CREDENTIALS_KEY_FILE.
+// Both hold a base64 encoded 32-byte key (e.g. from `openssl rand -base64 32`).
+// Returns nil without error when neither is set.
+func GetCredentialsMasterKey() ([]byte, error) {
+	if encoded := os.Getenv("PIXERVE_CREDENTIALS_KEY"); encoded != "" {
+		return decodeMasterKey(encoded)
+	}
+	if path := os.Getenv("PIXERVE_CREDENTIALS_KEY_FILE"); path != "" {
+		return ReadMasterKeyFile(path)
+	}
+	return nil, nil
+}
+
+// ReadMasterKeyFile reads a base64 encoded 32-byte master key from a file.
+// Surrounding whitespace (such as a trailing newline) is ignored.
+func ReadMasterKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read master key file: %w", err)
+	}
+	return decodeMasterKey(string(data))
+}
+
+// decodeMasterKey decodes a base64 master key and checks its length
+func decodeMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeySize, len(key))
+	}
+	return key, nil
+}
@@ -49,6 +49,13 @@ func GetSuccessDBPath() string {
 	return filepath.Join(GetDataDir(), "success.db")
 }
 
+// GetQueueDBPath returns the full path to the job queue database.
+// The queue database persists pending, in-flight and finished job state across restarts.
+// Path: {DATA_DIR}/ConvertQueue.db
+func GetQueueDBPath() string {
+	return filepath.Join(GetDataDir(), "ConvertQueue.db")
+}
+
 // GetDirectServeBaseDir returns the base directory for direct file serving.
 // This directory contains processed images that are served directly by the HTTP server.
 // Configurable via PIXERVE_SERVE_DIR environment variable for server administrators.
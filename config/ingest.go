@@ -0,0 +1,56 @@
+package config
+
+import (
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"pixerve/logger"
+)
+
+// GetIngestTimeout returns how long fetching a source image by URL may take, including the body.
+// Configurable via PIXERVE_INGEST_TIMEOUT as a Go duration (default 30s).
+func GetIngestTimeout() time.Duration {
+	const defaultTimeout = 30 * time.Second
+	if env := os.Getenv("PIXERVE_INGEST_TIMEOUT"); env != "" {
+		timeout, err := time.ParseDuration(env)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		logger.Warnf("Ignoring invalid PIXERVE_INGEST_TIMEOUT value: %s", env)
+	}
+	return defaultTimeout
+}
+
+// GetIngestAllowedHosts returns the hosts source images may be fetched from.
+// Configurable via PIXERVE_INGEST_ALLOWED_HOSTS as a comma separated list of hostnames,
+// where "*.example.com" matches any subdomain. Empty allows any public host.
+func GetIngestAllowedHosts() []string {
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv("PIXERVE_INGEST_ALLOWED_HOSTS"), ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// GetIngestAllowedNetworks returns address ranges exempt from the private network block,
+// for origins that live on an internal network.
+// Configurable via PIXERVE_INGEST_ALLOWED_NETWORKS as a comma separated list of CIDRs.
+func GetIngestAllowedNetworks() []netip.Prefix {
+	var networks []netip.Prefix
+	for _, cidr := range strings.Split(os.Getenv("PIXERVE_INGEST_ALLOWED_NETWORKS"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			logger.Warnf("Ignoring invalid network in PIXERVE_INGEST_ALLOWED_NETWORKS: %s", cidr)
+			continue
+		}
+		networks = append(networks, prefix.Masked())
+	}
+	return networks
+}
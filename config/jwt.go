@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"pixerve/logger"
+)
+
+// GetJWTSecret returns the HMAC (HS256) secret used to verify upload JWTs.
+// Priority: PIXERVE_JWT_SECRET environment variable > contents of PIXERVE_JWT_SECRET_FILE.
+// Returns nil when neither is set, which disables HS256 verification.
+func GetJWTSecret() ([]byte, error) {
+	if secret := os.Getenv("PIXERVE_JWT_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	if path := os.Getenv("PIXERVE_JWT_SECRET_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT secret file: %w", err)
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, fmt.Errorf("JWT secret file %s is empty", path)
+		}
+		return []byte(secret), nil
+	}
+	return nil, nil
+}
+
+// GetJWTPublicKeyFile returns the path to a PEM encoded RSA or ECDSA public key
+// used to verify RS256/ES256 upload JWTs. Configurable via PIXERVE_JWT_PUBLIC_KEY_FILE.
+func GetJWTPublicKeyFile() string {
+	return os.Getenv("PIXERVE_JWT_PUBLIC_KEY_FILE")
+}
+
+// GetJWKSFile returns the path to a local JSON Web Key Set file.
+// Keys are selected by the token's "kid" header and the file is reloaded when it changes.
+// Configurable via PIXERVE_JWKS_FILE.
+func GetJWKSFile() string {
+	return os.Getenv("PIXERVE_JWKS_FILE")
+}
+
+// GetJWTIssuer returns the required "iss" claim, or empty to accept any issuer.
+// Configurable via PIXERVE_JWT_ISSUER.
+func GetJWTIssuer() string {
+	return os.Getenv("PIXERVE_JWT_ISSUER")
+}
+
+// GetJWTAudience returns the value that must appear in the "aud" claim, or empty to skip the check.
+// Configurable via PIXERVE_JWT_AUDIENCE.
+func GetJWTAudience() string {
+	return os.Getenv("PIXERVE_JWT_AUDIENCE")
+}
+
+// GetJWTClockSkew returns the tolerance applied to exp, iat and nbf checks.
+// Configurable via PIXERVE_JWT_CLOCK_SKEW as a Go duration (e.g. "30s"). Defaults to 0.
+func GetJWTClockSkew() time.Duration {
+	if env := os.Getenv("PIXERVE_JWT_CLOCK_SKEW"); env != "" {
+		skew, err := time.ParseDuration(env)
+		if err == nil && skew >= 0 {
+			return skew
+		}
+		logger.Warnf("Ignoring invalid PIXERVE_JWT_CLOCK_SKEW value: %s", env)
+	}
+	return 0
+}
@@ -1,3 +0,0 @@
-package config
-
-const SHARED_JWT_SECRET = ""
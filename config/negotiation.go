@@ -0,0 +1,40 @@
+package config
+
+import (
+	"os"
+	"slices"
+	"strings"
+
+	"pixerve/logger"
+)
+
+// DefaultFormatPreference is the order in which negotiated image requests pick a format
+var DefaultFormatPreference = []string{"avif", "webp", "jpg", "png"}
+
+// GetFormatPreference returns the formats /images/ may serve, most preferred first.
+// Configurable via PIXERVE_FORMAT_PREFERENCE as a comma separated list (e.g. "webp,avif,jpg").
+func GetFormatPreference() []string {
+	env := os.Getenv("PIXERVE_FORMAT_PREFERENCE")
+	if env == "" {
+		return DefaultFormatPreference
+	}
+	var formats []string
+	for _, format := range strings.Split(env, ",") {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "jpeg" {
+			format = "jpg"
+		}
+		if format == "" {
+			continue
+		}
+		if !slices.Contains(DefaultFormatPreference, format) {
+			logger.Warnf("Ignoring invalid PIXERVE_FORMAT_PREFERENCE value: %s", format)
+			continue
+		}
+		formats = append(formats, format)
+	}
+	if len(formats) == 0 {
+		return DefaultFormatPreference
+	}
+	return formats
+}
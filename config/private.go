@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"pixerve/logger"
+)
+
+// DefaultPrivateURLMaxTTL is the longest validity of a minted private file URL
+const DefaultPrivateURLMaxTTL = 7 * 24 * time.Hour
+
+// GetPrivateServeBaseDir returns the directory for files of jobs with "private": true.
+// It must not be inside the direct serve directory, which is public under /files/.
+// Configurable via PIXERVE_PRIVATE_SERVE_DIR; defaults to "./private" relative to the executable.
+func GetPrivateServeBaseDir() string {
+	if dir := os.Getenv("PIXERVE_PRIVATE_SERVE_DIR"); dir != "" {
+		return dir
+	}
+	return "./private"
+}
+
+// GetPrivateURLKey returns the HMAC key that signs private file URLs (/private/).
+// Priority: PIXERVE_PRIVATE_URL_KEY environment variable > contents of PIXERVE_PRIVATE_URL_KEY_FILE.
+// Returns nil when neither is set, which disables serving and minting private URLs.
+func GetPrivateURLKey() ([]byte, error) {
+	if key := os.Getenv("PIXERVE_PRIVATE_URL_KEY"); key != "" {
+		return []byte(key), nil
+	}
+	if path := os.Getenv("PIXERVE_PRIVATE_URL_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private URL key file: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return nil, fmt.Errorf("private URL key file %s is empty", path)
+		}
+		return []byte(key), nil
+	}
+	return nil, nil
+}
+
+// GetPrivateURLMaxTTL returns the longest validity a client may request for private URLs.
+// Configurable via PIXERVE_PRIVATE_URL_MAX_TTL as a Go duration (e.g. "24h").
+func GetPrivateURLMaxTTL() time.Duration {
+	env := os.Getenv("PIXERVE_PRIVATE_URL_MAX_TTL")
+	if env == "" {
+		return DefaultPrivateURLMaxTTL
+	}
+	ttl, err := time.ParseDuration(env)
+	if err != nil || ttl <= 0 {
+		logger.Warnf("Ignoring invalid PIXERVE_PRIVATE_URL_MAX_TTL value: %s", env)
+		return DefaultPrivateURLMaxTTL
+	}
+	return ttl
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"pixerve/logger"
+)
+
+// DefaultTransformTimeout is how long encoding one on-the-fly variant may take
+const DefaultTransformTimeout = time.Minute
+
+// GetTransformKey returns the HMAC key that signs on-the-fly transformation URLs (/img/).
+// Priority: PIXERVE_TRANSFORM_KEY environment variable > contents of PIXERVE_TRANSFORM_KEY_FILE.
+// Returns nil when neither is set, which disables the /img/ endpoint.
+func GetTransformKey() ([]byte, error) {
+	if key := os.Getenv("PIXERVE_TRANSFORM_KEY"); key != "" {
+		return []byte(key), nil
+	}
+	if path := os.Getenv("PIXERVE_TRANSFORM_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transform key file: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return nil, fmt.Errorf("transform key file %s is empty", path)
+		}
+		return []byte(key), nil
+	}
+	return nil, nil
+}
+
+// GetTransformCacheDir returns the directory where transformed variants are cached.
+// Configurable via PIXERVE_TRANSFORM_CACHE_DIR.
+// Path: {DATA_DIR}/transform-cache by default
+func GetTransformCacheDir() string {
+	if dir := os.Getenv("PIXERVE_TRANSFORM_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(GetDataDir(), "transform-cache")
+}
+
+// GetTransformConcurrency returns how many on-the-fly variants may be encoded at once.
+// Configurable via PIXERVE_TRANSFORM_CONCURRENCY (default: the number of CPUs).
+func GetTransformConcurrency() int {
+	if env := os.Getenv("PIXERVE_TRANSFORM_CONCURRENCY"); env != "" {
+		n, err := strconv.Atoi(env)
+		if err == nil && n > 0 {
+			return n
+		}
+		logger.Warnf("Ignoring invalid PIXERVE_TRANSFORM_CONCURRENCY value: %s", env)
+	}
+	return runtime.NumCPU()
+}
+
+// GetTransformTimeout returns how long encoding one on-the-fly variant may take.
+// Configurable via PIXERVE_TRANSFORM_TIMEOUT as a Go duration (default 1m).
+func GetTransformTimeout() time.Duration {
+	if env := os.Getenv("PIXERVE_TRANSFORM_TIMEOUT"); env != "" {
+		timeout, err := time.ParseDuration(env)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		logger.Warnf("Ignoring invalid PIXERVE_TRANSFORM_TIMEOUT value: %s", env)
+	}
+	return DefaultTransformTimeout
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"pixerve/logger"
+)
+
+// DefaultMaxUploadSize is the upload size limit used when PIXERVE_MAX_UPLOAD_SIZE is unset
+const DefaultMaxUploadSize int64 = 100 << 20 // 100 MB
+
+// GetMaxUploadSize returns the largest file accepted by /upload for subjects without their own limit.
+// Configurable via PIXERVE_MAX_UPLOAD_SIZE as bytes or with a KB/MB/GB suffix (e.g. "250MB").
+func GetMaxUploadSize() int64 {
+	if env := os.Getenv("PIXERVE_MAX_UPLOAD_SIZE"); env != "" {
+		size, err := ParseByteSize(env)
+		if err == nil && size > 0 {
+			return size
+		}
+		logger.Warnf("Ignoring invalid PIXERVE_MAX_UPLOAD_SIZE value: %s", env)
+	}
+	return DefaultMaxUploadSize
+}
+
+// GetMaxUploadSizeFor returns the upload size limit for a JWT subject.
+// Per-subject limits are configured via PIXERVE_SUBJECT_UPLOAD_LIMITS as a comma separated
+// list of subject=size pairs (e.g. "tenant-a=500MB,tenant-b=10MB"); other subjects get GetMaxUploadSize.
+func GetMaxUploadSizeFor(subject string) int64 {
+	env := os.Getenv("PIXERVE_SUBJECT_UPLOAD_LIMITS")
+	for _, entry := range strings.Split(env, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || strings.TrimSpace(name) != subject {
+			continue
+		}
+		size, err := ParseByteSize(value)
+		if err == nil && size > 0 {
+			return size
+		}
+		logger.Warnf("Ignoring invalid upload limit for subject %s in PIXERVE_SUBJECT_UPLOAD_LIMITS: %s", subject, value)
+	}
+	return GetMaxUploadSize()
+}
+
+// ParseByteSize parses a size given in bytes or with a KB, MB or GB suffix (powers of 1024)
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if value > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return value * multiplier, nil
+}
+
+// DefaultMaxBatchFiles is the batch upload file limit used when PIXERVE_MAX_BATCH_FILES is unset
+const DefaultMaxBatchFiles = 100
+
+// GetMaxBatchFiles returns the largest number of files accepted by one batch upload,
+// counting each file inside an uploaded archive.
+// Configurable via PIXERVE_MAX_BATCH_FILES.
+func GetMaxBatchFiles() int {
+	if env := os.Getenv("PIXERVE_MAX_BATCH_FILES"); env != "" {
+		files, err := strconv.Atoi(env)
+		if err == nil && files > 0 {
+			return files
+		}
+		logger.Warnf("Ignoring invalid PIXERVE_MAX_BATCH_FILES value: %s", env)
+	}
+	return DefaultMaxBatchFiles
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"pixerve/logger"
+)
+
+// DefaultWriterConcurrency is the number of concurrent writes allowed per backend type
+const DefaultWriterConcurrency = 8
+
+// DefaultWriterIdleTimeout is how long an unused pooled backend client is kept open
+const DefaultWriterIdleTimeout = 5 * time.Minute
+
+// GetWriterConcurrency returns the number of writes and deletes that may run at once against
+// backendType, across all jobs. Configurable via PIXERVE_WRITER_CONCURRENCY as a comma
+// separated list of a default and type=limit overrides (e.g. "16,sftp=4").
+func GetWriterConcurrency(backendType string) int {
+	limit := DefaultWriterConcurrency
+	env := os.Getenv("PIXERVE_WRITER_CONCURRENCY")
+	for _, entry := range strings.Split(env, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(entry, "=")
+		if ok && strings.TrimSpace(name) != backendType {
+			continue
+		}
+		if !ok {
+			value = name
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			logger.Warnf("Ignoring invalid PIXERVE_WRITER_CONCURRENCY entry: %s", entry)
+			continue
+		}
+		limit = n
+		if ok {
+			return limit
+		}
+	}
+	return limit
+}
+
+// GetWriterIdleTimeout returns how long pooled S3, GCS and SFTP clients stay open without use.
+// Configurable via PIXERVE_WRITER_IDLE_TIMEOUT as a Go duration (e.g. "10m").
+func GetWriterIdleTimeout() time.Duration {
+	env := os.Getenv("PIXERVE_WRITER_IDLE_TIMEOUT")
+	if env == "" {
+		return DefaultWriterIdleTimeout
+	}
+	timeout, err := time.ParseDuration(env)
+	if err != nil || timeout <= 0 {
+		logger.Warnf("Ignoring invalid PIXERVE_WRITER_IDLE_TIMEOUT value: %s", env)
+		return DefaultWriterIdleTimeout
+	}
+	return timeout
+}
@@ -1,7 +1,7 @@
 package credentials
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"pixerve/logger"
 
@@ -10,6 +10,9 @@ import (
 
 var db *pebble.DB
 
+// ErrNotFound is returned when no credentials are registered under a key
+var ErrNotFound = errors.New("credentials not found")
+
 // OpenDB opens the Pebble DB for credentials at the specified path
 func OpenDB(dbPath string) error {
 	var err error
@@ -30,6 +33,9 @@ func CloseDB() error {
 
 }
 
+// GetCredentials returns the credentials map stored under the given key.
+// Encrypted records are decrypted transparently with the configured master key.
+// Returns ErrNotFound if the key has not been registered.
 func GetCredentials(key string) (map[string]string, error) {
 	if db == nil {
 		return nil, fmt.Errorf("credentials database not initialized")
@@ -37,37 +43,44 @@ func GetCredentials(key string) (map[string]string, error) {
 
 	value, closer, err := db.Get([]byte(key))
 	if err != nil {
+		if err == pebble.ErrNotFound {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	defer closer.Close()
-	creds := make(map[string]string)
-	err = json.Unmarshal(value, &creds)
-	if err != nil {
-		return nil, err
-	}
-	return creds, nil
+	return decodeValue(key, value)
 }
 
-// StoreCredentials stores the credentials map under the given key
+// StoreCredentials stores the credentials map under the given key.
+// The value is encrypted when a master key has been configured via SetMasterKey.
 func StoreCredentials(key string, creds map[string]string) error {
 	if db == nil {
 		return fmt.Errorf("credentials database not initialized")
 	}
 
-	encodedCreds, err := json.Marshal(creds)
+	encodedCreds, err := encodeValue(key, creds, masterKey)
 	if err != nil {
 		return err
 	}
 	return db.Set([]byte(key), encodedCreds, pebble.Sync)
 }
 
-// DeleteCredentials deletes the credentials for the given key
+// DeleteCredentials deletes the credentials and metadata for the given key
 func DeleteCredentials(key string) error {
 	if db == nil {
 		return fmt.Errorf("credentials database not initialized")
 	}
 
-	return db.Delete([]byte(key), pebble.Sync)
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Delete([]byte(key), nil); err != nil {
+		return err
+	}
+	if err := batch.Delete(metadataKey(key), nil); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
 }
 
 // CheckHealth performs a basic health check on the credentials database
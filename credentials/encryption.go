@@ -0,0 +1,267 @@
+package credentials
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"pixerve/config"
+	"pixerve/logger"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// encryptedPrefix marks a stored value as an encryption envelope rather than plaintext JSON
+var encryptedPrefix = []byte("pxenc:")
+
+// masterKey wraps the per-record data keys. nil means new records are stored unencrypted.
+var masterKey []byte
+
+// envelope is the at-rest format of an encrypted credentials record.
+// Each record gets its own random data key, which is sealed with the master key.
+// Both ciphertexts are nonce-prefixed AES-256-GCM and bound to the access key.
+type envelope struct {
+	Version    int    `json:"v"`
+	KeyID      string `json:"kid"` // identifies the master key that wrapped the data key
+	WrappedKey []byte `json:"wk"`  // data key sealed with the master key
+	Ciphertext []byte `json:"ct"`  // credentials JSON sealed with the data key
+}
+
+// SetMasterKey configures the key used to encrypt and decrypt stored credentials.
+// Must be called before the database is used concurrently. Passing nil disables encryption
+// of new records; existing encrypted records then become unreadable.
+func SetMasterKey(key []byte) error {
+	if key != nil && len(key) != config.MasterKeySize {
+		return fmt.Errorf("master key must be %d bytes, got %d", config.MasterKeySize, len(key))
+	}
+	masterKey = key
+	return nil
+}
+
+// keyID returns a short, non-secret fingerprint of a master key
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// seal encrypts plaintext with AES-256-GCM and returns nonce || ciphertext
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts a nonce || ciphertext value produced by seal
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// encodeValue serializes a credentials map for storage under key,
+// encrypting it with a fresh data key when withKey is non-nil
+func encodeValue(key string, creds map[string]string, withKey []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	if withKey == nil {
+		return plaintext, nil
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	ciphertext, err := seal(dataKey, plaintext, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+	wrappedKey, err := seal(withKey, dataKey, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	encoded, err := json.Marshal(envelope{
+		Version:    1,
+		KeyID:      keyID(withKey),
+		WrappedKey: wrappedKey,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, encryptedPrefix...), encoded...), nil
+}
+
+// decodeValue parses a stored credentials value, decrypting it if it is an envelope
+func decodeValue(key string, value []byte) (map[string]string, error) {
+	plaintext := value
+	if bytes.HasPrefix(value, encryptedPrefix) {
+		var env envelope
+		if err := json.Unmarshal(value[len(encryptedPrefix):], &env); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal credentials envelope: %w", err)
+		}
+		if masterKey == nil {
+			return nil, fmt.Errorf("credentials are encrypted but no master key is configured")
+		}
+		if env.KeyID != keyID(masterKey) {
+			return nil, fmt.Errorf("credentials were encrypted with a different master key (kid %s)", env.KeyID)
+		}
+		dataKey, err := open(masterKey, env.WrappedKey, []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+		}
+		plaintext, err = open(dataKey, env.Ciphertext, []byte(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
+		}
+	}
+
+	creds := make(map[string]string)
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// isCredentialsKey reports whether a raw DB key holds a credentials record
+func isCredentialsKey(key []byte) bool {
+	return !strings.HasPrefix(string(key), metadataPrefix)
+}
+
+// VerifyMasterKey checks that every encrypted record can be read with the configured master key.
+// It fails if encrypted records exist but no key is configured, or if any record was wrapped
+// with a different key. Intended to run once at startup so the server refuses to start
+// instead of failing jobs later.
+func VerifyMasterKey() error {
+	if db == nil {
+		return fmt.Errorf("credentials database not initialized")
+	}
+
+	iter, err := db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	encrypted, plaintext := 0, 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		if !isCredentialsKey(iter.Key()) {
+			continue
+		}
+		value := iter.Value()
+		if !bytes.HasPrefix(value, encryptedPrefix) {
+			plaintext++
+			continue
+		}
+		encrypted++
+
+		if masterKey == nil {
+			return fmt.Errorf("credentials database contains encrypted records but no master key is configured")
+		}
+		var env envelope
+		if err := json.Unmarshal(value[len(encryptedPrefix):], &env); err != nil {
+			return fmt.Errorf("failed to unmarshal credentials envelope for %s: %w", iter.Key(), err)
+		}
+		if env.KeyID != keyID(masterKey) {
+			return fmt.Errorf("credentials for %s were encrypted with a different master key (kid %s, configured %s)",
+				iter.Key(), env.KeyID, keyID(masterKey))
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return fmt.Errorf("iteration error: %w", err)
+	}
+
+	if masterKey != nil && plaintext > 0 {
+		logger.Warnf("%d credentials records are stored unencrypted; run `pixerve rekey` to encrypt them", plaintext)
+	}
+	logger.Debugf("Credentials encryption check passed: %d encrypted, %d plaintext", encrypted, plaintext)
+	return nil
+}
+
+// Reencrypt rewrites every credentials record with newKey and makes it the active master key.
+// Records are decrypted with the currently configured key; plaintext records are encrypted.
+// All records are rewritten in a single batch, so a failure leaves the database unchanged.
+// Intended for offline key rotation while the server is stopped.
+func Reencrypt(newKey []byte) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("credentials database not initialized")
+	}
+	if len(newKey) != config.MasterKeySize {
+		return 0, fmt.Errorf("master key must be %d bytes, got %d", config.MasterKeySize, len(newKey))
+	}
+
+	iter, err := db.NewIter(&pebble.IterOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create iterator: %w", err)
+	}
+
+	batch := db.NewBatch()
+	defer batch.Close()
+
+	count := 0
+	for iter.First(); iter.Valid(); iter.Next() {
+		if !isCredentialsKey(iter.Key()) {
+			continue
+		}
+		key := string(iter.Key())
+		creds, err := decodeValue(key, iter.Value())
+		if err != nil {
+			iter.Close()
+			return 0, fmt.Errorf("failed to read credentials for %s: %w", key, err)
+		}
+		value, err := encodeValue(key, creds, newKey)
+		if err != nil {
+			iter.Close()
+			return 0, fmt.Errorf("failed to re-encrypt credentials for %s: %w", key, err)
+		}
+		if err := batch.Set([]byte(key), value, nil); err != nil {
+			iter.Close()
+			return 0, err
+		}
+		count++
+	}
+
+	if err := iter.Error(); err != nil {
+		iter.Close()
+		return 0, fmt.Errorf("iteration error: %w", err)
+	}
+	if err := iter.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := batch.Commit(pebble.Sync); err != nil {
+		return 0, fmt.Errorf("failed to commit re-encrypted credentials: %w", err)
+	}
+
+	masterKey = newKey
+	return count, nil
+}
@@ -0,0 +1,170 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// metadataPrefix namespaces metadata records so they never collide with access keys
+const metadataPrefix = "__meta__:"
+
+// Metadata describes a registered credential bundle without exposing any of its values.
+// It is safe to return from API endpoints.
+type Metadata struct {
+	AccessKey string    `json:"access_key"`
+	Owner     string    `json:"owner"`  // JWT subject allowed to reference this key
+	Type      string    `json:"type"`   // backend type, e.g. "s3", "gcs", "sftp"
+	Fields    []string  `json:"fields"` // names of the stored fields, never their values
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func metadataKey(key string) []byte {
+	return []byte(metadataPrefix + key)
+}
+
+// updateMu serializes UpdateCredentials so concurrent updates of a bundle are not lost
+var updateMu sync.Mutex
+
+// RegisterCredentials stores the credentials map and its metadata under the given key in one batch.
+// Metadata.AccessKey and Metadata.Fields are filled in from the arguments.
+func RegisterCredentials(key string, meta Metadata, creds map[string]string) error {
+	if db == nil {
+		return fmt.Errorf("credentials database not initialized")
+	}
+
+	meta.AccessKey = key
+	meta.Fields = make([]string, 0, len(creds))
+	for field := range creds {
+		meta.Fields = append(meta.Fields, field)
+	}
+	sort.Strings(meta.Fields)
+
+	encodedCreds, err := encodeValue(key, creds, masterKey)
+	if err != nil {
+		return err
+	}
+	encodedMeta, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set([]byte(key), encodedCreds, nil); err != nil {
+		return err
+	}
+	if err := batch.Set(metadataKey(key), encodedMeta, nil); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// UpdateCredentials applies update to the credentials stored under key and stores the result,
+// keeping the field names in the key's metadata in sync. Updates are serialized, so update
+// always sees the latest stored bundle. Returns ErrNotFound if the key has not been registered.
+func UpdateCredentials(key string, update func(creds map[string]string) error) error {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	creds, err := GetCredentials(key)
+	if err != nil {
+		return err
+	}
+	if err := update(creds); err != nil {
+		return err
+	}
+
+	encodedCreds, err := encodeValue(key, creds, masterKey)
+	if err != nil {
+		return err
+	}
+
+	batch := db.NewBatch()
+	defer batch.Close()
+	if err := batch.Set([]byte(key), encodedCreds, nil); err != nil {
+		return err
+	}
+
+	meta, err := GetMetadata(key)
+	switch {
+	case err == nil:
+		meta.Fields = make([]string, 0, len(creds))
+		for field := range creds {
+			meta.Fields = append(meta.Fields, field)
+		}
+		sort.Strings(meta.Fields)
+		encodedMeta, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if err := batch.Set(metadataKey(key), encodedMeta, nil); err != nil {
+			return err
+		}
+	case err != ErrNotFound:
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// GetMetadata returns the metadata for the given key.
+// Returns ErrNotFound if the key has not been registered with metadata.
+func GetMetadata(key string) (*Metadata, error) {
+	if db == nil {
+		return nil, fmt.Errorf("credentials database not initialized")
+	}
+
+	value, closer, err := db.Get(metadataKey(key))
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer closer.Close()
+
+	var meta Metadata
+	if err := json.Unmarshal(value, &meta); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal credentials metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// ListMetadata returns metadata for all registered keys.
+// If owner is non-empty only keys owned by that subject are returned.
+func ListMetadata(owner string) ([]Metadata, error) {
+	if db == nil {
+		return nil, fmt.Errorf("credentials database not initialized")
+	}
+
+	iter, err := db.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(metadataPrefix),
+		UpperBound: []byte(metadataPrefix + "\xff"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	list := make([]Metadata, 0)
+	for iter.First(); iter.Valid(); iter.Next() {
+		var meta Metadata
+		if err := json.Unmarshal(iter.Value(), &meta); err != nil {
+			continue // Skip invalid records
+		}
+		if owner != "" && meta.Owner != owner {
+			continue
+		}
+		list = append(list, meta)
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("iteration error: %w", err)
+	}
+
+	return list, nil
+}
@@ -34,6 +34,8 @@ func EncodeCopy(ctx context.Context, input, output string, opts EncodeOptions) e
 
 // RegisterCopy registers the copy encoder (no command dependency)
 func RegisterCopy() {
+	registryMu.Lock()
 	Registry["copy"] = EncodeCopy
+	registryMu.Unlock()
 	logger.Debugf("encoder [copy] registered (no command required)")
 }
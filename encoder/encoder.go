@@ -4,6 +4,7 @@ import (
 	"context"
 	"os/exec"
 	"pixerve/logger"
+	"sync"
 )
 
 // EncodeFunc is the function signature for any encoder
@@ -18,24 +19,41 @@ type EncodeOptions struct {
 // Registry maps format name → encoder function
 var Registry = map[string]EncodeFunc{}
 
-// Register adds encoder if the underlying command exists, logs status
+// registryMu guards Registry and NativeRegistry, which HTTP transform requests read
+// while jobs run
+var registryMu sync.RWMutex
+
+// Register adds encoder if the underlying command exists, logs status.
+// For formats with a native encoder the command is only a fallback, so its absence is not a warning.
 func Register(format string, cmdName string, fn EncodeFunc) {
 	if _, err := exec.LookPath(cmdName); err != nil {
+		if _, ok := GetNative(format); ok {
+			logger.Debugf("command fallback for encoder [%s] unavailable: '%s' not found in PATH", format, cmdName)
+			return
+		}
 		logger.Warnf("encoder [%s] skipped: command '%s' not found in PATH", format, cmdName)
 		return
 	}
+	registryMu.Lock()
 	Registry[format] = fn
+	registryMu.Unlock()
 	logger.Debugf("encoder [%s] registered (command: %s)", format, cmdName)
 }
 
 // Lookup encoder by format
 func Get(format string) (EncodeFunc, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
 	fn, ok := Registry[format]
 	return fn, ok
 }
 
-// Explicit defaults registration
+// Explicit defaults registration, done once at startup.
+// Native encoders are registered first; command encoders serve formats without a native path
+// and sources the native decoders cannot read.
 func RegisterDefaults() {
+	RegisterNative("jpg", EncodeJPGNative)
+	RegisterNative("png", EncodePNGNative)
 	Register("jpg", "magick", EncodeJPG)
 	Register("png", "magick", EncodePNG)
 	Register("webp", "cwebp", EncodeWebP)
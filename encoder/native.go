@@ -0,0 +1,71 @@
+package encoder
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"pixerve/logger"
+
+	// Additional decoders for source images; jpeg and png are registered by the imports above
+	_ "image/gif"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// NativeEncodeFunc encodes an already decoded and resized image in-process
+type NativeEncodeFunc func(w io.Writer, img image.Image, opts EncodeOptions) error
+
+// NativeRegistry maps format name → in-process encoder.
+// Formats listed here are produced by the Pipeline without spawning external commands.
+var NativeRegistry = map[string]NativeEncodeFunc{}
+
+// RegisterNative adds an in-process encoder for format
+func RegisterNative(format string, fn NativeEncodeFunc) {
+	registryMu.Lock()
+	NativeRegistry[format] = fn
+	registryMu.Unlock()
+	logger.Debugf("encoder [%s] registered (native)", format)
+}
+
+// GetNative looks up an in-process encoder by format
+func GetNative(format string) (NativeEncodeFunc, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := NativeRegistry[format]
+	return fn, ok
+}
+
+// EncodeJPGNative encodes img as baseline JPEG.
+// Transparent areas are flattened onto white, since JPEG has no alpha channel.
+func EncodeJPGNative(w io.Writer, img image.Image, o EncodeOptions) error {
+	quality := o.Quality
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	} else if quality > 100 {
+		quality = 100
+	}
+	return jpeg.Encode(w, flatten(img), &jpeg.Options{Quality: quality})
+}
+
+// EncodePNGNative encodes img as PNG. Quality and Speed do not apply to lossless output.
+func EncodePNGNative(w io.Writer, img image.Image, o EncodeOptions) error {
+	enc := png.Encoder{CompressionLevel: png.DefaultCompression}
+	return enc.Encode(w, img)
+}
+
+// flatten composites img onto an opaque white background unless it is already opaque
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Over)
+	return dst
+}
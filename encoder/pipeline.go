@@ -0,0 +1,152 @@
+package encoder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"sync"
+
+	"pixerve/logger"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Pipeline encodes one source image into many sizes and formats.
+// The source is decoded at most once and each distinct target size is resampled at most once,
+// then shared by every native encoder that asks for it. Formats without a native encoder,
+// and sources the native decoders cannot read, fall back to the command encoders in Registry.
+type Pipeline struct {
+	input string
+
+	mu        sync.Mutex
+	decoded   bool
+	source    image.Image
+	decodeErr error
+	sizes     map[image.Point]image.Image // target size -> resampled image
+}
+
+// NewPipeline creates a pipeline for the image at input. Decoding is deferred until
+// the first native encode, so jobs that only use command encoders never pay for it.
+func NewPipeline(input string) *Pipeline {
+	return &Pipeline{
+		input: input,
+		sizes: make(map[image.Point]image.Image),
+	}
+}
+
+// Encode writes the source image to output in format, resized to fit opts.Width x opts.Height
+func (p *Pipeline) Encode(ctx context.Context, format, output string, opts EncodeOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	native, hasNative := GetNative(format)
+	command, hasCommand := Get(format)
+
+	if hasNative {
+		img, err := p.resized(opts.Width, opts.Height)
+		if err == nil {
+			return writeNative(output, native, img, opts)
+		}
+		if !hasCommand {
+			return err
+		}
+		logger.Debugf("native decode of %s failed (%v), falling back to command encoder [%s]", p.input, err, format)
+	}
+
+	if !hasCommand {
+		return fmt.Errorf("encoder %s not found", format)
+	}
+	return command(ctx, p.input, output, opts)
+}
+
+// decode reads and decodes the source image once, caching the result or error
+func (p *Pipeline) decode() (image.Image, error) {
+	if p.decoded {
+		return p.source, p.decodeErr
+	}
+	p.decoded = true
+
+	f, err := os.Open(p.input)
+	if err != nil {
+		p.decodeErr = err
+		return nil, err
+	}
+	defer f.Close()
+
+	img, format, err := image.Decode(bufio.NewReader(f))
+	if err != nil {
+		p.decodeErr = fmt.Errorf("failed to decode %s: %w", p.input, err)
+		return nil, p.decodeErr
+	}
+
+	logger.Debugf("decoded %s (%s, %dx%d)", p.input, format, img.Bounds().Dx(), img.Bounds().Dy())
+	p.source = img
+	return img, nil
+}
+
+// resized returns the source scaled to fit within width x height, reusing earlier results
+func (p *Pipeline) resized(width, height int) (image.Image, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	src, err := p.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	size := FitDimensions(bounds.Dx(), bounds.Dy(), width, height)
+	if size == bounds.Size() {
+		return src, nil
+	}
+	if img, ok := p.sizes[size]; ok {
+		return img, nil
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, xdraw.Src, nil)
+	p.sizes[size] = dst
+	return dst, nil
+}
+
+// FitDimensions returns the largest size with the source aspect ratio that fits within
+// width x height, matching ImageMagick's `-resize WxH`. A zero width or height is derived
+// from the other; if both are zero the source size is kept.
+func FitDimensions(srcWidth, srcHeight, width, height int) image.Point {
+	if srcWidth <= 0 || srcHeight <= 0 || (width <= 0 && height <= 0) {
+		return image.Pt(srcWidth, srcHeight)
+	}
+
+	// Scale by whichever bound is tighter
+	if height <= 0 || (width > 0 && width*srcHeight <= height*srcWidth) {
+		h := (srcHeight*width + srcWidth/2) / srcWidth
+		return image.Pt(width, max(h, 1))
+	}
+	w := (srcWidth*height + srcHeight/2) / srcHeight
+	return image.Pt(max(w, 1), height)
+}
+
+// writeNative encodes img to output, removing the partial file on failure
+func writeNative(output string, fn NativeEncodeFunc, img image.Image, opts EncodeOptions) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	err = fn(w, img, opts)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+		return err
+	}
+	return nil
+}
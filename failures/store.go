@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"pixerve/models"
+
 	pebble "github.com/cockroachdb/pebble"
 )
 
@@ -14,6 +16,8 @@ type FailureRecord struct {
 	Timestamp time.Time `json:"timestamp"`
 	Error     string    `json:"error"`
 	JobData   string    `json:"job_data"` // JSON string of the job instructions
+	// Outputs lists what the job wrote to storage backends before it stopped
+	Outputs []models.WrittenOutput `json:"outputs,omitempty"`
 }
 
 var db *pebble.DB
@@ -31,13 +35,21 @@ func Init(dbPath string) error {
 // Close closes the failure store
 func Close() error {
 	if db != nil {
-		return db.Close()
+		err := db.Close()
+		db = nil
+		return err
 	}
 	return nil
 }
 
 // StoreFailure stores a processing failure
 func StoreFailure(hash string, err error, jobData interface{}) error {
+	return StoreFailureWithOutputs(hash, err, jobData, nil)
+}
+
+// StoreFailureWithOutputs stores a processing failure along with the outputs the job had
+// already written, so partially delivered jobs can be cleaned up or reconciled
+func StoreFailureWithOutputs(hash string, err error, jobData interface{}, outputs []models.WrittenOutput) error {
 	if db == nil {
 		return fmt.Errorf("failure store not initialized")
 	}
@@ -58,6 +70,7 @@ func StoreFailure(hash string, err error, jobData interface{}) error {
 		Timestamp: time.Now(),
 		Error:     errorMsg,
 		JobData:   string(jobJSON),
+		Outputs:   outputs,
 	}
 
 	// Convert record to JSON
@@ -4,10 +4,12 @@ go 1.25.2
 
 require (
 	cloud.google.com/go/storage v1.57.0
+	github.com/aws/smithy-go v1.23.0
 	github.com/cockroachdb/pebble v1.1.5
 	github.com/go-jose/go-jose/v4 v4.1.3
 	github.com/pkg/sftp v1.13.9
 	golang.org/x/crypto v0.42.0
+	golang.org/x/image v0.31.0
 	google.golang.org/api v0.247.0
 )
 
@@ -68,7 +70,6 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/internal/presigned-url v1.13.9 // indirect
 	github.com/aws/aws-sdk-go-v2/service/internal/s3shared v1.19.9 // indirect
 	github.com/aws/aws-sdk-go-v2/service/s3 v1.88.4
-	github.com/aws/smithy-go v1.23.0 // indirect
 )
 
 require (
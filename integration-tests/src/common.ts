@@ -398,7 +398,8 @@ export class PixerveServer {
                 // Start the server
                 this.process = spawn('./pixerve', [], {
                     cwd: path.join(__dirname, '..'),
-                    stdio: [ 'ignore', 'pipe', 'pipe' ]
+                    stdio: [ 'ignore', 'pipe', 'pipe' ],
+                    env: { ...process.env, PIXERVE_JWT_SECRET: 'test-secret-key-for-jwt-signing-at-least-32-bytes-long' }
                 });
 
                 this.logger.debug('Server process spawned', { pid: this.process.pid });
@@ -127,7 +127,8 @@ class PixerveIntegrationTests {
                 // Start the server
                 this.pixerveProcess = spawn('./pixerve', [], {
                     cwd: path.join(__dirname, '..'),
-                    stdio: [ 'ignore', 'pipe', 'pipe' ]
+                    stdio: [ 'ignore', 'pipe', 'pipe' ],
+                    env: { ...process.env, PIXERVE_JWT_SECRET: 'test-secret-key-for-jwt-signing-at-least-32-bytes-long' }
                 });
 
                 if (this.pixerveProcess.stdout) {
@@ -0,0 +1,91 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+
+	"pixerve/credentials"
+	"pixerve/models"
+	writerbackends "pixerve/writerBackends"
+)
+
+// ErrStorageKeyNotOwned is returned when a JWT references a storage key registered to another subject
+var ErrStorageKeyNotOwned = errors.New("storage key is not owned by subject")
+
+// AuthorizeStorageKeys checks that every storage key referenced in a job spec is registered
+// to the given JWT subject and for the backend type it is used with, and that the type is a
+// registered writer backend. Called at upload time so jobs referencing foreign or unknown keys
+// or backends are never queued.
+func AuthorizeStorageKeys(subject string, storageKeys map[string]string) error {
+	for storageType, storageKey := range storageKeys {
+		if err := writerbackends.ValidateStorageType(storageType); err != nil {
+			return fmt.Errorf("%s backend: %w", storageType, err)
+		}
+
+		meta, err := credentials.GetMetadata(storageKey)
+		if err != nil {
+			if errors.Is(err, credentials.ErrNotFound) {
+				return fmt.Errorf("%s backend: storage key %s is not registered: %w", storageType, storageKey, err)
+			}
+			return fmt.Errorf("%s backend: failed to load metadata for storage key %s: %w", storageType, storageKey, err)
+		}
+
+		if meta.Owner != subject {
+			return fmt.Errorf("%s backend: storage key %s: %w", storageType, storageKey, ErrStorageKeyNotOwned)
+		}
+
+		if meta.Type != storageType {
+			return fmt.Errorf("%s backend: storage key %s is registered for %s", storageType, storageKey, meta.Type)
+		}
+	}
+	return nil
+}
+
+// ResolveWriterJobs looks up the credential bundle registered for each writer job's
+// storage key and returns copies of the writer jobs with the bundle merged into their credentials.
+//
+// The storage key stays under "key" in the merged map; bundle fields are layered on top.
+// Resolution happens at processing time so secrets are never written to instructions.json.
+// An error is returned for the first writer whose key is unknown or whose bundle is
+// missing fields required by its backend type; those errors are marked Permanent.
+func ResolveWriterJobs(writerJobs []models.WriterJob) ([]models.WriterJob, error) {
+	resolved := make([]models.WriterJob, 0, len(writerJobs))
+
+	for _, writerJob := range writerJobs {
+		merged := make(map[string]string, len(writerJob.Credentials))
+		for k, v := range writerJob.Credentials {
+			merged[k] = v
+		}
+
+		// directServe is configured by the server and has no credential bundle
+		if writerJob.Type != "directServe" {
+			storageKey := writerJob.Credentials["key"]
+			if storageKey == "" {
+				return nil, Permanent(fmt.Errorf("%s backend: no storage key provided", writerJob.Type))
+			}
+
+			bundle, err := credentials.GetCredentials(storageKey)
+			if err != nil {
+				if errors.Is(err, credentials.ErrNotFound) {
+					return nil, Permanent(fmt.Errorf("%s backend: storage key %s is not registered: %w", writerJob.Type, storageKey, err))
+				}
+				return nil, fmt.Errorf("%s backend: failed to load credentials for storage key %s: %w", writerJob.Type, storageKey, err)
+			}
+
+			for k, v := range bundle {
+				merged[k] = v
+			}
+		}
+
+		if err := writerbackends.ValidateAccessInfo(writerJob.Type, merged); err != nil {
+			return nil, Permanent(fmt.Errorf("%s backend: %w", writerJob.Type, err))
+		}
+
+		resolved = append(resolved, models.WriterJob{
+			Type:        writerJob.Type,
+			Credentials: merged,
+		})
+	}
+
+	return resolved, nil
+}
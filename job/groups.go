@@ -0,0 +1,241 @@
+package job
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"pixerve/logger"
+	"pixerve/taskQueue"
+)
+
+// Aggregate group states reported by GetGroupStatus
+const (
+	GroupStateUploading  = "uploading"  // the batch upload is still being received
+	GroupStatePending    = "pending"    // no member has started yet
+	GroupStateProcessing = "processing" // some members have not finished
+	GroupStateCompleted  = "completed"  // every member completed
+	GroupStatePartial    = "partial"    // some members completed and some did not
+	GroupStateFailed     = "failed"     // no member completed
+)
+
+// GroupStatus is the aggregate status of a job group
+type GroupStatus struct {
+	ID          string           `json:"group"`
+	State       string           `json:"state"`
+	Total       int              `json:"total"`
+	Counts      map[string]int   `json:"counts"` // number of members per job status
+	Jobs        []GroupJobStatus `json:"jobs"`
+	CompletedAt *time.Time       `json:"completed_at,omitempty"`
+}
+
+// GroupJobStatus is the status of one member job of a group
+type GroupJobStatus struct {
+	Hash     string `json:"hash"`
+	Filename string `json:"filename"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+}
+
+// CreateGroup starts a job group for a batch upload and returns its ID.
+// callbackURL and callbackHeaders are used for the single group-level completion callback;
+// member jobs do not send their own.
+func CreateGroup(subject, callbackURL string, callbackHeaders map[string]string) (string, error) {
+	q, err := queue()
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate group ID: %w", err)
+	}
+
+	rec, err := q.CreateGroup(taskQueue.GroupRecord{
+		ID:              hex.EncodeToString(b),
+		Subject:         subject,
+		CallbackURL:     callbackURL,
+		CallbackHeaders: callbackHeaders,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create group: %w", err)
+	}
+	logger.Infof("Created job group %s for subject %s", rec.ID, subject)
+	return rec.ID, nil
+}
+
+// AddPendingGroupJob is AddPendingJob for a member of a group created with CreateGroup.
+// filename is the uploaded file the job was created from, reported in the group status,
+// and subject is the JWT subject that uploaded it.
+func AddPendingGroupJob(dir string, priority int, groupID, filename, subject string) error {
+	q, err := queue()
+	if err != nil {
+		return err
+	}
+
+	hash := filepath.Base(dir)
+	if err := q.AddGroupMember(groupID, taskQueue.GroupMember{Hash: hash, Filename: filename}); err != nil {
+		return fmt.Errorf("failed to add job %s to group %s: %w", hash, groupID, err)
+	}
+	if _, err := q.EnqueueFor(hash, dir, priority, groupID, subject); err != nil {
+		if removeErr := q.RemoveGroupMember(groupID, hash); removeErr != nil {
+			logger.Errorf("Failed to remove job %s from group %s: %v", hash, groupID, removeErr)
+		}
+		return fmt.Errorf("failed to enqueue job %s: %w", hash, err)
+	}
+
+	notifyScheduler()
+	return nil
+}
+
+// SealGroup marks a group as having all its members. The group completes, and its callback
+// is sent, once every member has finished; that may already be the case when sealing.
+func SealGroup(groupID string) error {
+	q, err := queue()
+	if err != nil {
+		return err
+	}
+	if err := q.SealGroup(groupID); err != nil {
+		return fmt.Errorf("failed to seal group %s: %w", groupID, err)
+	}
+	completeGroup(q, groupID)
+	return nil
+}
+
+// GetGroupStatus returns the aggregate status of a group
+func GetGroupStatus(groupID string) (GroupStatus, error) {
+	q, err := queue()
+	if err != nil {
+		return GroupStatus{}, err
+	}
+	rec, jobs, err := q.GetGroup(groupID)
+	if err != nil {
+		return GroupStatus{}, err
+	}
+	return buildGroupStatus(rec, jobs), nil
+}
+
+// GetGroupStatusFor returns the aggregate status of a group created by subject.
+// Groups of other subjects are reported as taskQueue.ErrGroupNotFound.
+func GetGroupStatusFor(groupID, subject string) (GroupStatus, error) {
+	q, err := queue()
+	if err != nil {
+		return GroupStatus{}, err
+	}
+	rec, jobs, err := q.GetGroup(groupID)
+	if err != nil {
+		return GroupStatus{}, err
+	}
+	if rec.Subject != subject {
+		return GroupStatus{}, taskQueue.ErrGroupNotFound
+	}
+	return buildGroupStatus(rec, jobs), nil
+}
+
+// buildGroupStatus aggregates the member job records of a group
+func buildGroupStatus(rec taskQueue.GroupRecord, jobs []taskQueue.JobRecord) GroupStatus {
+	byHash := make(map[string]taskQueue.JobRecord, len(jobs))
+	for _, job := range jobs {
+		byHash[job.Hash] = job
+	}
+
+	status := GroupStatus{
+		ID:     rec.ID,
+		Total:  len(rec.Members),
+		Counts: make(map[string]int),
+		Jobs:   make([]GroupJobStatus, 0, len(rec.Members)),
+	}
+	if !rec.CompletedAt.IsZero() {
+		completedAt := rec.CompletedAt
+		status.CompletedAt = &completedAt
+	}
+
+	finished := 0
+	for _, member := range rec.Members {
+		jobStatus := GroupJobStatus{Hash: member.Hash, Filename: member.Filename, State: "unknown"}
+		if job, ok := byHash[member.Hash]; ok {
+			jobStatus.State = string(job.Status)
+			jobStatus.Error = job.Error
+			if job.Status.Terminal() {
+				finished++
+			}
+		} else {
+			// The job record was purged, so it finished long ago
+			finished++
+		}
+		status.Counts[jobStatus.State]++
+		status.Jobs = append(status.Jobs, jobStatus)
+	}
+
+	completed := status.Counts[string(taskQueue.JobCompleted)]
+	switch {
+	case !rec.Sealed:
+		status.State = GroupStateUploading
+	case finished < status.Total && status.Counts[string(taskQueue.JobPending)] == status.Total:
+		status.State = GroupStatePending
+	case finished < status.Total:
+		status.State = GroupStateProcessing
+	case completed == 0:
+		status.State = GroupStateFailed
+	case completed == status.Total:
+		status.State = GroupStateCompleted
+	default:
+		status.State = GroupStatePartial
+	}
+	return status
+}
+
+// completeGroup completes a group if its last member just finished and sends the group callback.
+// Called whenever a member job reaches a terminal status; only the call that completes the
+// group sends the callback.
+func completeGroup(q *taskQueue.DBQueue, groupID string) {
+	rec, jobs, completed, err := q.CompleteGroup(groupID)
+	if err != nil {
+		if !errors.Is(err, taskQueue.ErrGroupNotFound) {
+			logger.Errorf("Failed to check completion of group %s: %v", groupID, err)
+		}
+		return
+	}
+	if !completed {
+		return
+	}
+
+	status := buildGroupStatus(rec, jobs)
+	logger.Infof("Job group %s finished: %s (%d jobs)", groupID, status.State, status.Total)
+	if err := sendGroupCallback(rec, status); err != nil {
+		logger.Errorf("Failed to send callback for group %s: %v", groupID, err)
+	}
+}
+
+// recoverGroups completes groups whose last member finished without completing the group,
+// e.g. because it was settled during lease recovery or the server stopped in between
+func recoverGroups(q *taskQueue.DBQueue) {
+	groups, err := q.ListOpenGroups()
+	if err != nil {
+		logger.Errorf("Failed to list open job groups: %v", err)
+		return
+	}
+	for _, group := range groups {
+		completeGroup(q, group.ID)
+	}
+}
+
+// sendGroupCallback sends the group-level completion callback if configured
+func sendGroupCallback(rec taskQueue.GroupRecord, status GroupStatus) error {
+	if rec.CallbackURL == "" {
+		return nil // No callback configured
+	}
+
+	payload := map[string]interface{}{
+		"group":     status.ID,
+		"status":    status.State,
+		"total":     status.Total,
+		"counts":    status.Counts,
+		"jobs":      status.Jobs,
+		"timestamp": time.Now().Unix(),
+	}
+	return postCallback(rec.CallbackURL, rec.CallbackHeaders, payload)
+}
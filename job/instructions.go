@@ -13,6 +13,11 @@ type JobInstructions struct {
 	OriginalFile string      `json:"original_file"` // Original filename
 	Hash         string      `json:"hash"`          // SHA256 hash
 	Job          combinedJob `json:"job"`           // The parsed job details
+
+	// Group is the job group of a batch upload, if any
+	Group string `json:"group,omitempty"`
+	// Subject is the JWT subject that enqueued the job and owns its outputs
+	Subject string `json:"subject,omitempty"`
 }
 
 // WriteInstructions writes the job instructions to instructions.json in the given directory
@@ -2,6 +2,9 @@ package job
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,6 +14,8 @@ import (
 	"time"
 
 	"pixerve/logger"
+	"pixerve/success"
+	"pixerve/taskQueue"
 )
 
 // JobState represents the current state of a job
@@ -22,15 +27,63 @@ const (
 	JobStateCompleted
 	JobStateFailed
 	JobStateCancelled
+	JobStateDeadLetter
+)
+
+const (
+	// leaseTTL is how long a worker holds a job without renewing its lease
+	leaseTTL = 2 * time.Minute
+	// pollInterval is how often idle workers check the queue when not woken by a new job
+	pollInterval = 1 * time.Second
 )
 
 var (
-	pendingJobs []string                              // slice of directory paths with pending jobs
-	activeJobs  = make(map[string]context.CancelFunc) // hash -> cancel function
-	jobStates   = make(map[string]JobState)           // hash -> job state
-	mu          sync.RWMutex
+	activeJobs = make(map[string]context.CancelCauseFunc) // hash -> cancel function
+	mu         sync.RWMutex
+
+	// instanceID identifies this server process as a lease owner.
+	// Leases held by any other ID belong to a previous run and are recovered at startup.
+	instanceID = newInstanceID()
+
+	// wake nudges the scheduler loop when a job is enqueued or a worker frees up
+	wake = make(chan struct{}, 1)
 )
 
+// newInstanceID returns a random lease owner ID for this process
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// queue returns the persistent job queue or an error if it has not been opened
+func queue() (*taskQueue.DBQueue, error) {
+	if taskQueue.ConvertQueue == nil {
+		return nil, fmt.Errorf("job queue not initialized")
+	}
+	return taskQueue.ConvertQueue, nil
+}
+
+// stateFromStatus maps a persisted queue status to a JobState
+func stateFromStatus(status taskQueue.JobStatus) JobState {
+	switch status {
+	case taskQueue.JobProcessing:
+		return JobStateProcessing
+	case taskQueue.JobCompleted:
+		return JobStateCompleted
+	case taskQueue.JobFailed:
+		return JobStateFailed
+	case taskQueue.JobCancelled:
+		return JobStateCancelled
+	case taskQueue.JobDeadLetter:
+		return JobStateDeadLetter
+	default:
+		return JobStatePending
+	}
+}
+
 // getMaxWorkers returns the maximum number of concurrent workers for job processing.
 // Configurable via PIXERVE_MAX_WORKERS environment variable.
 // Defaults to runtime.NumCPU() - 1 (minimum 1) to utilize available cores while leaving one for system processes.
@@ -59,121 +112,302 @@ func getMaxWorkers() int {
 	return defaultWorkers
 }
 
-// AddPendingJob adds a job directory to the pending list
-func AddPendingJob(dir string) {
+// getReservedRealtimeWorkers returns how many of maxWorkers are held back for realtime jobs.
+// Configurable via PIXERVE_REALTIME_WORKER_SHARE as a percentage of workers (default 25).
+// At least one worker is always left for queued jobs, so a single-worker server reserves none.
+func getReservedRealtimeWorkers(maxWorkers int) int {
+	share := 25
+	if env := os.Getenv("PIXERVE_REALTIME_WORKER_SHARE"); env != "" {
+		if value, err := strconv.Atoi(env); err == nil && value >= 0 && value <= 100 {
+			share = value
+		} else {
+			logger.Warnf("Invalid PIXERVE_REALTIME_WORKER_SHARE %q, using %d", env, share)
+		}
+	}
+
+	reserved := (maxWorkers*share + 99) / 100
+	if reserved > maxWorkers-1 {
+		reserved = maxWorkers - 1
+	}
+	return reserved
+}
+
+// getQueueAging returns how long a queued job may wait before it competes with realtime jobs.
+// Configurable via PIXERVE_QUEUE_AGING as a duration (default 1m); 0 disables aging.
+func getQueueAging() time.Duration {
+	const defaultAging = time.Minute
+	if env := os.Getenv("PIXERVE_QUEUE_AGING"); env != "" {
+		aging, err := time.ParseDuration(env)
+		if err == nil && aging >= 0 {
+			return aging
+		}
+		logger.Warnf("Invalid PIXERVE_QUEUE_AGING %q, using %v", env, defaultAging)
+	}
+	return defaultAging
+}
+
+// AddPendingJob persists a job directory in the queue as pending and wakes the scheduler.
+// The directory name is the job hash; priority follows models.JobSpec.Priority (0 = realtime, 1 = queued).
+func AddPendingJob(dir string, priority int) error {
+	return AddPendingJobFor(dir, priority, "")
+}
+
+// AddPendingJobFor is AddPendingJob for a job uploaded by the JWT subject, who alone may
+// see its status and cancel it
+func AddPendingJobFor(dir string, priority int, subject string) error {
+	q, err := queue()
+	if err != nil {
+		return err
+	}
+
 	hash := filepath.Base(dir)
-	mu.Lock()
-	defer mu.Unlock()
-	pendingJobs = append(pendingJobs, dir)
-	jobStates[hash] = JobStatePending
+	if _, err := q.EnqueueFor(hash, dir, priority, "", subject); err != nil {
+		return fmt.Errorf("failed to enqueue job %s: %w", hash, err)
+	}
+
+	notifyScheduler()
+	return nil
 }
 
-// RemovePendingJob removes a job directory from the pending list
+// notifyScheduler wakes the scheduler loop without blocking
+func notifyScheduler() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// RemovePendingJob removes a job that has not started yet from the queue
 func RemovePendingJob(dir string) {
-	mu.Lock()
-	defer mu.Unlock()
-	for i, p := range pendingJobs {
-		if p == dir {
-			pendingJobs = append(pendingJobs[:i], pendingJobs[i+1:]...)
-			break
-		}
+	q, err := queue()
+	if err != nil {
+		return
+	}
+	hash := filepath.Base(dir)
+	if err := q.Remove(hash); err != nil && !errors.Is(err, taskQueue.ErrJobNotFound) {
+		logger.Warnf("Failed to remove pending job %s: %v", hash, err)
 	}
 }
 
-// GetPendingJobs returns a copy of the pending jobs list
+// GetPendingJobs returns the directories of all jobs waiting to be processed
 func GetPendingJobs() []string {
-	mu.RLock()
-	defer mu.RUnlock()
-	// Return a copy to avoid race conditions
-	jobs := make([]string, len(pendingJobs))
-	copy(jobs, pendingJobs)
+	q, err := queue()
+	if err != nil {
+		return nil
+	}
+	records, err := q.ListJobs(taskQueue.JobPending)
+	if err != nil {
+		logger.Errorf("Failed to list pending jobs: %v", err)
+		return nil
+	}
+	jobs := make([]string, 0, len(records))
+	for _, rec := range records {
+		jobs = append(jobs, rec.Dir)
+	}
 	return jobs
 }
 
-// CancelJob cancels a running job by hash
-func CancelJob(hash string) error {
-	mu.Lock()
-	defer mu.Unlock()
-
-	state, exists := jobStates[hash]
-	if !exists {
-		return fmt.Errorf("job with hash %s not found", hash)
-	}
-
-	switch state {
-	case JobStateCompleted:
-		return fmt.Errorf("job with hash %s is already completed", hash)
-	case JobStateFailed:
-		return fmt.Errorf("job with hash %s has already failed", hash)
-	case JobStateCancelled:
-		return fmt.Errorf("job with hash %s is already cancelled", hash)
-	case JobStateProcessing:
-		return fmt.Errorf("job with hash %s is currently processing and cannot be cancelled", hash)
-	case JobStatePending:
-		// Allow cancellation of pending jobs
-		cancel, exists := activeJobs[hash]
-		if !exists {
-			return fmt.Errorf("job with hash %s is pending but not active", hash)
+// ErrJobCancelled is the cancellation cause of jobs stopped through CancelJob
+var ErrJobCancelled = errors.New("job cancelled by request")
+
+// cancelRequest is the context cancellation cause passed to a running job by CancelJob
+type cancelRequest struct {
+	deleteOutputs bool // remove outputs the job already wrote to storage backends
+}
+
+func (c *cancelRequest) Error() string { return ErrJobCancelled.Error() }
+func (c *cancelRequest) Unwrap() error { return ErrJobCancelled }
+
+// CancelJob cancels a job by hash and returns the state it was in.
+// A pending job is cancelled immediately and its files removed. A processing job is stopped:
+// running encoders are killed and uploads in progress aborted, and if deleteOutputs is set the
+// outputs it already wrote to storage backends are deleted. The job settles as cancelled
+// shortly after this returns; its status then lists the outputs it wrote.
+func CancelJob(hash string, deleteOutputs bool) (JobState, error) {
+	q, err := queue()
+	if err != nil {
+		return 0, err
+	}
+
+	rec, err := q.CancelPending(hash)
+	if errors.Is(err, taskQueue.ErrJobNotPending) && rec.Status == taskQueue.JobProcessing {
+		rec, err = q.RequestCancel(hash)
+		if err == nil {
+			signalCancel(hash, deleteOutputs)
+			logger.Infof("Cancellation requested for processing job %s", hash)
+			return JobStateProcessing, nil
 		}
-		cancel()
-		delete(activeJobs, hash)
-		jobStates[hash] = JobStateCancelled
-		return nil
-	default:
-		return fmt.Errorf("job with hash %s is in unknown state", hash)
+		if errors.Is(err, taskQueue.ErrJobNotProcessing) {
+			// The job finished in the meantime
+			err = taskQueue.ErrJobNotPending
+		}
+	}
+	if errors.Is(err, taskQueue.ErrJobNotFound) {
+		return 0, fmt.Errorf("job with hash %s not found", hash)
+	}
+	if errors.Is(err, taskQueue.ErrJobNotPending) {
+		state := stateFromStatus(rec.Status)
+		switch rec.Status {
+		case taskQueue.JobCompleted:
+			return state, fmt.Errorf("job with hash %s is already completed", hash)
+		case taskQueue.JobFailed:
+			return state, fmt.Errorf("job with hash %s has already failed", hash)
+		case taskQueue.JobCancelled:
+			return state, fmt.Errorf("job with hash %s is already cancelled", hash)
+		case taskQueue.JobDeadLetter:
+			return state, fmt.Errorf("job with hash %s has exhausted its retries", hash)
+		default:
+			return state, fmt.Errorf("job with hash %s is in unknown state", hash)
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel job %s: %w", hash, err)
+	}
+
+	// The job will never run, so its uploaded files are no longer needed
+	if err := os.RemoveAll(rec.Dir); err != nil {
+		logger.Errorf("Failed to cleanup cancelled job directory %s: %v", rec.Dir, err)
+	}
+	if rec.Group != "" {
+		completeGroup(q, rec.Group)
+	}
+	return JobStatePending, nil
+}
+
+// CancelJobFor is CancelJob for the JWT subject that uploaded the job.
+// Jobs of other subjects, and jobs without a known subject, are reported as not found.
+func CancelJobFor(hash, subject string, deleteOutputs bool) (JobState, error) {
+	if _, _, exists := GetJobDetailsFor(hash, subject); !exists {
+		return 0, fmt.Errorf("job with hash %s not found", hash)
+	}
+	return CancelJob(hash, deleteOutputs)
+}
+
+// signalCancel cancels the context of a job running in this process.
+// A job that has been leased but not yet registered picks up the persisted request in processJob.
+func signalCancel(hash string, deleteOutputs bool) {
+	mu.RLock()
+	cancel, ok := activeJobs[hash]
+	mu.RUnlock()
+	if ok {
+		cancel(&cancelRequest{deleteOutputs: deleteOutputs})
 	}
 }
 
 // GetJobState returns the current state of a job
 func GetJobState(hash string) (JobState, bool) {
-	mu.RLock()
-	defer mu.RUnlock()
-	state, exists := jobStates[hash]
+	state, _, exists := GetJobDetails(hash)
 	return state, exists
 }
 
+// GetJobDetails returns the current state of a job along with its persisted record,
+// which holds the last error and the outputs written by a cancelled or failed attempt
+func GetJobDetails(hash string) (JobState, taskQueue.JobRecord, bool) {
+	q, err := queue()
+	if err != nil {
+		return 0, taskQueue.JobRecord{}, false
+	}
+	rec, err := q.GetJob(hash)
+	if err != nil {
+		if !errors.Is(err, taskQueue.ErrJobNotFound) {
+			logger.Errorf("Failed to load job %s: %v", hash, err)
+		}
+		return 0, taskQueue.JobRecord{}, false
+	}
+	return stateFromStatus(rec.Status), rec, true
+}
+
+// GetJobDetailsFor is GetJobDetails for the JWT subject that uploaded the job.
+// Jobs of other subjects, and jobs without a known subject, do not exist for the caller.
+func GetJobDetailsFor(hash, subject string) (JobState, taskQueue.JobRecord, bool) {
+	state, rec, exists := GetJobDetails(hash)
+	if !exists || rec.Subject == "" || rec.Subject != subject {
+		return 0, taskQueue.JobRecord{}, false
+	}
+	return state, rec, true
+}
+
 // IsJobCancellable checks if a job can be cancelled
 func IsJobCancellable(hash string) bool {
-	mu.RLock()
-	defer mu.RUnlock()
-	state, exists := jobStates[hash]
-	return exists && state == JobStatePending
+	state, exists := GetJobState(hash)
+	return exists && (state == JobStatePending || state == JobStateProcessing)
 }
 
-// ScanForPendingJobs scans the temp directory for job folders with instructions.json
-func ScanForPendingJobs() error {
-	tempDir := os.TempDir()
-	entries, err := os.ReadDir(tempDir)
+// RecoverPendingJobs prepares the persisted queue after a restart.
+// Jobs that were processing when the previous run stopped are returned to the pending state
+// so exactly the unfinished jobs resume. Pending jobs whose directory has disappeared are
+// settled instead of retried: completed if a success record exists (the previous run crashed
+// between finishing and acknowledging the job), failed otherwise.
+func RecoverPendingJobs() error {
+	q, err := queue()
 	if err != nil {
 		return err
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
+	recovered, err := q.RecoverLeases(instanceID)
+	if err != nil {
+		return fmt.Errorf("failed to recover job leases: %w", err)
+	}
+	if recovered > 0 {
+		logger.Infof("Recovered %d interrupted jobs", recovered)
+	}
+
+	records, err := q.ListJobs(taskQueue.JobPending)
+	if err != nil {
+		return fmt.Errorf("failed to list pending jobs: %w", err)
+	}
+
+	for _, rec := range records {
+		if _, err := os.Stat(filepath.Join(rec.Dir, "instructions.json")); err == nil {
 			continue
 		}
-		dirPath := filepath.Join(tempDir, entry.Name())
-		instrPath := filepath.Join(dirPath, "instructions.json")
-		if _, err := os.Stat(instrPath); err == nil {
-			// instructions.json exists, add to pending
-			AddPendingJob(dirPath)
+
+		status, errMsg := taskQueue.JobFailed, "job directory missing after restart"
+		if record, err := success.GetSuccess(rec.Hash); err == nil && record != nil {
+			status, errMsg = taskQueue.JobCompleted, ""
+		}
+		logger.Warnf("Job %s has no instructions in %s, marking %s", rec.Hash, rec.Dir, status)
+		if err := q.Finish(rec.Hash, status, errMsg); err != nil {
+			logger.Errorf("Failed to settle job %s: %v", rec.Hash, err)
 		}
 	}
+
+	// Groups whose last member settled above or before the restart still owe their callback
+	recoverGroups(q)
+
+	logger.Infof("%d jobs pending after recovery", len(GetPendingJobs()))
 	return nil
 }
 
-// processJob processes a single job directory
-func processJob(jobDir string) error {
-	// Extract hash from job directory path
-	hash := filepath.Base(jobDir)
+// keepLease renews the lease on a job until stop is closed.
+// If the lease is lost the job is cancelled, since another worker may now own it.
+func keepLease(q *taskQueue.DBQueue, hash string, cancel context.CancelCauseFunc, stop <-chan struct{}) {
+	ticker := time.NewTicker(leaseTTL / 3)
+	defer ticker.Stop()
 
-	// Mark job as processing
-	mu.Lock()
-	jobStates[hash] = JobStateProcessing
-	mu.Unlock()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := q.ExtendLease(hash, instanceID, leaseTTL); err != nil {
+				logger.Errorf("Failed to renew lease on job %s: %v", hash, err)
+				if errors.Is(err, taskQueue.ErrLeaseLost) {
+					cancel(err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// processJob processes a single leased job and acknowledges its outcome
+func processJob(q *taskQueue.DBQueue, rec taskQueue.JobRecord) error {
+	hash := rec.Hash
 
 	// Create context with cancellation
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
 	// Register the cancel function
 	mu.Lock()
@@ -187,84 +421,256 @@ func processJob(jobDir string) error {
 		mu.Unlock()
 	}()
 
-	err := ProcessJob(ctx, jobDir)
+	// Honour a cancellation requested between leasing and registering the job
+	if current, err := q.GetJob(hash); err == nil && current.CancelRequested {
+		cancel(&cancelRequest{})
+	}
 
-	// Mark job as completed or failed
-	mu.Lock()
+	// Hold the lease for as long as the job runs
+	stopLease := make(chan struct{})
+	leaseDone := make(chan struct{})
+	go func() {
+		defer close(leaseDone)
+		keepLease(q, hash, cancel, stopLease)
+	}()
+
+	err := ProcessJob(ctx, rec.Dir)
+
+	close(stopLease)
+	<-leaseDone
+
+	settleJob(q, rec, err, errors.Is(context.Cause(ctx), ErrJobCancelled))
+	return err
+}
+
+// settleJob records the outcome of an attempt:
+// - success and cancellation are acknowledged as such; cancelled jobs are recorded as failures
+// - permanent failures are acknowledged as failed, recorded and their files removed
+// - retryable failures are retried with exponential backoff until the attempt limit
+// - jobs that exhaust their attempts are dead-lettered, keeping their files for a requeue
+func settleJob(q *taskQueue.DBQueue, rec taskQueue.JobRecord, err error, cancelled bool) {
+	hash := rec.Hash
+	var status taskQueue.JobStatus
+	errMsg := ""
+
+	switch {
+	case err == nil:
+		status = taskQueue.JobCompleted
+	case cancelled:
+		status, errMsg = taskQueue.JobCancelled, err.Error()
+	case !IsRetryable(err):
+		status, errMsg = taskQueue.JobFailed, err.Error()
+	case rec.Attempts >= getMaxAttempts():
+		status, errMsg = taskQueue.JobDeadLetter, err.Error()
+	default:
+		base, maxDelay := getRetryDelays()
+		delay := BackoffDelay(rec.Attempts, base, maxDelay)
+		if retryErr := q.Retry(hash, instanceID, err.Error(), time.Now().Add(delay)); retryErr != nil {
+			logger.Errorf("Failed to schedule retry of job %s: %v", hash, retryErr)
+			return
+		}
+		logger.Warnf("Job %s failed on attempt %d, retrying in %v: %v", hash, rec.Attempts, delay.Round(time.Millisecond), err)
+		return
+	}
+
+	if ackErr := q.AckWithOutputs(hash, instanceID, status, errMsg, jobOutputs(err)); ackErr != nil {
+		logger.Errorf("Failed to acknowledge job %s as %s: %v", hash, status, ackErr)
+		return
+	}
+
+	switch status {
+	case taskQueue.JobCancelled:
+		recordFailure(hash, err)
+		logger.Infof("Job %s cancelled while processing", hash)
+	case taskQueue.JobFailed:
+		recordFailure(hash, err)
+		if err := os.RemoveAll(rec.Dir); err != nil {
+			logger.Errorf("Failed to cleanup failed job directory %s: %v", rec.Dir, err)
+		}
+	case taskQueue.JobDeadLetter:
+		recordFailure(hash, err)
+		logger.Errorf("Job %s moved to the dead-letter list after %d attempts: %v", hash, rec.Attempts, err)
+	}
+
+	if rec.Group != "" {
+		completeGroup(q, rec.Group)
+	}
+}
+
+// ListDeadLetterJobs returns the jobs that exhausted their retries
+func ListDeadLetterJobs() ([]taskQueue.JobRecord, error) {
+	q, err := queue()
 	if err != nil {
-		if ctx.Err() == context.Canceled {
-			jobStates[hash] = JobStateCancelled
-		} else {
-			jobStates[hash] = JobStateFailed
+		return nil, err
+	}
+	return q.ListJobs(taskQueue.JobDeadLetter)
+}
+
+// ErrJobFilesMissing is returned when a dead-lettered job's uploaded files are gone
+var ErrJobFilesMissing = errors.New("job files are no longer available")
+
+// RequeueDeadLetterJob moves a dead-lettered job back into the queue with a fresh retry budget
+func RequeueDeadLetterJob(hash string) (taskQueue.JobRecord, error) {
+	q, err := queue()
+	if err != nil {
+		return taskQueue.JobRecord{}, err
+	}
+
+	rec, err := q.GetJob(hash)
+	if err != nil {
+		return taskQueue.JobRecord{}, err
+	}
+	if rec.Status == taskQueue.JobDeadLetter {
+		if _, err := os.Stat(filepath.Join(rec.Dir, "instructions.json")); err != nil {
+			return rec, fmt.Errorf("%w: %s: %v", ErrJobFilesMissing, hash, err)
 		}
-	} else {
-		jobStates[hash] = JobStateCompleted
 	}
-	mu.Unlock()
 
-	return err
+	rec, err = q.RequeueDeadLetter(hash)
+	if err != nil {
+		return rec, err
+	}
+	logger.Infof("Requeued dead-lettered job %s", hash)
+	notifyScheduler()
+	return rec, nil
 }
 
-// ProcessPendingJobs runs in a continuous loop processing pending image conversion jobs.
+// PurgeFinishedJobs deletes finished job records older than maxAge, along with the files
+// dead-lettered jobs kept for a possible requeue and job groups that completed before maxAge
+func PurgeFinishedJobs(maxAge time.Duration) (int, error) {
+	q, err := queue()
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := q.PurgeJobs(maxAge)
+	if err != nil {
+		return 0, err
+	}
+	for _, rec := range purged {
+		if rec.Status != taskQueue.JobDeadLetter {
+			continue
+		}
+		if err := os.RemoveAll(rec.Dir); err != nil {
+			logger.Errorf("Failed to remove files of purged job %s: %v", rec.Hash, err)
+		}
+	}
+
+	if groups, err := q.PurgeGroups(maxAge); err != nil {
+		logger.Errorf("Failed to purge job groups: %v", err)
+	} else if groups > 0 {
+		logger.Infof("Purged %d old job groups", groups)
+	}
+	return len(purged), nil
+}
+
+// ProcessPendingJobs runs the job scheduler in a continuous loop.
 // This function is designed to run as a background goroutine and handles the job queue.
 //
-// Processing logic:
-// 1. Continuously checks for pending jobs every 1 second when queue is empty
-// 2. Processes jobs concurrently using a worker pool (configurable max workers, default 2)
-// 3. Uses a semaphore to limit concurrent workers and prevent resource exhaustion
-// 4. For each job:
-//   - Calls processJob() to handle the conversion
-//   - Removes job from pending queue on success
-//   - Removes failed jobs from queue to prevent infinite retry loops
-//   - Logs processing status and errors
+// Scheduling logic:
+// 1. Whenever a worker is free, leases the next job from the persistent queue
+// 2. Realtime jobs (priority 0) are always served before queued jobs (priority 1)
+// 3. A share of workers is reserved for realtime jobs; queued jobs only use the rest
+// 4. Queued jobs waiting longer than the aging threshold compete with realtime jobs in arrival order
+// 5. Aged jobs may use reserved workers, so a steady stream of realtime work cannot starve them
+// 6. Each job starts as soon as a worker frees up; there is no batch barrier
+// 7. For each leased job:
+//   - Renews the lease while the job runs
+//   - Calls ProcessJob() to handle the conversion
+//   - Acknowledges the job as completed, failed, cancelled or dead-lettered, or schedules a retry
 //
-// Concurrency benefits:
-// - Multiple jobs can be processed simultaneously (configurable via PIXERVE_MAX_WORKERS)
-// - I/O-bound operations (file writing) happen concurrently within each job
-// - CPU-bound operations (image encoding) are naturally parallelized
+// Crash safety:
+// - A job leaves the processing state only when acknowledged, so a crash mid-conversion leaves it leased
+// - RecoverPendingJobs returns such jobs to the pending state on the next start
 //
 // Configuration:
 // - PIXERVE_MAX_WORKERS: Number of concurrent workers (default: NumCPU-1, minimum 1, range: 1-10)
+// - PIXERVE_REALTIME_WORKER_SHARE: Percentage of workers reserved for realtime jobs (default: 25)
+// - PIXERVE_QUEUE_AGING: Wait after which queued jobs are promoted (default: 1m, 0 disables)
+// - PIXERVE_MAX_ATTEMPTS: Attempts before a job is dead-lettered (default: 5)
+// - PIXERVE_RETRY_BASE_DELAY / PIXERVE_RETRY_MAX_DELAY: Retry backoff bounds (default: 10s / 10m)
 //
 // This function runs indefinitely and should be started as a goroutine in main().
 // It provides the async processing capability that allows the HTTP server to remain responsive.
 func ProcessPendingJobs() {
 	maxWorkers := getMaxWorkers()
-	semaphore := make(chan struct{}, maxWorkers)
+	reserved := getReservedRealtimeWorkers(maxWorkers)
+	aging := getQueueAging()
+	logger.Infof("Job scheduler started: %d workers, %d reserved for realtime jobs, queue aging %v",
+		maxWorkers, reserved, aging)
+
+	var (
+		schedMu       sync.Mutex
+		running       int // jobs currently running
+		runningQueued int // queued-lane jobs running on unreserved workers
+	)
 
 	for {
-		jobs := GetPendingJobs()
-		if len(jobs) == 0 {
-			time.Sleep(1 * time.Second) // Wait before checking again
+		schedMu.Lock()
+		free := running < maxWorkers
+		policy := taskQueue.LeasePolicy{
+			AllowQueued: runningQueued < maxWorkers-reserved,
+			AgingAfter:  aging,
+		}
+		schedMu.Unlock()
+
+		var q *taskQueue.DBQueue
+		var rec *taskQueue.JobRecord
+		var err error
+		if free {
+			q, rec, err = leaseNext(policy)
+			if err != nil {
+				logger.Errorf("Failed to lease job: %v", err)
+			}
+		}
+
+		if rec == nil {
+			// Wait for a new job, a finished worker, or the next aging check
+			select {
+			case <-wake:
+			case <-time.After(pollInterval):
+			}
 			continue
 		}
-		logger.Infof("Processing %d pending jobs", len(jobs))
-
-		// Process jobs concurrently with worker limit
-		var wg sync.WaitGroup
-		for _, jobDir := range jobs {
-			wg.Add(1)
-			go func(jobDir string) {
-				defer wg.Done()
-
-				// Acquire worker slot
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
-
-				// Process the job
-				if err := processJob(jobDir); err != nil {
-					logger.Errorf("Failed to process job in %s: %v", jobDir, err)
-					// Remove failed jobs from pending queue to prevent infinite retries
-					RemovePendingJob(jobDir)
-				} else {
-					// Remove from pending on success
-					RemovePendingJob(jobDir)
-					logger.Infof("Processed job in %s", jobDir)
-				}
-			}(jobDir)
+
+		// Jobs leased through the queued allowance count against it, even if they had aged;
+		// anything else runs on a reserved or realtime-eligible worker
+		countsAsQueued := rec.Priority != taskQueue.PriorityRealtime && policy.AllowQueued
+
+		schedMu.Lock()
+		running++
+		if countsAsQueued {
+			runningQueued++
 		}
+		schedMu.Unlock()
+
+		go func(rec taskQueue.JobRecord) {
+			defer func() {
+				schedMu.Lock()
+				running--
+				if countsAsQueued {
+					runningQueued--
+				}
+				schedMu.Unlock()
+				notifyScheduler()
+			}()
+
+			logger.Infof("Processing job %s (priority %d, attempt %d)", rec.Hash, rec.Priority, rec.Attempts)
+			if err := processJob(q, rec); err != nil {
+				logger.Errorf("Failed to process job in %s: %v", rec.Dir, err)
+			} else {
+				logger.Infof("Processed job in %s", rec.Dir)
+			}
+		}(*rec)
+	}
+}
 
-		// Wait for all jobs in this batch to complete
-		wg.Wait()
+// leaseNext claims the next eligible job for this instance
+func leaseNext(policy taskQueue.LeasePolicy) (*taskQueue.DBQueue, *taskQueue.JobRecord, error) {
+	q, err := queue()
+	if err != nil {
+		return nil, nil, err
 	}
+	rec, err := q.Lease(instanceID, leaseTTL, policy)
+	return q, rec, err
 }
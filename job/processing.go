@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -14,13 +15,15 @@ import (
 
 	"pixerve/config"
 	"pixerve/encoder"
-	"pixerve/failures"
 	"pixerve/logger"
 	"pixerve/models"
 	"pixerve/success"
 	writerbackends "pixerve/writerBackends"
 )
 
+// outputDeleteTimeout bounds the removal of outputs written by a cancelled job
+const outputDeleteTimeout = time.Minute
+
 // ProcessJob processes a single image conversion job from the pending queue.
 // This is the core job processing function that handles the complete image conversion pipeline.
 //
@@ -29,75 +32,79 @@ import (
 //   - jobDir: temporary directory containing the uploaded file and instructions
 //
 // Process flow:
-// 1. Registers image encoders (AVIF, WebP, JPEG/PNG)
-// 2. Sets up cleanup handling for job cancellation
-// 3. Reads processing instructions from the job directory
+// 1. Sets up cleanup handling for job cancellation
+// 2. Reads processing instructions from the job directory
+// 3. Resolves storage keys into registered credential bundles and validates them
 // 4. Validates input file and conversion parameters
 // 5. Performs image conversion using appropriate encoder
 // 6. Stores result using configured writer backend
-// 7. Updates success/failure tracking databases
+// 7. Updates success tracking database
 // 8. Cleans up temporary files
 //
+// Encoders must be registered beforehand with encoder.RegisterDefaults (main does this at startup).
+//
 // The function handles various error conditions and ensures proper cleanup
-// even when jobs are cancelled or fail partway through processing.
+// even when jobs are cancelled through CancelJob partway through processing.
+// Failures are returned as *JobError, marked with Permanent when a retry cannot help;
+// the scheduler decides whether to retry and records final failures in the failure store.
+// The job directory is kept on failure so the job can be retried.
 func ProcessJob(ctx context.Context, jobDir string) error {
-	// Ensure encoders are registered
-	encoder.RegisterDefaults()
-
-	// Create a channel to signal cleanup completion
-	cleanupDone := make(chan struct{})
-
-	// Start cleanup goroutine for cancellation
-	go func() {
-		defer close(cleanupDone)
-		<-ctx.Done()
+	// A job cancelled through CancelJob never runs again, so its uploaded files go. This runs once
+	// every conversion and write has returned; other cancellations, such as a lost lease, keep
+	// the directory for whichever run takes the job over.
+	defer func() {
+		var req *cancelRequest
+		if !errors.As(context.Cause(ctx), &req) {
+			return
+		}
 		logger.Infof("Job cancelled, cleaning up %s", jobDir)
-		// Only cleanup if context was cancelled (not if job completed successfully)
-		if ctx.Err() == context.Canceled {
-			if err := os.RemoveAll(jobDir); err != nil {
-				logger.Errorf("Failed to cleanup cancelled job directory %s: %v", jobDir, err)
-			}
+		if err := os.RemoveAll(jobDir); err != nil {
+			logger.Errorf("Failed to cleanup cancelled job directory %s: %v", jobDir, err)
 		}
 	}()
 
-	// Ensure cleanup goroutine completes
-	defer func() {
-		<-cleanupDone
-	}()
-
 	// Read instructions
 	instr, err := ReadInstructions(jobDir)
 	if err != nil {
 		logger.Errorf("Failed to read instructions for %s: %v", jobDir, err)
 		// Create a minimal instr for failure storage
 		hash := filepath.Base(jobDir)
-		return storeFailure(JobInstructions{Hash: hash}, err)
+		return jobFailed(JobInstructions{Hash: hash}, Permanent(err))
 	}
 
 	logger.Infof("Processing job in %s: %s", jobDir, instr.OriginalFile)
 
+	// Resolve storage credentials before spending CPU on conversions
+	writerJobs, err := ResolveWriterJobs(instr.Job.WriterJobs)
+	if err != nil {
+		logger.Errorf("Failed to resolve storage credentials for %s: %v", jobDir, err)
+		return jobFailed(instr, err)
+	}
+
 	// Create output subdirectory
 	outputDir := filepath.Join(jobDir, "output")
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		logger.Errorf("Failed to create output directory for %s: %v", jobDir, err)
-		return storeFailure(instr, err)
+		return jobFailed(instr, err)
 	}
 
 	// Process conversions
 	convertedFiles, err := processConversions(ctx, instr, outputDir)
 	if err != nil {
 		logger.Errorf("Failed to process conversions for %s: %v", jobDir, err)
-		return storeFailure(instr, err)
+		// Conversions are deterministic: an input that fails to convert will fail again
+		return jobFailed(instr, Permanent(err))
 	}
 
 	// Write to storage backends
-	if err := processWriters(ctx, instr, convertedFiles); err != nil {
+	outputs, err := processWriters(ctx, instr, writerJobs, convertedFiles)
+	if err != nil {
 		logger.Errorf("Failed to write to storage backends for %s: %v", jobDir, err)
-		return storeFailure(instr, err)
+		return &JobError{Instructions: instr, Outputs: outputs, Err: err}
 	}
 
 	// Store success record
-	if err := success.StoreSuccess(instr.Hash, instr.Job, len(convertedFiles)); err != nil {
+	if err := success.StoreSuccessWithOutputs(instr.Hash, instr.Subject, instr.Job, len(convertedFiles), outputs); err != nil {
 		logger.Errorf("Failed to store success record for %s: %v", jobDir, err)
 		// Don't fail the job for success storage errors
 	}
@@ -118,11 +125,13 @@ func ProcessJob(ctx context.Context, jobDir string) error {
 	return nil
 }
 
-// processConversions runs all conversion jobs and returns list of output files
+// processConversions runs all conversion jobs and returns list of output files.
+// The source is decoded once and shared across conversions through an encoder pipeline.
 func processConversions(ctx context.Context, instr JobInstructions, outputDir string) ([]string, error) {
 	var convertedFiles []string
 
 	inputPath := filepath.Join(instr.FilePath, instr.OriginalFile)
+	pipeline := encoder.NewPipeline(inputPath)
 
 	for _, convJob := range instr.Job.ConversionJobs {
 		// Check for cancellation
@@ -132,7 +141,7 @@ func processConversions(ctx context.Context, instr JobInstructions, outputDir st
 		default:
 		}
 
-		outputFile, err := runConversion(ctx, inputPath, convJob, outputDir, instr.Hash, instr.OriginalFile)
+		outputFile, err := runConversion(ctx, pipeline, convJob, outputDir, instr.Hash, instr.OriginalFile)
 		if err != nil {
 			return nil, fmt.Errorf("conversion failed for %s: %w", convJob.Encoder, err)
 		}
@@ -143,18 +152,12 @@ func processConversions(ctx context.Context, instr JobInstructions, outputDir st
 }
 
 // runConversion executes a single conversion job
-func runConversion(ctx context.Context, inputPath string, convJob models.ConversionJob, outputDir, hash, originalFile string) (string, error) {
+func runConversion(ctx context.Context, pipeline *encoder.Pipeline, convJob models.ConversionJob, outputDir, hash, originalFile string) (string, error) {
 	// Generate output filename
 	outputFile := generateOutputFilename(hash, originalFile, convJob)
 
 	outputPath := filepath.Join(outputDir, outputFile)
 
-	// Get encoder function
-	enc, ok := encoder.Get(convJob.Encoder)
-	if !ok {
-		return "", fmt.Errorf("encoder %s not found", convJob.Encoder)
-	}
-
 	// Run conversion
 	opts := encoder.EncodeOptions{
 		Width:   convJob.Width,
@@ -163,7 +166,7 @@ func runConversion(ctx context.Context, inputPath string, convJob models.Convers
 		Speed:   convJob.Speed,
 	}
 
-	if err := enc(ctx, inputPath, outputPath, opts); err != nil {
+	if err := pipeline.Encode(ctx, convJob.Encoder, outputPath, opts); err != nil {
 		return "", fmt.Errorf("encoding failed: %w", err)
 	}
 
@@ -172,13 +175,7 @@ func runConversion(ctx context.Context, inputPath string, convJob models.Convers
 
 // generateOutputFilename creates the output filename based on conversion job
 func generateOutputFilename(hash, originalFile string, convJob models.ConversionJob) string {
-	// Extract original name without extension
-	nameParts := strings.Split(originalFile, ".")
-	originalName := strings.Join(nameParts[:len(nameParts)-1], ".")
-	originalExt := ""
-	if len(nameParts) > 1 {
-		originalExt = nameParts[len(nameParts)-1]
-	}
+	originalName, originalExt := splitOriginalName(originalFile)
 
 	if convJob.Encoder == "copy" {
 		// For copy encoder: hash_original_name.original_extension
@@ -190,6 +187,17 @@ func generateOutputFilename(hash, originalFile string, convJob models.Conversion
 	}
 }
 
+// splitOriginalName splits an uploaded filename into its name without extension and the extension
+func splitOriginalName(originalFile string) (string, string) {
+	nameParts := strings.Split(originalFile, ".")
+	originalName := strings.Join(nameParts[:len(nameParts)-1], ".")
+	originalExt := ""
+	if len(nameParts) > 1 {
+		originalExt = nameParts[len(nameParts)-1]
+	}
+	return originalName, originalExt
+}
+
 // getExtensionForEncoder returns the file extension for a given encoder
 func getExtensionForEncoder(encoderName string) string {
 	switch encoderName {
@@ -214,18 +222,26 @@ func getExtensionForEncoder(encoderName string) string {
 // - Each file-backend combination runs in its own goroutine
 // - Errors are collected via a buffered channel
 // - WaitGroup ensures all operations complete before returning
-// - Context cancellation is checked in each goroutine
+// - Context cancellation is checked in each goroutine and aborts uploads in progress
 //
 // Performance benefits:
 // - Network I/O (S3, GCS) and disk I/O (directServe) happen concurrently
 // - No CPU contention since operations are I/O bound
 // - Faster overall job completion for multi-format/multi-backend jobs
-func processWriters(ctx context.Context, instr JobInstructions, convertedFiles []string) error {
+//
+// writerJobs must already be resolved via ResolveWriterJobs so each carries its full credential bundle.
+// The returned outputs list every write that was started with its checksums if complete;
+// on failure some may be incomplete. If the job
+// was cancelled with CancelJob asking for it, those outputs are deleted from their backends again.
+func processWriters(ctx context.Context, instr JobInstructions, writerJobs []models.WriterJob, convertedFiles []string) ([]models.WrittenOutput, error) {
 	// Channel to collect errors from concurrent writes
-	errChan := make(chan error, len(instr.Job.WriterJobs)*len(convertedFiles))
+	errChan := make(chan error, len(writerJobs)*len(convertedFiles))
 	var wg sync.WaitGroup
 
-	for _, writerJob := range instr.Job.WriterJobs {
+	var writesMu sync.Mutex
+	var writes []*writtenOutput
+
+	for _, writerJob := range writerJobs {
 		for _, file := range convertedFiles {
 			wg.Add(1)
 			go func(writerJob models.WriterJob, file string) {
@@ -250,37 +266,101 @@ func processWriters(ctx context.Context, instr JobInstructions, convertedFiles [
 				defer reader.Close()
 
 				// Prepare access info
-				accessInfo := prepareAccessInfo(writerJob, file, instr.Job.SubDir)
+				accessInfo, err := prepareAccessInfo(writerJob, file, instr)
+				if err != nil {
+					errChan <- fmt.Errorf("failed to prepare %s for %s: %w", file, writerJob.Type, err)
+					return
+				}
+
+				// Track the write before it starts so interrupted writes can be cleaned up
+				write := &writtenOutput{
+					backendType: writerJob.Type,
+					accessInfo:  accessInfo,
+					output:      models.WrittenOutput{Backend: writerJob.Type, File: file},
+				}
+				writesMu.Lock()
+				writes = append(writes, write)
+				writesMu.Unlock()
 
 				// Write to backend
-				if err := writerbackends.WriteImage(ctx, accessInfo, reader, writerJob.Type); err != nil {
+				sums, err := writerbackends.WriteImage(ctx, accessInfo, reader, writerJob.Type)
+				if err != nil {
 					errChan <- fmt.Errorf("failed to write %s to %s: %w", file, writerJob.Type, err)
 					return
 				}
 
+				writesMu.Lock()
+				write.output.Complete = true
+				write.output.Size = sums.Size
+				write.output.SHA256 = sums.SHA256
+				write.output.MD5 = sums.MD5
+				writesMu.Unlock()
+
 				logger.Debugf("Successfully wrote %s to %s backend", file, writerJob.Type)
 			}(writerJob, file)
 		}
 	}
 
-	// Close error channel when all goroutines complete
-	go func() {
-		wg.Wait()
-		close(errChan)
-	}()
+	// Wait for every write to settle so the outputs are final
+	wg.Wait()
+	close(errChan)
 
-	// Collect any errors
+	// Report the first error
+	var firstErr error
 	for err := range errChan {
-		if err != nil {
-			return err
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
 	}
 
-	return nil
+	var req *cancelRequest
+	if firstErr != nil && errors.As(context.Cause(ctx), &req) && req.deleteOutputs {
+		deleteOutputs(ctx, writes)
+	}
+
+	outputs := make([]models.WrittenOutput, 0, len(writes))
+	for _, write := range writes {
+		outputs = append(outputs, write.output)
+	}
+	return outputs, firstErr
 }
 
-// prepareAccessInfo prepares the access info map for the writer backend
-func prepareAccessInfo(writerJob models.WriterJob, filename, subDir string) map[string]string {
+// writtenOutput tracks a write to one backend along with what is needed to delete it again
+type writtenOutput struct {
+	backendType string
+	accessInfo  map[string]string
+	output      models.WrittenOutput
+}
+
+// deleteOutputs removes the given writes from their backends. The job context is already
+// cancelled at this point, so the deletes run on a fresh timeout that keeps its values.
+func deleteOutputs(ctx context.Context, writes []*writtenOutput) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), outputDeleteTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, write := range writes {
+		wg.Add(1)
+		go func(write *writtenOutput) {
+			defer wg.Done()
+			if err := writerbackends.DeleteImage(ctx, write.accessInfo, write.backendType); err != nil {
+				logger.Errorf("Failed to delete %s from %s backend: %v", write.output.File, write.backendType, err)
+				write.output.DeleteError = err.Error()
+				return
+			}
+			write.output.Deleted = true
+		}(write)
+	}
+	wg.Wait()
+}
+
+// prepareAccessInfo prepares the access info map for the writer backend.
+// Remote backends get the object path rendered from the job's path template, or else their
+// credential bundle's, as "objectPath", along with the content type of the output, the job's
+// cacheControl and metadata over their bundle's, and provenance metadata. S3 storage options
+// are layered from the bundle, its "<format>.<field>" overrides and the job's settings for
+// the output.
+func prepareAccessInfo(writerJob models.WriterJob, filename string, instr JobInstructions) (map[string]string, error) {
 	accessInfo := make(map[string]string)
 
 	// Copy credentials
@@ -290,34 +370,112 @@ func prepareAccessInfo(writerJob models.WriterJob, filename, subDir string) map[
 
 	// Add filename and subdir
 	accessInfo["filename"] = filename
-	accessInfo["folder"] = subDir
+	accessInfo["folder"] = instr.Job.SubDir
 
 	// Set backend-specific configuration
 	switch writerJob.Type {
-	case "directServe":
+	case writerbackends.DirectServeType:
 		accessInfo["baseDir"] = config.GetDirectServeBaseDir()
+		if accessInfo["private"] == "true" {
+			accessInfo["baseDir"] = config.GetPrivateServeBaseDir()
+		}
+	default:
+		template := instr.Job.PathTemplate
+		if template == "" {
+			template = accessInfo["pathTemplate"]
+		}
+		convJob, ok := outputConversion(instr, filename)
+		if !ok {
+			return nil, fmt.Errorf("no conversion of the job produces %s", filename)
+		}
+		vars := outputPathVars(instr, filename, accessInfo["prefix"], convJob)
+		objectPath, err := writerbackends.RenderPath(template, vars)
+		if err != nil {
+			return nil, err
+		}
+		accessInfo["objectPath"] = objectPath
+		accessInfo["contentType"] = writerbackends.ContentTypeForExt(vars.Ext)
+
+		if instr.Job.CacheControl != "" {
+			accessInfo["cacheControl"] = instr.Job.CacheControl
+		}
+		for name, value := range instr.Job.Metadata {
+			accessInfo[writerbackends.MetadataFieldPrefix+name] = value
+		}
+		provenance := writerbackends.ProvenanceMetadata(instr.Hash, instr.OriginalFile, convJob.Encoder, vars.Width, vars.Height)
+		for name, value := range provenance {
+			accessInfo[writerbackends.MetadataFieldPrefix+name] = value
+		}
+		if err := writerbackends.ValidateObjectMetadataSize(accessInfo); err != nil {
+			return nil, err
+		}
+
+		if writerJob.Type == "s3" {
+			if err := applyS3Options(accessInfo, vars.Format, convJob.S3); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return accessInfo
+	return accessInfo, nil
 }
 
-// storeFailure stores a processing failure in the failure store
-func storeFailure(instr JobInstructions, err error) error {
-	if instr.Hash == "" {
-		logger.Errorf("Cannot store failure: missing hash")
+// applyS3Options layers the S3 storage options for an output of format over the bundle's:
+// first the bundle's "<format>.<field>" overrides, then the job's settings for the output
+func applyS3Options(accessInfo map[string]string, format string, jobOptions *models.S3Options) error {
+	if err := writerbackends.ApplyS3Options(accessInfo, writerbackends.S3OutputOptions(accessInfo, format)); err != nil {
 		return err
 	}
+	if err := writerbackends.ApplyS3Options(accessInfo, s3OptionFields(jobOptions)); err != nil {
+		return err
+	}
+	if err := writerbackends.ValidateS3Options(accessInfo); err != nil {
+		return fmt.Errorf("invalid s3 settings for %s outputs: %w", format, err)
+	}
+	return nil
+}
+
+// outputPathVars returns the path template values for an output file of the job produced by convJob
+func outputPathVars(instr JobInstructions, filename, prefix string, convJob models.ConversionJob) writerbackends.PathVars {
+	name, originalExt := splitOriginalName(instr.OriginalFile)
+	vars := writerbackends.PathVars{
+		Prefix:   prefix,
+		SubDir:   instr.Job.SubDir,
+		Hash:     instr.Hash,
+		Name:     name,
+		Filename: filename,
+	}
+	if convJob.Encoder == "copy" {
+		vars.Format = "original"
+		vars.Ext = originalExt
+	} else {
+		vars.Format = convJob.Encoder
+		vars.Ext = getExtensionForEncoder(convJob.Encoder)
+		vars.Width = convJob.Width
+		vars.Height = convJob.Length
+	}
+	return vars
+}
 
-	if storeErr := failures.StoreFailure(instr.Hash, err, instr); storeErr != nil {
-		logger.Errorf("Failed to store failure for hash %s: %v", instr.Hash, storeErr)
+// outputConversion returns the conversion job of the job that produced filename
+func outputConversion(instr JobInstructions, filename string) (models.ConversionJob, bool) {
+	for _, convJob := range instr.Job.ConversionJobs {
+		if generateOutputFilename(instr.Hash, instr.OriginalFile, convJob) == filename {
+			return convJob, true
+		}
 	}
+	return models.ConversionJob{}, false
+}
 
-	return err
+// jobFailed wraps a processing failure together with the job instructions
+func jobFailed(instr JobInstructions, err error) error {
+	return &JobError{Instructions: instr, Err: err}
 }
 
-// sendCallback sends completion callback if configured
+// sendCallback sends completion callback if configured.
+// Members of a group report through the group-level callback instead (see completeGroup).
 func sendCallback(instr JobInstructions) error {
-	if instr.Job.CallbackURL == "" {
+	if instr.Job.CallbackURL == "" || instr.Group != "" {
 		return nil // No callback configured
 	}
 
@@ -329,14 +487,23 @@ func sendCallback(instr JobInstructions) error {
 		"timestamp":  time.Now().Unix(),
 		"job_data":   instr.Job,
 	}
+	if err := postCallback(instr.Job.CallbackURL, instr.Job.CallbackHeaders, payload); err != nil {
+		return err
+	}
 
+	logger.Infof("Successfully sent callback to %s", instr.Job.CallbackURL)
+	return nil
+}
+
+// postCallback POSTs a JSON callback payload with the caller's custom headers
+func postCallback(callbackURL string, headers map[string]string, payload interface{}) error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal callback payload: %w", err)
 	}
 
 	// Create HTTP request
-	req, err := http.NewRequest("POST", instr.Job.CallbackURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", callbackURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		return fmt.Errorf("failed to create callback request: %w", err)
 	}
@@ -346,7 +513,7 @@ func sendCallback(instr JobInstructions) error {
 	req.Header.Set("User-Agent", "Pixerve/1.0")
 
 	// Add custom callback headers if provided
-	for key, value := range instr.Job.CallbackHeaders {
+	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
 
@@ -362,7 +529,5 @@ func sendCallback(instr JobInstructions) error {
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("callback returned non-2xx status: %d", resp.StatusCode)
 	}
-
-	logger.Infof("Successfully sent callback to %s", instr.Job.CallbackURL)
 	return nil
 }
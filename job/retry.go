@@ -0,0 +1,142 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+
+	"pixerve/failures"
+	"pixerve/logger"
+	"pixerve/models"
+	writerbackends "pixerve/writerBackends"
+)
+
+// JobError is returned by ProcessJob when a job fails.
+// It carries the job instructions so the failure can be recorded once the scheduler
+// decides the job will not be retried.
+type JobError struct {
+	Instructions JobInstructions
+	Outputs      []models.WrittenOutput // writes started before the job stopped
+	Err          error
+}
+
+func (e *JobError) Error() string { return e.Err.Error() }
+func (e *JobError) Unwrap() error { return e.Err }
+
+// permanentError marks a failure that will not succeed on retry
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a permanent failure, such as invalid instructions, an unknown
+// storage key or an image that cannot be decoded. Permanent failures are never retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable reports whether a failed job may succeed if attempted again.
+// Only failures known to be transient are retried: network errors, timeouts, throttling and
+// 5xx responses from storage backends (see writerbackends.IsTransientError). Failures marked
+// with Permanent, cancellations and anything unclassified, such as rejected credentials,
+// missing buckets or invalid settings, fail the job right away.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var perm *permanentError
+	if errors.As(err, &perm) {
+		return false
+	}
+	return writerbackends.IsTransientError(err)
+}
+
+// BackoffDelay returns the wait before retrying after the given attempt (1-based).
+// The delay doubles with every attempt starting at base, is capped at maxDelay,
+// and is jittered to between half and all of that value so retries of jobs that
+// failed together do not hit the same backend at the same moment.
+func BackoffDelay(attempt int, base, maxDelay time.Duration) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// getMaxAttempts returns how many times a job is attempted before it is dead-lettered.
+// Configurable via PIXERVE_MAX_ATTEMPTS (default 5, minimum 1).
+func getMaxAttempts() int {
+	const defaultAttempts = 5
+	if env := os.Getenv("PIXERVE_MAX_ATTEMPTS"); env != "" {
+		if attempts, err := strconv.Atoi(env); err == nil && attempts >= 1 {
+			return attempts
+		}
+		logger.Warnf("Invalid PIXERVE_MAX_ATTEMPTS %q, using %d", env, defaultAttempts)
+	}
+	return defaultAttempts
+}
+
+// getRetryDelays returns the base and maximum retry backoff.
+// Configurable via PIXERVE_RETRY_BASE_DELAY (default 10s) and PIXERVE_RETRY_MAX_DELAY (default 10m).
+func getRetryDelays() (time.Duration, time.Duration) {
+	base := durationFromEnv("PIXERVE_RETRY_BASE_DELAY", 10*time.Second)
+	maxDelay := durationFromEnv("PIXERVE_RETRY_MAX_DELAY", 10*time.Minute)
+	if maxDelay < base {
+		maxDelay = base
+	}
+	return base, maxDelay
+}
+
+// durationFromEnv parses a non-negative duration from an environment variable
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	env := os.Getenv(name)
+	if env == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(env)
+	if err != nil || value < 0 {
+		logger.Warnf("Invalid %s %q, using %v", name, env, fallback)
+		return fallback
+	}
+	return value
+}
+
+// jobOutputs returns the outputs carried by a *JobError, if any
+func jobOutputs(err error) []models.WrittenOutput {
+	var jobErr *JobError
+	if errors.As(err, &jobErr) {
+		return jobErr.Outputs
+	}
+	return nil
+}
+
+// recordFailure stores a job's final failure, and the outputs it had written, in the failure store
+func recordFailure(hash string, err error) {
+	instr := JobInstructions{Hash: hash}
+	var jobErr *JobError
+	if errors.As(err, &jobErr) && jobErr.Instructions.Hash != "" {
+		instr = jobErr.Instructions
+	}
+
+	if storeErr := failures.StoreFailureWithOutputs(hash, err, instr, jobOutputs(err)); storeErr != nil {
+		logger.Errorf("Failed to store failure for hash %s: %v", hash, storeErr)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"pixerve/models"
 	"pixerve/utils"
+	writerbackends "pixerve/writerBackends"
 )
 
 type combinedJob struct {
@@ -14,10 +15,17 @@ type combinedJob struct {
 	Priority        int
 	KeepOriginal    bool
 	SubDir          string
+	PathTemplate    string
+	CacheControl    string
+	Metadata        map[string]string
 }
 
 func ParseTokenIntoJobs(tokenString string) (combinedJob, error) {
-	claims, err := utils.VerifyPixerveJWT(tokenString, utils.VerifyConfig{})
+	verifyConfig, err := utils.LoadVerifyConfig()
+	if err != nil {
+		return combinedJob{}, fmt.Errorf("failed to load JWT verification keys: %w", err)
+	}
+	claims, err := utils.VerifyPixerveJWT(tokenString, verifyConfig)
 	if err != nil {
 		return combinedJob{}, fmt.Errorf("failed to verify JWT: %w", err)
 	}
@@ -37,6 +45,9 @@ func parseClaimsIntoJobs(task *models.PixerveJWT) (combinedJob, error) {
 	var writerJobs []models.WriterJob = make([]models.WriterJob, 0)
 
 	for format, spec := range task.Job.Formats {
+		if err := writerbackends.ValidateS3Options(s3OptionFields(spec.Settings.S3)); err != nil {
+			return combinedJob{}, fmt.Errorf("invalid s3 settings for %s: %w", format, err)
+		}
 		for _, size := range spec.Sizes {
 			var length, width int
 			if len(size) == 1 {
@@ -54,6 +65,7 @@ func parseClaimsIntoJobs(task *models.PixerveJWT) (combinedJob, error) {
 				Width:   width,
 				Quality: spec.Settings.Quality,
 				Speed:   spec.Settings.Speed,
+				S3:      spec.Settings.S3,
 			})
 		}
 	}
@@ -65,20 +77,42 @@ func parseClaimsIntoJobs(task *models.PixerveJWT) (combinedJob, error) {
 		})
 	}
 
+	if err := writerbackends.ValidatePathTemplate(task.Job.PathTemplate); err != nil {
+		return combinedJob{}, err
+	}
+	if err := writerbackends.ValidateCacheControl(task.Job.CacheControl); err != nil {
+		return combinedJob{}, err
+	}
+	if err := writerbackends.ValidateObjectMetadata(task.Job.Metadata); err != nil {
+		return combinedJob{}, err
+	}
+
+	if task.Job.Private && !task.Job.DirectHost {
+		return combinedJob{}, fmt.Errorf("private requires directHost")
+	}
+
 	if task.Job.DirectHost {
+		credentials := map[string]string{}
+		if task.Job.Private {
+			credentials["private"] = "true"
+		}
 		writerJobs = append(writerJobs, models.WriterJob{
 			Type:        "directServe",
-			Credentials: map[string]string{},
+			Credentials: credentials,
 		})
 	}
 
 	if task.Job.KeepOriginal {
+		if err := writerbackends.ValidateS3Options(s3OptionFields(task.Job.OriginalS3)); err != nil {
+			return combinedJob{}, fmt.Errorf("invalid originalS3: %w", err)
+		}
 		encodeJobs = append(encodeJobs, models.ConversionJob{
 			Encoder: "copy",
 			Length:  0,   // Not applicable for copy
 			Width:   0,   // Not applicable for copy
 			Quality: 100, // Not applicable for copy
 			Speed:   0,   // Not applicable for copy
+			S3:      task.Job.OriginalS3,
 		})
 	}
 
@@ -90,5 +124,22 @@ func parseClaimsIntoJobs(task *models.PixerveJWT) (combinedJob, error) {
 		Priority:        task.Job.Priority,
 		KeepOriginal:    task.Job.KeepOriginal,
 		SubDir:          task.Job.SubDir,
+		PathTemplate:    task.Job.PathTemplate,
+		CacheControl:    task.Job.CacheControl,
+		Metadata:        task.Job.Metadata,
 	}, nil
 }
+
+// s3OptionFields returns job storage options keyed by the accessInfo fields of the S3 writer
+func s3OptionFields(opts *models.S3Options) map[string]string {
+	if opts == nil {
+		return nil
+	}
+	return map[string]string{
+		"serverSideEncryption": opts.ServerSideEncryption,
+		"sseKmsKeyId":          opts.SSEKMSKeyID,
+		"storageClass":         opts.StorageClass,
+		"acl":                  opts.ACL,
+		"tags":                 writerbackends.EncodeS3Tags(opts.Tags),
+	}
+}
@@ -5,14 +5,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"pixerve/config"
 	"pixerve/credentials"
+	"pixerve/encoder"
 	"pixerve/failures"
 	"pixerve/job"
 	"pixerve/logger"
 	"pixerve/routes"
 	"pixerve/success"
+	"pixerve/taskQueue"
 
+	"strings"
 	"syscall"
 	"time"
 )
@@ -20,8 +24,8 @@ import (
 // main is the entry point for the Pixerve image processing server.
 // It performs the following initialization steps:
 // 1. Initializes all database stores (credentials, failures, success)
-// 2. Opens the task queue for async job processing
-// 3. Scans for any pending jobs from previous runs
+// 2. Opens the persistent task queue for async job processing
+// 3. Recovers jobs left pending or interrupted by previous runs
 // 4. Starts background cleanup and job processing routines
 // 5. Registers HTTP routes for the REST API
 // 6. Sets up file serving for processed images
@@ -29,23 +33,71 @@ import (
 //
 // The server provides endpoints for:
 // - Image upload and processing (/upload)
+// - Batch uploads of many files or archives as one job group (/upload/batch)
+// - Resumable tus uploads (/uploads/)
+// - Ingesting images by URL (/ingest)
 // - Health checks (/health)
-// - Job status monitoring (/status, /cancel)
+// - Job status monitoring (/status, /cancel, /groups/status)
 // - Success/failure tracking (/success, /failures)
+// - Storage credential management and health checks (/credentials, admin only)
+// - Registered storage backends (/backends)
+// - Dead-letter inspection and requeue (/deadletter, admin only)
 // - Direct file serving (/files/)
+// - Signed on-the-fly resizing and re-encoding of served files (/img/)
+// - Accept-negotiated format selection for served variants (/images/)
+// - Signed, expiring URLs for files of private jobs (/private/, minted by /private-urls)
 //
 // Environment variables:
 // - PIXERVE_DATA_DIR: Custom data directory (default: ./data)
 // - PIXERVE_SERVE_DIR: Custom serve directory (default: ./serve)
+// - PIXERVE_CACHE_CONTROL: Cache-Control rules for /files/ (default: /=public, max-age=31536000, immutable)
+// - PIXERVE_DIRECTORY_LISTING: Set to false to disable directory listings under /files/
+// - PIXERVE_PRIVATE_SERVE_DIR: Directory for files of private jobs (default: ./private)
+// - PIXERVE_PRIVATE_URL_KEY / PIXERVE_PRIVATE_URL_KEY_FILE: HMAC key for /private/ URLs (unset disables them)
+// - PIXERVE_PRIVATE_URL_MAX_TTL: Longest validity of minted private URLs (default: 168h)
+// - PIXERVE_ADMIN_TOKEN: Bearer token for admin endpoints (unset disables them)
+// - PIXERVE_CREDENTIALS_KEY / PIXERVE_CREDENTIALS_KEY_FILE: Master key for encrypting stored credentials
+// - PIXERVE_JWT_SECRET / PIXERVE_JWT_SECRET_FILE / PIXERVE_JWT_PUBLIC_KEY_FILE / PIXERVE_JWKS_FILE: JWT verification keys
+// - PIXERVE_JWT_ISSUER / PIXERVE_JWT_AUDIENCE / PIXERVE_JWT_CLOCK_SKEW: JWT claim checks
+// - PIXERVE_MAX_UPLOAD_SIZE / PIXERVE_SUBJECT_UPLOAD_LIMITS: Default and per-subject upload size limits
+// - PIXERVE_MAX_BATCH_FILES: Maximum number of files in one batch upload
+// - PIXERVE_TRANSFORM_KEY / PIXERVE_TRANSFORM_KEY_FILE: HMAC key for /img/ URLs (unset disables them)
+// - PIXERVE_TRANSFORM_CACHE_DIR: Cache for on-the-fly variants (default: {DATA_DIR}/transform-cache)
+// - PIXERVE_FORMAT_PREFERENCE: Format order for /images/ negotiation (default: avif,webp,jpg,png)
+// - PIXERVE_INGEST_TIMEOUT / PIXERVE_INGEST_ALLOWED_HOSTS / PIXERVE_INGEST_ALLOWED_NETWORKS: URL ingest limits
+// - PIXERVE_WRITER_CONCURRENCY: Concurrent writes per storage backend type (default: 8)
+// - PIXERVE_WRITER_IDLE_TIMEOUT: Idle time before pooled backend connections are closed (default: 5m)
+//
+// Subcommands:
+// - rekey -new-key-file <path>: Re-encrypt stored credentials with a new master key (server must be stopped)
 func main() {
+	// Offline maintenance commands (e.g. `pixerve rekey`) run instead of the server
+	if runSubcommand(os.Args[1:]) {
+		return
+	}
+
 	logger.Info("Starting Pixerve server initialization")
 
 	// Initialize credentials store
 	logger.Debug("Initializing credentials database")
+	masterKey, err := config.GetCredentialsMasterKey()
+	if err != nil {
+		logger.Fatalf("Failed to load credentials master key: %v", err)
+	}
 	if err := credentials.OpenDB(config.GetCredentialsDBPath()); err != nil {
 		logger.Fatalf("Failed to initialize credentials store: %v", err)
 	}
 	defer credentials.CloseDB()
+	if err := credentials.SetMasterKey(masterKey); err != nil {
+		logger.Fatalf("Failed to configure credentials master key: %v", err)
+	}
+	if masterKey == nil {
+		logger.Warn("No credentials master key configured; credentials will be stored unencrypted")
+	}
+	// Refuse to start if stored credentials cannot be decrypted with the configured key
+	if err := credentials.VerifyMasterKey(); err != nil {
+		logger.Fatalf("Credentials encryption check failed: %v", err)
+	}
 	logger.Info("Credentials database initialized successfully")
 
 	// Initialize failure store
@@ -65,15 +117,24 @@ func main() {
 	logger.Info("Success database initialized successfully")
 
 	// Initialize task queue
+	logger.Debug("Initializing task queue database")
+	if err := taskQueue.OpenConvertQueueDB(config.GetQueueDBPath()); err != nil {
+		logger.Fatalf("Failed to initialize task queue: %v", err)
+	}
+	defer taskQueue.CloseConvertQueueDB()
 	logger.Info("Task queue initialized successfully")
 
-	// Scan for pending jobs on startup
-	logger.Info("Scanning for pending jobs on startup")
-	if err := job.ScanForPendingJobs(); err != nil {
-		logger.Errorf("Failed to scan for pending jobs: %v", err)
+	// Register image encoders once, before jobs and transform requests use them
+	logger.Debug("Registering image encoders")
+	encoder.RegisterDefaults()
+
+	// Resume jobs left unfinished by the previous run
+	logger.Info("Recovering pending jobs on startup")
+	if err := job.RecoverPendingJobs(); err != nil {
+		logger.Errorf("Failed to recover pending jobs: %v", err)
 		// Don't exit - continue with server startup
 	} else {
-		logger.Info("Pending jobs scan completed")
+		logger.Info("Pending jobs recovery completed")
 	}
 
 	// Start cleanup routine for old logs (runs every 24 hours)
@@ -89,19 +150,50 @@ func main() {
 	// Register HTTP routes
 	logger.Info("Registering HTTP routes")
 	http.HandleFunc("/upload", routes.UploadHandler)
+	http.HandleFunc("/upload/batch", routes.BatchUploadHandler)
+	http.HandleFunc("/uploads", routes.TusHandler)
+	http.HandleFunc("/uploads/", routes.TusHandler)
+	http.HandleFunc("/ingest", routes.IngestHandler)
 	http.HandleFunc("/health", routes.HealthHandler)
 	http.HandleFunc("/version", routes.VersionHandler)
 	http.HandleFunc("/status", routes.JobStatusHandler)
+	http.HandleFunc("/groups/status", routes.GroupStatusHandler)
 	http.HandleFunc("/cancel", routes.CancelJobHandler)
 	http.HandleFunc("/failures", routes.FailureQueryHandler)
 	http.HandleFunc("/failures/list", routes.FailureListHandler)
 	http.HandleFunc("/success", routes.SuccessQueryHandler)
 	http.HandleFunc("/success/list", routes.SuccessListHandler)
 
+	// Credential management (admin only, requires PIXERVE_ADMIN_TOKEN)
+	http.HandleFunc("/credentials", routes.RequireAdmin(routes.CredentialsMetadataHandler))
+	http.HandleFunc("/credentials/list", routes.RequireAdmin(routes.CredentialsListHandler))
+	http.HandleFunc("/credentials/register", routes.RequireAdmin(routes.RegisterCredentialsHandler))
+	http.HandleFunc("/credentials/deregister", routes.RequireAdmin(routes.DeregisterCredentialsHandler))
+	http.HandleFunc("/credentials/check", routes.RequireAdmin(routes.CredentialsCheckHandler))
+
+	// Registered writer backends and their required credential fields
+	http.HandleFunc("/backends", routes.BackendsHandler)
+
+	// Dead-letter inspection and requeue (admin only)
+	http.HandleFunc("/deadletter/list", routes.RequireAdmin(routes.DeadLetterListHandler))
+	http.HandleFunc("/deadletter/requeue", routes.RequireAdmin(routes.DeadLetterRequeueHandler))
+
 	// Serve static files from direct serve directory
 	serveDir := config.GetDirectServeBaseDir()
 	logger.Infof("Setting up file server for direct serve directory: %s", serveDir)
-	http.Handle("/files/", http.StripPrefix("/files/", http.FileServer(http.Dir(serveDir))))
+	http.Handle("/files/", http.StripPrefix("/files", routes.FileServer(serveDir)))
+
+	// Signed on-the-fly variants of served files (requires PIXERVE_TRANSFORM_KEY)
+	http.HandleFunc("/img/", routes.TransformHandler)
+	http.HandleFunc("/images/", routes.NegotiatedImageHandler)
+
+	// Files of private jobs, only through signed expiring URLs (requires PIXERVE_PRIVATE_URL_KEY)
+	privateDir := config.GetPrivateServeBaseDir()
+	if rel, err := filepath.Rel(serveDir, privateDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		logger.Warnf("Private serve directory %s is inside the public serve directory %s; private files are publicly readable", privateDir, serveDir)
+	}
+	http.HandleFunc("/private/", routes.PrivateFileHandler)
+	http.HandleFunc("/private-urls", routes.PrivateURLsHandler)
 
 	logger.Info("HTTP routes registered successfully")
 
@@ -190,6 +282,27 @@ func cleanupRoutine(ctx context.Context) {
 				logger.Info("Successfully cleaned up old failure records")
 			}
 
+			logger.Debugf("Purging finished job records older than %v", maxAge)
+			if purged, err := job.PurgeFinishedJobs(maxAge); err != nil {
+				logger.Errorf("Failed to purge old job records: %v", err)
+			} else {
+				logger.Infof("Purged %d old job records", purged)
+			}
+
+			logger.Debug("Removing abandoned resumable uploads older than 24h")
+			if removed, err := routes.CleanupStaleUploads(24 * time.Hour); err != nil {
+				logger.Errorf("Failed to cleanup stale uploads: %v", err)
+			} else {
+				logger.Infof("Removed %d abandoned resumable uploads", removed)
+			}
+
+			logger.Debugf("Removing cached image variants older than %v", maxAge)
+			if removed, err := routes.CleanupTransformCache(maxAge); err != nil {
+				logger.Errorf("Failed to cleanup transform cache: %v", err)
+			} else {
+				logger.Infof("Removed %d cached image variants", removed)
+			}
+
 			logger.Info("Scheduled cleanup completed")
 		}
 	}
@@ -10,4 +10,21 @@ type ConversionJob struct {
 	Length, Width int    // dimensions
 	Quality       int    // 1–100
 	Speed         int    // encoder speed/efficiency tradeoff
+
+	// S3 overrides the storage options of S3 credential bundles for this output, if set
+	S3 *S3Options `json:",omitempty"`
+}
+
+// WrittenOutput records one converted file written to one storage backend by a job
+type WrittenOutput struct {
+	Backend     string `json:"backend"`
+	File        string `json:"file"`
+	Complete    bool   `json:"complete"`          // false if the write was interrupted
+	Deleted     bool   `json:"deleted,omitempty"` // removed again after the job was cancelled
+	DeleteError string `json:"delete_error,omitempty"`
+
+	// Checksums of the content written by a complete write, verified against the backend
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	MD5    string `json:"md5,omitempty"`
 }
@@ -1,11 +1,43 @@
 package models
 
+import "encoding/json"
+
 type PixerveJWT struct {
-	Issuer    string  `json:"iss"` // optional
-	Subject   string  `json:"sub"`
-	IssuedAt  int64   `json:"iat"`
-	ExpiresAt int64   `json:"exp"`
-	Job       JobSpec `json:"job"`
+	Issuer    string   `json:"iss"` // optional
+	Subject   string   `json:"sub"`
+	Audience  Audience `json:"aud,omitempty"` // optional
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf,omitempty"` // optional
+	ExpiresAt int64    `json:"exp"`
+	Job       JobSpec  `json:"job"`
+}
+
+// Audience is the "aud" claim, which may be encoded as a single string or an array of strings
+type Audience []string
+
+// UnmarshalJSON accepts both the string and array forms of the claim
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains reports whether the audience includes the given value
+func (a Audience) Contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // Core job specification
@@ -14,16 +46,34 @@ type JobSpec struct {
 	CallbackHeaders    map[string]string `json:"callbackHeaders,omitempty"`
 	Priority           int               `json:"priority"` // 0 = realtime, 1 = queued
 	KeepOriginal       bool              `json:"keepOriginal"`
+	SourceURL          string            `json:"sourceUrl,omitempty"` // fetched by /ingest instead of uploading
 
 	// Formats requested for conversion
 	Formats map[string]FormatSpec `json:"formats"` // e.g., jpg, webp, avif
 
+	// OriginalS3 overrides the storage options of S3 credential bundles for the kept original,
+	// like the per-format settings do for converted outputs
+	OriginalS3 *S3Options `json:"originalS3,omitempty"`
+
 	// Storage backends — each backend has its own key (random string mapped in PebbleDB)
 	StorageKeys map[string]string `json:"storageKeys,omitempty"` // e.g., {"s3":"abc123", "sftp":"def456"}
 
+	// PathTemplate names the objects written to storage backends, overriding the "pathTemplate"
+	// of their credential bundles, e.g. "{prefix}/{subDir}/{hash}/{format}/{width}x{height}.{ext}"
+	PathTemplate string `json:"pathTemplate,omitempty"`
+
+	// CacheControl and Metadata are set on objects written to S3 and GCS, overriding the
+	// "cacheControl" and "metadata.<name>" fields of their credential bundles
+	CacheControl string            `json:"cacheControl,omitempty"` // e.g. "public, max-age=31536000, immutable"
+	Metadata     map[string]string `json:"metadata,omitempty"`     // lower case names, e.g. {"tenant":"acme"}
+
 	// Direct host storage
 	DirectHost bool   `json:"directHost,omitempty"` // true if we want to serve via Pixerve HTTP
 	SubDir     string `json:"subDir,omitempty"`     // tenant folder or logical subdir
+
+	// Private stores direct host files outside the public /files/ tree; they are only
+	// served through signed, expiring /private/ URLs
+	Private bool `json:"private,omitempty"`
 }
 
 // Encoding settings per format
@@ -35,4 +85,17 @@ type FormatSpec struct {
 type FormatSettings struct {
 	Quality int `json:"quality"` // 1–100
 	Speed   int `json:"speed"`   // encoder speed/efficiency tradeoff
+
+	// S3 overrides the storage options of S3 credential bundles for this format's outputs
+	S3 *S3Options `json:"s3,omitempty"`
+}
+
+// S3Options are S3 storage options set by a job. Customer keys for SSE-C are only accepted
+// from credential bundles, since tokens are not encrypted.
+type S3Options struct {
+	ServerSideEncryption string            `json:"serverSideEncryption,omitempty"` // AES256, aws:kms or aws:kms:dsse
+	SSEKMSKeyID          string            `json:"sseKmsKeyId,omitempty"`
+	StorageClass         string            `json:"storageClass,omitempty"` // e.g. STANDARD_IA
+	ACL                  string            `json:"acl,omitempty"`          // canned ACL, e.g. public-read
+	Tags                 map[string]string `json:"tags,omitempty"`         // merged over the bundle's tags
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"pixerve/config"
+	"pixerve/credentials"
+	"pixerve/logger"
+)
+
+// runRekey implements the offline `pixerve rekey` command.
+// It re-encrypts every stored credentials record with a new master key, which is how the
+// master key is rotated. The current key is read from the usual configuration
+// (PIXERVE_CREDENTIALS_KEY or PIXERVE_CREDENTIALS_KEY_FILE); plaintext records left over
+// from before encryption was enabled are encrypted as part of the run.
+//
+// The server must be stopped: Pebble holds an exclusive lock on the database directory.
+// After a successful run, point the server configuration at the new key.
+//
+// Usage: pixerve rekey -new-key-file /path/to/new.key
+func runRekey(args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ContinueOnError)
+	newKeyFile := fs.String("new-key-file", "", "file containing the new base64 encoded 32-byte master key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *newKeyFile == "" {
+		return fmt.Errorf("-new-key-file is required")
+	}
+
+	newKey, err := config.ReadMasterKeyFile(*newKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load new master key: %w", err)
+	}
+
+	currentKey, err := config.GetCredentialsMasterKey()
+	if err != nil {
+		return fmt.Errorf("failed to load current master key: %w", err)
+	}
+
+	if err := credentials.OpenDB(config.GetCredentialsDBPath()); err != nil {
+		return fmt.Errorf("failed to open credentials database (is the server still running?): %w", err)
+	}
+	defer credentials.CloseDB()
+
+	if err := credentials.SetMasterKey(currentKey); err != nil {
+		return err
+	}
+
+	count, err := credentials.Reencrypt(newKey)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Re-encrypted %d credentials records with the new master key", count)
+	return nil
+}
+
+// runSubcommand dispatches offline maintenance commands.
+// Returns false if args do not name a known subcommand, in which case the server starts normally.
+func runSubcommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch args[0] {
+	case "rekey":
+		if err := runRekey(args[1:]); err != nil {
+			logger.Errorf("rekey failed: %v", err)
+			os.Exit(1)
+		}
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,40 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"pixerve/config"
+	"pixerve/logger"
+)
+
+// RequireAdmin wraps a handler so it only runs for requests carrying the admin bearer token.
+// The token is read from PIXERVE_ADMIN_TOKEN on every request; when it is unset the
+// wrapped endpoint is disabled and responds with 403.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken := config.GetAdminToken()
+		if adminToken == "" {
+			logger.Warnf("Admin endpoint %s called but PIXERVE_ADMIN_TOKEN is not configured", r.URL.Path)
+			http.Error(w, "Admin API disabled", http.StatusForbidden)
+			return
+		}
+
+		authHeader := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(authHeader, "Bearer ")
+		if authHeader == "" || token == authHeader {
+			logger.Warnf("Missing admin token for %s from %s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Admin token required", http.StatusUnauthorized)
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			logger.Warnf("Invalid admin token for %s from %s", r.URL.Path, r.RemoteAddr)
+			http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
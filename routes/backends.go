@@ -0,0 +1,131 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"pixerve/credentials"
+	"pixerve/logger"
+	writerbackends "pixerve/writerBackends"
+)
+
+// backendHealthCheckTimeout bounds a credential health check against a remote backend
+const backendHealthCheckTimeout = 15 * time.Second
+
+// BackendInfo describes a registered writer backend
+type BackendInfo struct {
+	Type           string   `json:"type"`
+	RequiredFields []string `json:"required_fields"` // "a|b" is satisfied by either field
+}
+
+// CredentialsCheckResponse is the result of a credential health check
+type CredentialsCheckResponse struct {
+	AccessKey string `json:"access_key"`
+	Type      string `json:"type"`
+	Healthy   bool   `json:"healthy"`
+	Error     string `json:"error,omitempty"`
+}
+
+// BackendsHandler lists the registered writer backends and the credential fields each requires
+func BackendsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Backends request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		logger.Warnf("Invalid method for backends endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backends := make([]BackendInfo, 0, len(writerbackends.Registry))
+	for _, backendType := range writerbackends.Types() {
+		writer, _ := writerbackends.Get(backendType)
+		fields := writer.RequiredFields()
+		if fields == nil {
+			fields = []string{}
+		}
+		backends = append(backends, BackendInfo{Type: backendType, RequiredFields: fields})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(backends); err != nil {
+		logger.Errorf("Failed to encode backends response: %v", err)
+		return
+	}
+	logger.Debug("Backends request completed successfully")
+}
+
+// CredentialsCheckHandler runs the backend health check with a registered credential bundle,
+// so broken credentials show up before jobs fail on them (admin endpoint)
+func CredentialsCheckHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Credentials check request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		logger.Warnf("Invalid method for credentials check endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyString := r.URL.Query().Get("access_key")
+	if keyString == "" {
+		logger.Warn("Missing access_key parameter in credentials check request")
+		http.Error(w, "Missing access_key parameter", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := credentials.GetMetadata(keyString)
+	if err != nil {
+		if errors.Is(err, credentials.ErrNotFound) {
+			logger.Debugf("No credentials metadata for access key: %s", keyString)
+			http.Error(w, "Access key not found", http.StatusNotFound)
+			return
+		}
+		logger.Errorf("Failed to get credentials metadata for key %s: %v", keyString, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	bundle, err := credentials.GetCredentials(keyString)
+	if err != nil {
+		logger.Errorf("Failed to load credentials for key %s: %v", keyString, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	// Pass the storage key as jobs do, so trustOnFirstUse can record the SFTP host key
+	bundle["key"] = keyString
+
+	checkCredentials(w, r, keyString, meta.Type, bundle)
+}
+
+// checkCredentials runs the health check of backendType with bundle and writes the result
+func checkCredentials(w http.ResponseWriter, r *http.Request, keyString, backendType string, bundle map[string]string) {
+	writer, ok := writerbackends.Get(backendType)
+	if !ok {
+		logger.Errorf("Credentials %s are registered for unknown backend type %s", keyString, backendType)
+		http.Error(w, "Unknown backend type: "+backendType, http.StatusConflict)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), backendHealthCheckTimeout)
+	defer cancel()
+
+	response := CredentialsCheckResponse{AccessKey: keyString, Type: backendType, Healthy: true}
+	err := writerbackends.ValidateAccessInfo(backendType, bundle)
+	if err == nil {
+		err = writer.HealthCheck(ctx, bundle)
+	}
+	if err != nil {
+		logger.Warnf("Health check of %s credentials %s failed: %v", backendType, keyString, err)
+		response.Healthy = false
+		response.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Errorf("Failed to encode credentials check response: %v", err)
+		return
+	}
+	logger.Debug("Credentials check request completed successfully")
+}
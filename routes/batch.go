@@ -0,0 +1,351 @@
+package routes
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pixerve/config"
+	"pixerve/job"
+	"pixerve/logger"
+)
+
+// errTooManyFiles is returned when a batch upload holds more files than PIXERVE_MAX_BATCH_FILES
+var errTooManyFiles = errors.New("too many files in batch")
+
+// BatchFileResult reports the outcome of one file of a batch upload
+type BatchFileResult struct {
+	Filename      string   `json:"filename"`
+	Hash          string   `json:"hash,omitempty"`
+	ExpectedFiles []string `json:"expected_files,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// BatchUploadResponse is the response of a batch upload
+type BatchUploadResponse struct {
+	Group string            `json:"group"`
+	Files []BatchFileResult `json:"files"`
+}
+
+// stagedFile is a file of a batch upload streamed to its own staging directory
+type stagedFile struct {
+	stagingDir string
+	filename   string
+	hash       string
+}
+
+// batchReceiver collects the files of a batch upload, expanding archives into their entries
+type batchReceiver struct {
+	maxFileSize int64
+	maxFiles    int
+	staged      []stagedFile
+	results     []BatchFileResult // files rejected while receiving
+	firstStatus int               // response status of the first rejected file
+}
+
+// count returns how many files have been received so far, accepted or not
+func (b *batchReceiver) count() int {
+	return len(b.staged) + len(b.results)
+}
+
+// reject records a file that will not be queued
+func (b *batchReceiver) reject(filename string, status int, msg string) {
+	logger.Warnf("Batch file %s rejected: %s", filename, msg)
+	b.results = append(b.results, BatchFileResult{Filename: filename, Error: msg})
+	if b.firstStatus == 0 {
+		b.firstStatus = status
+	}
+}
+
+// receive streams one file into its own staging directory. Files over the size limit are
+// rejected individually; any other error aborts the whole batch.
+func (b *batchReceiver) receive(reader io.Reader, filename string) error {
+	if b.count() >= b.maxFiles {
+		return errTooManyFiles
+	}
+
+	stagingDir, err := createStagingDir()
+	if err != nil {
+		return err
+	}
+
+	hash, _, err := streamToFile(reader, filepath.Join(stagingDir, filename), b.maxFileSize)
+	if errors.Is(err, errUploadTooLarge) {
+		os.RemoveAll(stagingDir)
+		b.reject(filename, http.StatusRequestEntityTooLarge, "File too large")
+		return nil
+	}
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		return err
+	}
+
+	b.staged = append(b.staged, stagedFile{stagingDir: stagingDir, filename: filename, hash: hash})
+	return nil
+}
+
+// receiveArchive expands a zip or tar archive, receiving each regular file in it.
+// The archive is limited by the request body size; each entry by the per-file limit.
+func (b *batchReceiver) receiveArchive(part *multipart.Part, filename string, archiveLimit int64) error {
+	lower := strings.ToLower(filename)
+	if strings.HasSuffix(lower, ".zip") {
+		// zip needs random access, so the archive is staged to disk first
+		archiveDir, err := createStagingDir()
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(archiveDir)
+
+		archivePath := filepath.Join(archiveDir, "archive.zip")
+		if _, _, err := streamToFile(part, archivePath, archiveLimit); err != nil {
+			return err
+		}
+
+		archive, err := zip.OpenReader(archivePath)
+		if err != nil {
+			b.reject(filename, http.StatusBadRequest, fmt.Sprintf("Invalid zip archive: %v", err))
+			return nil
+		}
+		defer archive.Close()
+
+		for _, entry := range archive.File {
+			name := archiveEntryName(entry.Name)
+			if !entry.Mode().IsRegular() || name == "" {
+				continue
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				b.reject(name, http.StatusBadRequest, fmt.Sprintf("Invalid archive entry: %v", err))
+				continue
+			}
+			err = b.receive(rc, name)
+			rc.Close()
+			if errors.Is(err, zip.ErrChecksum) || errors.Is(err, zip.ErrFormat) {
+				b.reject(name, http.StatusBadRequest, fmt.Sprintf("Invalid archive entry: %v", err))
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var reader io.Reader = part
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(part)
+		if err != nil {
+			b.reject(filename, http.StatusBadRequest, fmt.Sprintf("Invalid gzip archive: %v", err))
+			return nil
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if isBodyTooLarge(err) {
+				return err
+			}
+			b.reject(filename, http.StatusBadRequest, fmt.Sprintf("Invalid tar archive: %v", err))
+			return nil
+		}
+		name := archiveEntryName(header.Name)
+		if header.Typeflag != tar.TypeReg || name == "" {
+			continue
+		}
+		if err := b.receive(archive, name); err != nil {
+			return err
+		}
+	}
+}
+
+// isArchive reports whether an uploaded file is an archive to expand
+func isArchive(filename string) bool {
+	lower := strings.ToLower(filename)
+	for _, suffix := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveEntryName returns the base name of an archive entry, or "" for entries to skip:
+// hidden files and resource forks such as __MACOSX/ metadata
+func archiveEntryName(name string) string {
+	if strings.HasPrefix(name, "__MACOSX/") {
+		return ""
+	}
+	base := name[strings.LastIndexAny(name, `/\`)+1:]
+	if base == "" || strings.HasPrefix(base, ".") {
+		return ""
+	}
+	return base
+}
+
+// isBodyTooLarge reports whether err means the request body exceeded its limit
+func isBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// BatchUploadHandler uploads many files under one JWT as one job group.
+// Every "file" part of the multipart form becomes its own job; zip and tar (optionally gzipped)
+// archives are expanded and each file inside becomes a job. All jobs share the job spec of the
+// token and one group, whose aggregate status is served by /groups/status and which sends a
+// single completion callback once every member job has finished or failed.
+func BatchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Batch upload request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodPost {
+		logger.Warnf("Invalid method for batch upload endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := verifyJWT(r)
+	if err != nil {
+		logger.Errorf("JWT verification failed: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		return
+	}
+	logger.Infof("JWT verified successfully for subject: %s", claims.Subject)
+
+	if err := job.AuthorizeStorageKeys(claims.Subject, claims.Job.StorageKeys); err != nil {
+		logger.Warnf("Storage key authorization failed for subject %s: %v", claims.Subject, err)
+		http.Error(w, fmt.Sprintf("Storage keys not authorized: %v", err), http.StatusForbidden)
+		return
+	}
+
+	// Reject an invalid job spec before receiving any files
+	if _, err := job.ParseTokenIntoJobsFromClaims(claims); err != nil {
+		logger.Errorf("Failed to parse job from claims: %v", err)
+		http.Error(w, fmt.Sprintf("Failed to parse job: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	receiver := &batchReceiver{
+		maxFileSize: config.GetMaxUploadSizeFor(claims.Subject),
+		maxFiles:    config.GetMaxBatchFiles(),
+	}
+	maxBodySize := receiver.maxFileSize + multipartOverhead
+	if int64(receiver.maxFiles) <= (1<<63-1-multipartOverhead)/receiver.maxFileSize {
+		maxBodySize = receiver.maxFileSize*int64(receiver.maxFiles) + multipartOverhead
+	}
+	if r.ContentLength > maxBodySize {
+		logger.Warnf("Batch upload too large: content-length %d bytes (max: %d)", r.ContentLength, maxBodySize)
+		http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		logger.Errorf("Failed to read multipart form: %v", err)
+		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
+		return
+	}
+
+	// Staging directories are removed on every error path; a no-op once promoted
+	defer func() {
+		for _, file := range receiver.staged {
+			os.RemoveAll(file.stagingDir)
+		}
+	}()
+
+	for {
+		part, err := nextFilePart(reader)
+		if errors.Is(err, errNoFilePart) {
+			break
+		}
+		if err == nil {
+			filename := part.FileName()
+			logger.Infof("Batch file received: %s", filename)
+			if isArchive(filename) {
+				err = receiver.receiveArchive(part, filename, maxBodySize)
+			} else {
+				err = receiver.receive(part, filename)
+			}
+			part.Close()
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, errTooManyFiles):
+				logger.Warnf("Batch upload rejected: more than %d files", receiver.maxFiles)
+				http.Error(w, fmt.Sprintf("Too many files (max: %d)", receiver.maxFiles), http.StatusRequestEntityTooLarge)
+			case isTooLarge(err):
+				logger.Warnf("Batch upload too large: %v", err)
+				http.Error(w, "Batch too large", http.StatusRequestEntityTooLarge)
+			default:
+				logger.Errorf("Failed to read batch upload: %v", err)
+				http.Error(w, "Failed to read files", http.StatusBadRequest)
+			}
+			return
+		}
+	}
+
+	if receiver.count() == 0 {
+		logger.Warn("Batch upload without files")
+		http.Error(w, "No files in batch", http.StatusBadRequest)
+		return
+	}
+
+	groupID, err := job.CreateGroup(claims.Subject, claims.Job.CompletionCallback, claims.Job.CallbackHeaders)
+	if err != nil {
+		logger.Errorf("Failed to create job group: %v", err)
+		http.Error(w, "Failed to create job group", http.StatusInternalServerError)
+		return
+	}
+
+	response := BatchUploadResponse{Group: groupID, Files: receiver.results}
+	status := receiver.firstStatus
+	queued := 0
+	for _, file := range receiver.staged {
+		finalHash, expectedFiles, uploadErr := enqueueUpload(claims, file.stagingDir, file.filename, file.hash, groupID)
+		if uploadErr != nil {
+			logger.Warnf("Batch file %s not queued: %v", file.filename, uploadErr)
+			response.Files = append(response.Files, BatchFileResult{Filename: file.filename, Error: uploadErr.Message})
+			if status == 0 {
+				status = uploadErr.Status
+			}
+			continue
+		}
+		response.Files = append(response.Files, BatchFileResult{Filename: file.filename, Hash: finalHash, ExpectedFiles: expectedFiles})
+		queued++
+	}
+
+	if err := job.SealGroup(groupID); err != nil {
+		logger.Errorf("Failed to seal job group %s: %v", groupID, err)
+		http.Error(w, "Failed to finalize job group", http.StatusInternalServerError)
+		return
+	}
+
+	// Succeed if any file was queued; the results list the ones that were not
+	if queued > 0 {
+		status = http.StatusOK
+	}
+
+	logger.Infof("Batch upload completed: group=%s, queued=%d, rejected=%d", groupID, queued, len(response.Files)-queued)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Errorf("Failed to encode batch upload response: %v", err)
+		return
+	}
+	logger.Debug("Batch upload request completed successfully")
+}
@@ -1,13 +1,17 @@
 package routes
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"pixerve/job"
 	"pixerve/logger"
 )
 
-// CancelJobHandler cancels a running job by hash
+// CancelJobHandler cancels a pending or processing job by hash.
+// Pending jobs are cancelled immediately (204). Processing jobs are stopped asynchronously (202);
+// with deleteOutputs=true the outputs they already wrote to storage backends are deleted.
+// It takes the same Pixerve JWT as /upload; jobs of other subjects are not found.
 func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("Cancel job request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
 
@@ -17,15 +21,24 @@ func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claims, err := verifyJWT(r)
+	if err != nil {
+		logger.Errorf("JWT verification failed: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		return
+	}
+
 	hash := r.URL.Query().Get("hash")
 	if hash == "" {
 		logger.Warn("Missing hash parameter in cancel request")
 		http.Error(w, "Missing hash parameter", http.StatusBadRequest)
 		return
 	}
+	deleteOutputs := r.URL.Query().Get("deleteOutputs") == "true"
 
-	logger.Infof("Attempting to cancel job: %s", hash)
-	if err := job.CancelJob(hash); err != nil {
+	logger.Infof("Attempting to cancel job: %s (deleteOutputs=%v)", hash, deleteOutputs)
+	state, err := job.CancelJobFor(hash, claims.Subject, deleteOutputs)
+	if err != nil {
 		logger.Errorf("Failed to cancel job %s: %v", hash, err)
 		// Return appropriate status based on error
 		if err.Error() == "job with hash "+hash+" not found" {
@@ -36,6 +49,17 @@ func CancelJobHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if state == job.JobStateProcessing {
+		logger.Infof("Cancellation of processing job requested: %s", hash)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		response := JobStatusResponse{Hash: hash, State: "processing", CancelRequested: true}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			logger.Errorf("Failed to encode cancel response: %v", err)
+		}
+		return
+	}
+
 	logger.Infof("Job cancelled successfully: %s", hash)
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,90 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pixerve/job"
+	"pixerve/logger"
+	"pixerve/taskQueue"
+)
+
+// DeadLetterListHandler returns the jobs that exhausted their retries, with their attempt history (admin endpoint)
+func DeadLetterListHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Dead-letter list request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		logger.Warnf("Invalid method for dead-letter list endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobs, err := job.ListDeadLetterJobs()
+	if err != nil {
+		logger.Errorf("Failed to list dead-letter jobs: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []taskQueue.JobRecord{}
+	}
+
+	logger.Infof("Retrieved %d dead-letter jobs", len(jobs))
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"jobs":  jobs,
+		"count": len(jobs),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Errorf("Failed to encode dead-letter list response: %v", err)
+		return
+	}
+	logger.Debug("Dead-letter list request completed successfully")
+}
+
+// DeadLetterRequeueHandler moves a dead-lettered job back into the queue (admin endpoint)
+func DeadLetterRequeueHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Dead-letter requeue request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodPost {
+		logger.Warnf("Invalid method for dead-letter requeue endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		logger.Warn("Missing hash parameter in dead-letter requeue request")
+		http.Error(w, "Missing hash parameter", http.StatusBadRequest)
+		return
+	}
+
+	rec, err := job.RequeueDeadLetterJob(hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, taskQueue.ErrJobNotFound):
+			logger.Warnf("Requeue requested for unknown job: %s", hash)
+			http.Error(w, "Job not found", http.StatusNotFound)
+		case errors.Is(err, job.ErrJobFilesMissing):
+			logger.Warnf("Cannot requeue job %s: %v", hash, err)
+			http.Error(w, "Job files are no longer available", http.StatusGone)
+		case errors.Is(err, taskQueue.ErrJobNotDeadLettered):
+			logger.Warnf("Requeue requested for job %s in state %s", hash, rec.Status)
+			http.Error(w, "Job is not in the dead-letter list", http.StatusConflict)
+		default:
+			logger.Errorf("Failed to requeue job %s: %v", hash, err)
+			http.Error(w, "Failed to requeue job", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	logger.Infof("Dead-letter job requeued: %s", hash)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(JobStatusResponse{Hash: hash, State: string(rec.Status)}); err != nil {
+		logger.Errorf("Failed to encode requeue response: %v", err)
+		return
+	}
+	logger.Debug("Dead-letter requeue request completed successfully")
+}
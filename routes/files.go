@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"pixerve/config"
+	"pixerve/logger"
+)
+
+// etagEntry is a computed ETag, valid while the file keeps its size and modification time
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// maxETagCacheEntries bounds etagCache; when it is full a random entry makes room
+const maxETagCacheEntries = 4096
+
+// etagCache avoids re-hashing served files on every request
+var (
+	etagCacheMu sync.Mutex
+	etagCache   = make(map[string]etagEntry) // file path -> etagEntry
+)
+
+// fileETag returns a strong ETag derived from the SHA-256 of the file content
+func fileETag(filePath string, info os.FileInfo) (string, error) {
+	etagCacheMu.Lock()
+	entry, ok := etagCache[filePath]
+	etagCacheMu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.etag, nil
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", filePath, err)
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+	storeETag(filePath, etagEntry{size: info.Size(), modTime: info.ModTime(), etag: etag})
+	return etag, nil
+}
+
+// storeETag caches entry for filePath, evicting a random entry if the cache is full
+func storeETag(filePath string, entry etagEntry) {
+	etagCacheMu.Lock()
+	defer etagCacheMu.Unlock()
+	if _, ok := etagCache[filePath]; !ok && len(etagCache) >= maxETagCacheEntries {
+		for evict := range etagCache {
+			delete(etagCache, evict)
+			break
+		}
+	}
+	etagCache[filePath] = entry
+}
+
+// isContentAddressed reports whether a served file name starts with the SHA-256 content hash
+// of its upload (see checkDuplicateUpload), so the name never refers to other content
+func isContentAddressed(name string) bool {
+	hash, _, ok := strings.Cut(name, "_")
+	if !ok || len(hash) != sha256.Size*2 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if !strings.ContainsRune("0123456789abcdef", rune(hash[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// setCacheHeaders sets Cache-Control from the configured rules and a strong ETag for the served
+// file at urlPath (below /files/). http.ServeContent then answers conditional requests with 304.
+// Rules marking files immutable only apply to content-addressed names; other files may be
+// overwritten, so they get config.RevalidateCacheControl instead.
+func setCacheHeaders(w http.ResponseWriter, urlPath, filePath string, info os.FileInfo) {
+	cacheControl := config.GetCacheControl(urlPath)
+	if strings.Contains(cacheControl, "immutable") && !isContentAddressed(path.Base(urlPath)) {
+		cacheControl = config.RevalidateCacheControl
+	}
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	etag, err := fileETag(filePath, info)
+	if err != nil {
+		logger.Warnf("Failed to compute ETag for %s: %v", filePath, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+}
+
+// FileServer serves the direct serve directory below /files/ (with the prefix stripped) like
+// http.FileServer, adding Cache-Control per PIXERVE_CACHE_CONTROL and strong content ETags.
+// Directory listings return 404 when PIXERVE_DIRECTORY_LISTING is false.
+func FileServer(root string) http.Handler {
+	fileServer := http.FileServer(http.Dir(root))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			logger.Warnf("Invalid method for files endpoint: %s", r.Method)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// path.Clean on a rooted path drops any ".." that would escape the serve directory
+		urlPath := path.Clean("/" + r.URL.Path)
+		filePath := filepath.Join(root, filepath.FromSlash(urlPath))
+		info, err := os.Stat(filePath)
+		if err == nil {
+			if info.IsDir() && !config.IsDirectoryListingEnabled() {
+				http.NotFound(w, r)
+				return
+			}
+			if info.Mode().IsRegular() {
+				setCacheHeaders(w, urlPath, filePath, info)
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+}
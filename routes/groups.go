@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"pixerve/job"
+	"pixerve/logger"
+	"pixerve/taskQueue"
+)
+
+// GroupStatusHandler returns the aggregate status of a batch upload group by ID.
+// It takes the same Pixerve JWT as /upload/batch; groups of other subjects are not found.
+func GroupStatusHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Group status request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		logger.Warnf("Invalid method for group status endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := verifyJWT(r)
+	if err != nil {
+		logger.Errorf("JWT verification failed: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		logger.Warn("Missing id parameter in group status request")
+		http.Error(w, "Missing id parameter", http.StatusBadRequest)
+		return
+	}
+
+	status, err := job.GetGroupStatusFor(id, claims.Subject)
+	if err != nil {
+		if errors.Is(err, taskQueue.ErrGroupNotFound) {
+			logger.Warnf("Group not found for subject %s: %s", claims.Subject, id)
+			http.Error(w, fmt.Sprintf("Group %s not found", id), http.StatusNotFound)
+			return
+		}
+		logger.Errorf("Failed to load group %s: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Debugf("Group status: id=%s, state=%s, total=%d", id, status.State, status.Total)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logger.Errorf("Failed to encode group status response: %v", err)
+		return
+	}
+	logger.Debug("Group status request completed successfully")
+}
@@ -0,0 +1,138 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"pixerve/config"
+	"pixerve/job"
+	"pixerve/logger"
+	"pixerve/utils"
+)
+
+// defaultIngestFilename is used when the source URL path has no usable file name
+const defaultIngestFilename = "source"
+
+// IngestHandler creates a job from an image fetched by URL instead of uploaded.
+// The URL is taken from the signed job spec (job.sourceUrl), so clients cannot point Pixerve at
+// arbitrary addresses without the token issuer's consent. The fetch is guarded against SSRF and
+// bounded by the subject's upload size limit, then queued exactly like a regular upload.
+func IngestHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Ingest request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodPost {
+		logger.Warnf("Invalid method for ingest endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := verifyJWT(r)
+	if err != nil {
+		logger.Errorf("JWT verification failed: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		return
+	}
+	logger.Infof("JWT verified successfully for subject: %s", claims.Subject)
+
+	sourceURL := claims.Job.SourceURL
+	if sourceURL == "" {
+		logger.Warnf("Ingest request without sourceUrl from subject %s", claims.Subject)
+		http.Error(w, "Token job has no sourceUrl", http.StatusBadRequest)
+		return
+	}
+
+	if err := job.AuthorizeStorageKeys(claims.Subject, claims.Job.StorageKeys); err != nil {
+		logger.Warnf("Storage key authorization failed for subject %s: %v", claims.Subject, err)
+		http.Error(w, fmt.Sprintf("Storage keys not authorized: %v", err), http.StatusForbidden)
+		return
+	}
+
+	maxFileSize := config.GetMaxUploadSizeFor(claims.Subject)
+	opts := utils.LoadFetchOptions(maxFileSize)
+
+	// The timeout covers reading the body too, so bound the whole fetch by it
+	ctx, cancel := context.WithTimeout(r.Context(), opts.Timeout)
+	defer cancel()
+
+	logger.Infof("Fetching source image for subject %s: %s", claims.Subject, sourceURL)
+	resp, err := utils.FetchSourceImage(ctx, sourceURL, opts)
+	if err != nil {
+		logger.Warnf("Failed to fetch source %s: %v", sourceURL, err)
+		status, msg := ingestErrorStatus(err)
+		http.Error(w, msg, status)
+		return
+	}
+	defer resp.Body.Close()
+
+	filename := ingestFilename(resp.Request.URL)
+
+	stagingDir, err := createStagingDir()
+	if err != nil {
+		http.Error(w, "Failed to create temp directory", http.StatusInternalServerError)
+		return
+	}
+	// Removed on every error path; a no-op once the directory has been promoted
+	defer os.RemoveAll(stagingDir)
+
+	hashSum, size, err := streamToFile(resp.Body, filepath.Join(stagingDir, filename), maxFileSize)
+	if err != nil {
+		if isTooLarge(err) {
+			logger.Warnf("Source too large: more than %d bytes (max: %d)", size, maxFileSize)
+			http.Error(w, "Source image too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		logger.Errorf("Failed to read source %s: %v", sourceURL, err)
+		status, msg := ingestErrorStatus(err)
+		http.Error(w, msg, status)
+		return
+	}
+	logger.Debugf("Source streamed successfully: %s (%d bytes, hash %s)", filename, size, hashSum)
+
+	finalHash, expectedFiles, ok := queueUpload(w, claims, stagingDir, filename, hashSum)
+	if !ok {
+		return
+	}
+
+	logger.Infof("Ingest completed successfully: hash=%s, files=%v", finalHash, expectedFiles)
+	respondSuccess(w, finalHash, expectedFiles)
+}
+
+// ingestErrorStatus maps a source fetch error to the response status and message
+func ingestErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidSourceURL):
+		return http.StatusBadRequest, "Invalid source URL"
+	case errors.Is(err, utils.ErrSourceNotAllowed):
+		return http.StatusForbidden, "Source URL not allowed"
+	case errors.Is(err, utils.ErrSourceTooLarge):
+		return http.StatusRequestEntityTooLarge, "Source image too large"
+	case errors.Is(err, utils.ErrSourceNotImage):
+		return http.StatusUnsupportedMediaType, "Source is not an image"
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		return http.StatusGatewayTimeout, "Timed out fetching source"
+	default:
+		return http.StatusBadGateway, "Failed to fetch source"
+	}
+}
+
+// isTimeout reports whether err is a network timeout, e.g. from the fetch client's own deadline
+func isTimeout(err error) bool {
+	var timeoutErr interface{ Timeout() bool }
+	return errors.As(err, &timeoutErr) && timeoutErr.Timeout()
+}
+
+// ingestFilename derives the original file name from the final (post-redirect) source URL
+func ingestFilename(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == ".." || strings.ContainsAny(name, `/\`) {
+		return defaultIngestFilename
+	}
+	return name
+}
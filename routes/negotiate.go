@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"pixerve/config"
+	"pixerve/logger"
+	"pixerve/models"
+	"pixerve/success"
+	writerbackends "pixerve/writerBackends"
+)
+
+// formatContentTypes maps output formats to the media types clients list in Accept
+var formatContentTypes = map[string]string{
+	"avif": "image/avif",
+	"webp": "image/webp",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+}
+
+// universalFormats can be decoded by every client, so they also match wildcard Accept entries.
+// Other formats are only served to clients that list them explicitly, since browsers send
+// image/* without supporting every image format.
+var universalFormats = map[string]bool{"jpg": true, "png": true}
+
+// acceptQuality returns the q-value the Accept header gives format, 0 if it is not acceptable
+func acceptQuality(accept, format string) float64 {
+	contentType := formatContentTypes[format]
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+
+	exact, wildcard := -1.0, -1.0
+	for _, entry := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(entry))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case contentType:
+			exact = q
+		case "image/*", "*/*":
+			if q > wildcard {
+				wildcard = q
+			}
+		}
+	}
+
+	if exact >= 0 {
+		return exact
+	}
+	if universalFormats[format] && wildcard > 0 {
+		return wildcard
+	}
+	return 0
+}
+
+// negotiateFormat picks the format to serve from the available ones: the highest q-value in
+// Accept wins, ties go to the earlier format in preference. If the client accepts none of
+// them, the most preferred universal format is served instead. Returns "" if nothing fits.
+func negotiateFormat(accept string, preference []string, available map[string]string) string {
+	best, bestQ := "", 0.0
+	for _, format := range preference {
+		if _, ok := available[format]; !ok {
+			continue
+		}
+		if q := acceptQuality(accept, format); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best != "" {
+		return best
+	}
+	for _, format := range preference {
+		if _, ok := available[format]; ok && universalFormats[format] {
+			return format
+		}
+	}
+	return ""
+}
+
+// parseImageSize parses "<width>x<height>" or "<size>" for square images
+func parseImageSize(s string) (int, int, error) {
+	widthStr, heightStr, ok := strings.Cut(s, "x")
+	if !ok {
+		heightStr = widthStr
+	}
+	width, err := strconv.Atoi(widthStr)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid width %q", widthStr)
+	}
+	height, err := strconv.Atoi(heightStr)
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid height %q", heightStr)
+	}
+	return width, height, nil
+}
+
+// findVariants returns the directly served variants of a job at one size in dir, by format.
+// Only files the job itself wrote are considered, since the names of other uploads can share
+// the prefix (duplicates are named <hash>_<N>). Variants are named
+// <hash>_<original name>_<height>_<width>_.<ext> (see calculateExpectedFiles).
+func findVariants(dir, hash string, outputs []models.WrittenOutput, width, height int, formats []string) map[string]string {
+	available := make(map[string]string)
+	for _, format := range formats {
+		suffix := fmt.Sprintf("_%d_%d_.%s", height, width, getExtensionForEncoder(format))
+		for _, output := range outputs {
+			if output.Backend != writerbackends.DirectServeType || !output.Complete || output.Deleted ||
+				!strings.HasPrefix(output.File, hash+"_") || !strings.HasSuffix(output.File, suffix) {
+				continue
+			}
+			filePath := filepath.Join(dir, output.File)
+			if info, err := os.Stat(filePath); err == nil && info.Mode().IsRegular() {
+				available[format] = filePath
+				break
+			}
+		}
+	}
+	return available
+}
+
+// NegotiatedImageHandler serves a directly hosted variant in the best format the client supports:
+// GET /images/[<subDir>/]<hash>/<width>x<height> looks up the variants of that job and size and
+// picks one by the Accept header and PIXERVE_FORMAT_PREFERENCE (default avif > webp > jpg > png).
+// Responses carry Vary: Accept, and Content-Location names the chosen file under /files/.
+func NegotiatedImageHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Negotiated image request: method=%s, path=%s, remoteAddr=%s", r.Method, r.URL.Path, r.RemoteAddr)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		logger.Warnf("Invalid method for images endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/images/"), "/"), "/")
+	if len(segments) < 2 {
+		http.Error(w, "Expected /images/[<subDir>/]<hash>/<width>x<height>", http.StatusBadRequest)
+		return
+	}
+	hash, size := segments[len(segments)-2], segments[len(segments)-1]
+	// path.Clean on a rooted path drops any ".." that would escape the serve directory
+	subDir := strings.TrimPrefix(path.Clean("/"+strings.Join(segments[:len(segments)-2], "/")), "/")
+	if hash == "" || hash == "." || hash == ".." {
+		http.Error(w, "Invalid hash", http.StatusBadRequest)
+		return
+	}
+
+	width, height, err := parseImageSize(size)
+	if err != nil {
+		logger.Warnf("Invalid size in negotiated image request: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid size: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// The response differs by Accept even when it is an error
+	w.Header().Set("Vary", "Accept")
+
+	// The variants are the outputs recorded for the finished job
+	record, err := success.GetSuccess(hash)
+	if err != nil {
+		logger.Errorf("Failed to look up job %s: %v", hash, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if record == nil {
+		logger.Debugf("No finished job %s for negotiated image request", hash)
+		http.NotFound(w, r)
+		return
+	}
+
+	preference := config.GetFormatPreference()
+	dir := filepath.Join(config.GetDirectServeBaseDir(), filepath.FromSlash(subDir))
+	available := findVariants(dir, hash, record.Outputs, width, height, preference)
+	if len(available) == 0 {
+		logger.Debugf("No variants of %s at %dx%d in %s", hash, width, height, dir)
+		http.NotFound(w, r)
+		return
+	}
+
+	format := negotiateFormat(r.Header.Get("Accept"), preference, available)
+	if format == "" {
+		logger.Debugf("No acceptable variant of %s for Accept %q", hash, r.Header.Get("Accept"))
+		http.Error(w, "No acceptable image format", http.StatusNotAcceptable)
+		return
+	}
+
+	filePath := available[format]
+	f, err := os.Open(filePath)
+	if err != nil {
+		logger.Errorf("Failed to open variant %s: %v", filePath, err)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		logger.Errorf("Failed to stat variant %s: %v", filePath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	servedPath := path.Join("/", subDir, filepath.Base(filePath))
+	w.Header().Set("Content-Type", formatContentTypes[format])
+	w.Header().Set("Content-Location", (&url.URL{Path: "/files" + servedPath}).EscapedPath())
+	setCacheHeaders(w, servedPath, filePath, info)
+	logger.Debugf("Serving %s variant of %s: %s", format, hash, filePath)
+	http.ServeContent(w, r, filepath.Base(filePath), info.ModTime(), f)
+}
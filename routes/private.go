@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pixerve/config"
+	"pixerve/logger"
+	"pixerve/models"
+	"pixerve/success"
+	"pixerve/utils"
+	writerbackends "pixerve/writerBackends"
+)
+
+// defaultPrivateURLTTL is how long minted private URLs are valid unless the request sets ttl
+const defaultPrivateURLTTL = time.Hour
+
+// PrivateURLsResponse lists signed URLs for the private files of a job
+type PrivateURLsResponse struct {
+	Hash    string            `json:"hash"`
+	Expires time.Time         `json:"expires"`
+	URLs    map[string]string `json:"urls"` // filename -> URL path
+}
+
+// PrivateFileHandler serves files of private jobs from the private serve directory:
+// GET /private/<subDir>/<file>?expires=<unix>&sig=<signature>, with URLs minted by
+// PrivateURLsHandler (see utils.PrivateURL). There are no directory listings.
+func PrivateFileHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Private file request: method=%s, path=%s, remoteAddr=%s", r.Method, r.URL.Path, r.RemoteAddr)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		logger.Warnf("Invalid method for private file endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, err := config.GetPrivateURLKey()
+	if err != nil {
+		logger.Errorf("Failed to load private URL key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		// Private files cannot be served without a signing key
+		http.NotFound(w, r)
+		return
+	}
+
+	escapedPath := strings.TrimPrefix(r.URL.EscapedPath(), "/private")
+	if err := utils.VerifyPrivateURL(key, escapedPath, r.URL.Query(), time.Now()); err != nil {
+		logger.Warnf("Refused private URL %s: %v", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// path.Clean on a rooted path drops any ".." that would escape the private directory
+	urlPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/private"))
+	filePath := filepath.Join(config.GetPrivateServeBaseDir(), filepath.FromSlash(urlPath))
+	f, err := os.Open(filePath)
+	if err != nil {
+		logger.Warnf("Private file not found: %s", filePath)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Shared caches must not keep the file past the URL's expiry
+	w.Header().Set("Cache-Control", "private, no-store")
+	if etag, err := fileETag(filePath, info); err == nil {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	logger.Debug("Private file request completed successfully")
+}
+
+// PrivateURLsHandler mints signed, expiring URLs for the private files of a job:
+// GET /private-urls?hash=<hash>[&ttl=<duration>] with the Pixerve JWT as Bearer token.
+// The hash must be a finished job enqueued by the token's subject, and files are looked up in the
+// token's subDir.
+func PrivateURLsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Private URLs request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		logger.Warnf("Invalid method for private URLs endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := verifyJWT(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Unauthorized: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	key, err := config.GetPrivateURLKey()
+	if err != nil {
+		logger.Errorf("Failed to load private URL key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		logger.Warn("Private URLs requested but PIXERVE_PRIVATE_URL_KEY is not set")
+		http.Error(w, "Private URLs are not enabled", http.StatusNotFound)
+		return
+	}
+
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		logger.Warn("Missing hash parameter in private URLs request")
+		http.Error(w, "Missing hash parameter", http.StatusBadRequest)
+		return
+	}
+	if strings.ContainsAny(hash, `/\`) {
+		http.Error(w, "Invalid hash parameter", http.StatusBadRequest)
+		return
+	}
+	// The owner is recorded when the job is enqueued; hash suffixes do not identify it,
+	// since duplicate uploads of other subjects are numbered (see checkDuplicateUpload)
+	record, err := success.GetSuccess(hash)
+	if err != nil {
+		logger.Errorf("Failed to look up job %s: %v", hash, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if record == nil {
+		http.Error(w, fmt.Sprintf("No private files for hash %s", hash), http.StatusNotFound)
+		return
+	}
+	if record.Subject == "" || record.Subject != claims.Subject {
+		logger.Warnf("Subject %s requested private URLs for foreign hash %s", claims.Subject, hash)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ttl := defaultPrivateURLTTL
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			http.Error(w, fmt.Sprintf("Invalid ttl: %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	if maxTTL := config.GetPrivateURLMaxTTL(); ttl > maxTTL {
+		http.Error(w, fmt.Sprintf("ttl exceeds the maximum of %s", maxTTL), http.StatusBadRequest)
+		return
+	}
+
+	subDir := strings.TrimPrefix(path.Clean("/"+claims.Job.SubDir), "/")
+	files := privateFilesForHash(filepath.Join(config.GetPrivateServeBaseDir(), filepath.FromSlash(subDir)), hash, record.Outputs)
+	if len(files) == 0 {
+		http.Error(w, fmt.Sprintf("No private files for hash %s", hash), http.StatusNotFound)
+		return
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	response := PrivateURLsResponse{Hash: hash, Expires: expires, URLs: make(map[string]string, len(files))}
+	for _, name := range files {
+		response.URLs[name] = utils.PrivateURL(key, path.Join(subDir, name), expires)
+	}
+	logger.Infof("Minted %d private URLs for %s valid until %s", len(files), hash, expires.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Errorf("Failed to encode private URLs response: %v", err)
+		return
+	}
+	logger.Debug("Private URLs request completed successfully")
+}
+
+// privateFilesForHash returns the names of the files in dir that the job hash wrote:
+// complete, undeleted directServe outputs of its record. Matching names by the hash prefix
+// alone would also pick up jobs whose hash extends this one, e.g. <sha>_alice_x for <sha>_alice.
+func privateFilesForHash(dir, hash string, outputs []models.WrittenOutput) []string {
+	var files []string
+	for _, output := range outputs {
+		if output.Backend != writerbackends.DirectServeType || !output.Complete || output.Deleted ||
+			output.File != filepath.Base(output.File) || !strings.HasPrefix(output.File, hash+"_") {
+			continue
+		}
+		if info, err := os.Stat(filepath.Join(dir, output.File)); err == nil && info.Mode().IsRegular() {
+			files = append(files, output.File)
+		}
+	}
+	return files
+}
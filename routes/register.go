@@ -2,11 +2,15 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"pixerve/config"
 	"pixerve/credentials"
 	"pixerve/logger"
 	"pixerve/utils"
+	writerbackends "pixerve/writerBackends"
+	"time"
 )
 
 type S3Credentials struct {
@@ -20,6 +24,7 @@ func OpenCredentialsDB() error {
 	return credentials.OpenDB(config.GetCredentialsDBPath())
 }
 
+// DeregisterCredentialsHandler deletes a credential bundle and its metadata (admin endpoint)
 func DeregisterCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("Deregister credentials request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
 
@@ -37,6 +42,17 @@ func DeregisterCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := credentials.GetCredentials(keyString); err != nil {
+		if errors.Is(err, credentials.ErrNotFound) {
+			logger.Warnf("Deregister requested for unknown access key: %s", keyString)
+			http.Error(w, "Access key not found", http.StatusNotFound)
+			return
+		}
+		logger.Errorf("Failed to look up credentials for key %s: %v", keyString, err)
+		http.Error(w, "Failed to delete credentials", http.StatusInternalServerError)
+		return
+	}
+
 	logger.Infof("Attempting to delete credentials for access key: %s", keyString)
 	err := credentials.DeleteCredentials(keyString)
 
@@ -45,11 +61,15 @@ func DeregisterCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to delete credentials", http.StatusInternalServerError)
 		return
 	}
+	// Close pooled backend connections opened with the deleted bundle
+	writerbackends.InvalidateClients(keyString)
 
 	logger.Infof("Credentials deleted successfully for access key: %s", keyString)
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RegisterCredentialsHandler stores a credential bundle for the JWT subject and backend type
+// given in the query string and returns the generated access key (admin endpoint)
 func RegisterCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("Register credentials request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
 
@@ -59,6 +79,20 @@ func RegisterCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	subject := r.URL.Query().Get("subject")
+	backendType := r.URL.Query().Get("type")
+	if subject == "" || backendType == "" {
+		logger.Warn("Missing subject or type parameter in register request")
+		http.Error(w, "Missing subject or type parameter", http.StatusBadRequest)
+		return
+	}
+
+	if err := writerbackends.ValidateStorageType(backendType); err != nil {
+		logger.Warnf("Rejected register request for backend type %s: %v", backendType, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	logger.Debug("Generating access key for new credentials")
 	keyString, err := utils.GenerateRandomHex(16)
 
@@ -81,8 +115,18 @@ func RegisterCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	logger.Infof("Storing credentials for access key: %s", keyString)
-	err = credentials.StoreCredentials(keyString, credsBody)
+	if err := writerbackends.ValidateAccessInfo(backendType, credsBody); err != nil {
+		logger.Warnf("Rejected %s credentials for subject %s: %v", backendType, subject, err)
+		http.Error(w, fmt.Sprintf("Invalid %s credentials: %v", backendType, err), http.StatusBadRequest)
+		return
+	}
+
+	logger.Infof("Storing %s credentials for access key: %s (owner: %s)", backendType, keyString, subject)
+	err = credentials.RegisterCredentials(keyString, credentials.Metadata{
+		Owner:     subject,
+		Type:      backendType,
+		CreatedAt: time.Now(),
+	}, credsBody)
 
 	if err != nil {
 		logger.Errorf("Failed to store credentials for key %s: %v", keyString, err)
@@ -103,3 +147,74 @@ func RegisterCredentialsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Debug("Register credentials request completed successfully")
 }
+
+// CredentialsMetadataHandler returns the metadata of a single credential bundle (admin endpoint).
+// Secret values are never included in the response.
+func CredentialsMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Credentials metadata request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		logger.Warnf("Invalid method for credentials metadata endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keyString := r.URL.Query().Get("access_key")
+	if keyString == "" {
+		logger.Warn("Missing access_key parameter in credentials metadata request")
+		http.Error(w, "Missing access_key parameter", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := credentials.GetMetadata(keyString)
+	if err != nil {
+		if errors.Is(err, credentials.ErrNotFound) {
+			logger.Debugf("No credentials metadata for access key: %s", keyString)
+			http.Error(w, "Access key not found", http.StatusNotFound)
+			return
+		}
+		logger.Errorf("Failed to get credentials metadata for key %s: %v", keyString, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(meta); err != nil {
+		logger.Errorf("Failed to encode credentials metadata response: %v", err)
+		return
+	}
+	logger.Debug("Credentials metadata request completed successfully")
+}
+
+// CredentialsListHandler lists metadata of all credential bundles, optionally filtered
+// by owning subject (admin endpoint). Secret values are never included in the response.
+func CredentialsListHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Credentials list request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
+
+	if r.Method != http.MethodGet {
+		logger.Warnf("Invalid method for credentials list endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subject := r.URL.Query().Get("subject")
+	list, err := credentials.ListMetadata(subject)
+	if err != nil {
+		logger.Errorf("Failed to list credentials metadata: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infof("Retrieved %d credentials metadata records", len(list))
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"credentials": list,
+		"count":       len(list),
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Errorf("Failed to encode credentials list response: %v", err)
+		return
+	}
+	logger.Debug("Credentials list request completed successfully")
+}
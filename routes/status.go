@@ -6,15 +6,20 @@ import (
 	"net/http"
 	"pixerve/job"
 	"pixerve/logger"
+	"pixerve/models"
 )
 
 // JobStatusResponse represents the job status response
 type JobStatusResponse struct {
-	Hash  string `json:"hash"`
-	State string `json:"state"`
+	Hash            string                 `json:"hash"`
+	State           string                 `json:"state"`
+	Error           string                 `json:"error,omitempty"`
+	CancelRequested bool                   `json:"cancel_requested,omitempty"`
+	Outputs         []models.WrittenOutput `json:"outputs,omitempty"` // writes of a cancelled or failed job
 }
 
-// JobStatusHandler returns the status of a job by hash
+// JobStatusHandler returns the status of a job by hash.
+// It takes the same Pixerve JWT as /upload; jobs of other subjects are not found.
 func JobStatusHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("Job status request: method=%s, remoteAddr=%s", r.Method, r.RemoteAddr)
 
@@ -24,6 +29,13 @@ func JobStatusHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	claims, err := verifyJWT(r)
+	if err != nil {
+		logger.Errorf("JWT verification failed: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		return
+	}
+
 	hash := r.URL.Query().Get("hash")
 	if hash == "" {
 		logger.Warn("Missing hash parameter in status request")
@@ -32,7 +44,7 @@ func JobStatusHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	logger.Debugf("Checking status for job: %s", hash)
-	state, exists := job.GetJobState(hash)
+	state, rec, exists := job.GetJobDetailsFor(hash, claims.Subject)
 	if !exists {
 		logger.Warnf("Job not found: %s", hash)
 		http.Error(w, fmt.Sprintf("Job with hash %s not found", hash), http.StatusNotFound)
@@ -51,6 +63,8 @@ func JobStatusHandler(w http.ResponseWriter, r *http.Request) {
 		stateStr = "failed"
 	case job.JobStateCancelled:
 		stateStr = "cancelled"
+	case job.JobStateDeadLetter:
+		stateStr = "dead_letter"
 	default:
 		stateStr = "unknown"
 	}
@@ -58,8 +72,11 @@ func JobStatusHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debugf("Job status: hash=%s, state=%s", hash, stateStr)
 
 	response := JobStatusResponse{
-		Hash:  hash,
-		State: stateStr,
+		Hash:            hash,
+		State:           stateStr,
+		Error:           rec.Error,
+		CancelRequested: rec.CancelRequested,
+		Outputs:         rec.Outputs,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
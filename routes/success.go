@@ -61,6 +61,9 @@ func SuccessQueryHandler(w http.ResponseWriter, r *http.Request) {
 		"file_count": record.FileCount,
 		"job_data":   record.JobData,
 	}
+	if len(record.Outputs) > 0 {
+		response["outputs"] = record.Outputs
+	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		logger.Errorf("Failed to encode success response: %v", err)
 		return
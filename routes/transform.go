@@ -0,0 +1,283 @@
+package routes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pixerve/config"
+	"pixerve/encoder"
+	"pixerve/logger"
+	"pixerve/utils"
+)
+
+const (
+	// maxTransformDimension bounds the width and height of on-the-fly variants
+	maxTransformDimension = 8192
+	// transformQuality and transformSpeed are the encoder settings of on-the-fly variants
+	transformQuality = 80
+	transformSpeed   = 4
+)
+
+// transformLocks serializes generation of each cached variant, so concurrent requests
+// for a missing variant encode it once. Entries are removed once the variant is generated.
+var transformLocks sync.Map // cache path -> *sync.Mutex
+
+// transformSlots limits the variants encoded at once across all requests
+var (
+	transformSlotsOnce sync.Once
+	transformSlots     chan struct{}
+)
+
+// acquireTransformSlot waits until fewer than PIXERVE_TRANSFORM_CONCURRENCY variants are being
+// encoded, then returns a function that frees the slot again
+func acquireTransformSlot(ctx context.Context) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	transformSlotsOnce.Do(func() {
+		transformSlots = make(chan struct{}, config.GetTransformConcurrency())
+	})
+
+	select {
+	case transformSlots <- struct{}{}:
+		return func() { <-transformSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// transformRequest is a parsed /img/<signature>/<width>x<height>/<format>/<path> URL
+type transformRequest struct {
+	signature     string
+	transformPath string // "/<width>x<height>/<format>/<path>" as signed, still escaped
+	width, height int
+	format        string
+	filePath      string // unescaped path relative to the direct serve directory
+}
+
+// parseTransformPath splits an escaped request path below /img/ into its parts
+func parseTransformPath(escapedPath string) (transformRequest, error) {
+	rest := strings.TrimPrefix(escapedPath, "/img/")
+	signature, transformPath, ok := strings.Cut(rest, "/")
+	if !ok || signature == "" {
+		return transformRequest{}, fmt.Errorf("missing signature")
+	}
+	req := transformRequest{signature: signature, transformPath: "/" + transformPath}
+
+	parts := strings.SplitN(transformPath, "/", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return req, fmt.Errorf("expected /<width>x<height>/<format>/<path>")
+	}
+
+	widthStr, heightStr, ok := strings.Cut(parts[0], "x")
+	if !ok {
+		return req, fmt.Errorf("invalid size %q", parts[0])
+	}
+	var err error
+	if req.width, err = strconv.Atoi(widthStr); err != nil || req.width < 0 || req.width > maxTransformDimension {
+		return req, fmt.Errorf("invalid width %q", widthStr)
+	}
+	if req.height, err = strconv.Atoi(heightStr); err != nil || req.height < 0 || req.height > maxTransformDimension {
+		return req, fmt.Errorf("invalid height %q", heightStr)
+	}
+
+	if req.format, err = url.PathUnescape(parts[1]); err != nil {
+		return req, fmt.Errorf("invalid format: %w", err)
+	}
+	if req.filePath, err = url.PathUnescape(parts[2]); err != nil {
+		return req, fmt.Errorf("invalid path: %w", err)
+	}
+	return req, nil
+}
+
+// TransformHandler serves variants of files in the direct serve directory derived on first
+// request: /img/<signature>/<width>x<height>/<format>/<path> resizes the file at <path> to fit
+// within width x height and encodes it with the registered encoder for format. Results are
+// cached on disk and regenerated when the source changes. URLs must carry an HMAC signature
+// (see utils.TransformURL) so clients cannot request arbitrary sizes.
+func TransformHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Transform request: method=%s, path=%s, remoteAddr=%s", r.Method, r.URL.Path, r.RemoteAddr)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		logger.Warnf("Invalid method for transform endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, err := config.GetTransformKey()
+	if err != nil {
+		logger.Errorf("Failed to load transform key: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if key == nil {
+		// Transformations are disabled without a signing key
+		http.NotFound(w, r)
+		return
+	}
+
+	req, err := parseTransformPath(r.URL.EscapedPath())
+	if err != nil {
+		logger.Warnf("Invalid transform URL %s: %v", r.URL.Path, err)
+		http.Error(w, fmt.Sprintf("Invalid transform URL: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Check the signature before doing any work on behalf of the request
+	if !utils.VerifyTransformSignature(key, req.signature, req.transformPath) {
+		logger.Warnf("Invalid transform signature for %s", req.transformPath)
+		http.Error(w, "Invalid signature", http.StatusForbidden)
+		return
+	}
+
+	_, hasNative := encoder.GetNative(req.format)
+	_, hasCommand := encoder.Get(req.format)
+	if req.format == "copy" || (!hasNative && !hasCommand) {
+		logger.Warnf("Transform requested for unsupported format: %s", req.format)
+		http.Error(w, fmt.Sprintf("Unsupported format: %s", req.format), http.StatusBadRequest)
+		return
+	}
+
+	// path.Clean on a rooted path drops any ".." that would escape the serve directory
+	sourcePath := filepath.Join(config.GetDirectServeBaseDir(), filepath.FromSlash(path.Clean("/"+req.filePath)))
+	source, err := os.Stat(sourcePath)
+	if err != nil || !source.Mode().IsRegular() {
+		logger.Warnf("Transform source not found: %s", sourcePath)
+		http.NotFound(w, r)
+		return
+	}
+
+	cachePath, err := ensureVariant(r, req, sourcePath, source.ModTime())
+	if err != nil {
+		logger.Errorf("Failed to transform %s to %s %dx%d: %v", sourcePath, req.format, req.width, req.height, err)
+		http.Error(w, "Failed to transform image", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(cachePath)
+	if err != nil {
+		logger.Errorf("Failed to open cached variant %s: %v", cachePath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		logger.Errorf("Failed to stat cached variant %s: %v", cachePath, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, r, filepath.Base(cachePath), info.ModTime(), f)
+	logger.Debug("Transform request completed successfully")
+}
+
+// ensureVariant returns the cached variant for req, encoding it first if it is missing or
+// older than the source
+func ensureVariant(r *http.Request, req transformRequest, sourcePath string, sourceModTime time.Time) (string, error) {
+	sum := sha256.Sum256([]byte(req.transformPath))
+	name := hex.EncodeToString(sum[:])
+	cacheDir := filepath.Join(config.GetTransformCacheDir(), name[:2])
+	cachePath := filepath.Join(cacheDir, name+"."+getExtensionForEncoder(req.format))
+
+	if fresh(cachePath, sourceModTime) {
+		return cachePath, nil
+	}
+
+	value, _ := transformLocks.LoadOrStore(cachePath, &sync.Mutex{})
+	lock := value.(*sync.Mutex)
+	lock.Lock()
+	defer lock.Unlock()
+	// Requests still waiting on this lock find the variant fresh once it is released
+	defer transformLocks.CompareAndDelete(cachePath, lock)
+
+	// Another request may have encoded it while we waited
+	if fresh(cachePath, sourceModTime) {
+		return cachePath, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	release, err := acquireTransformSlot(r.Context())
+	if err != nil {
+		return "", fmt.Errorf("gave up waiting to encode: %w", err)
+	}
+	defer release()
+
+	// Encode next to the final path and rename, so readers never see a partial file.
+	// The temporary name keeps the extension, which command encoders use to pick the format.
+	tmpPath := filepath.Join(cacheDir, fmt.Sprintf(".tmp-%d-%s", time.Now().UnixNano(), filepath.Base(cachePath)))
+	defer os.Remove(tmpPath)
+
+	logger.Infof("Generating %s variant %dx%d of %s", req.format, req.width, req.height, sourcePath)
+	opts := encoder.EncodeOptions{
+		Width:   req.width,
+		Height:  req.height,
+		Quality: transformQuality,
+		Speed:   transformSpeed,
+	}
+	// Other requests may be waiting for this variant, so a client that goes away does not
+	// abort the encode; the timeout bounds it instead
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), config.GetTransformTimeout())
+	defer cancel()
+	if err := encoder.NewPipeline(sourcePath).Encode(ctx, req.format, tmpPath, opts); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		return "", fmt.Errorf("failed to store variant: %w", err)
+	}
+	return cachePath, nil
+}
+
+// fresh reports whether a cached variant exists and is at least as new as its source
+func fresh(cachePath string, sourceModTime time.Time) bool {
+	info, err := os.Stat(cachePath)
+	return err == nil && !info.ModTime().Before(sourceModTime)
+}
+
+// CleanupTransformCache removes cached variants not regenerated within maxAge.
+// They are derived again on the next request.
+func CleanupTransformCache(maxAge time.Duration) (int, error) {
+	cacheDir := config.GetTransformCacheDir()
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+
+	err := filepath.WalkDir(cacheDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil {
+			logger.Warnf("Failed to remove cached variant %s: %v", p, err)
+			return nil
+		}
+		transformLocks.Delete(p)
+		removed++
+		return nil
+	})
+	return removed, err
+}
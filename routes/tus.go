@@ -0,0 +1,494 @@
+package routes
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"pixerve/config"
+	"pixerve/job"
+	"pixerve/logger"
+	"pixerve/models"
+)
+
+// Resumable uploads following the tus 1.0.0 protocol (https://tus.io/protocols/resumable-upload),
+// with the creation and termination extensions:
+//
+//	OPTIONS /uploads/       -> protocol discovery
+//	POST    /uploads/       -> create an upload (Upload-Length, Upload-Metadata with filename)
+//	HEAD    /uploads/<id>   -> current Upload-Offset
+//	PATCH   /uploads/<id>   -> append a chunk at Upload-Offset
+//	DELETE  /uploads/<id>   -> abandon an upload
+//
+// Every request except OPTIONS carries the same Pixerve JWT as /upload, and only the subject that
+// created an upload may touch it. Chunks are appended to a file in the temp area; once the last
+// chunk lands the file is hashed and queued exactly like a multipart upload, using the job spec of
+// the token that completed it. The job hash is returned in the Pixerve-Job-Hash header.
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,termination"
+	tusUploadsPath = "/uploads/"
+	tusDirPrefix   = ".pixerve-tus-"
+	tusInfoFile    = "upload.json"
+	tusDataFile    = "data"
+)
+
+// tusUpload is the persisted state of a resumable upload.
+// The current offset is the size of the data file, so it survives restarts without extra bookkeeping.
+type tusUpload struct {
+	ID        string    `json:"id"`
+	Subject   string    `json:"subject"`
+	Length    int64     `json:"length"`
+	Filename  string    `json:"filename"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// tusLocks serializes requests per upload so chunks cannot interleave.
+// Entries are only created for authorized requests to existing uploads and are removed with the upload.
+var tusLocks sync.Map // upload ID -> *sync.Mutex
+
+// TusHandler serves the resumable upload endpoint
+func TusHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debugf("Resumable upload request: method=%s, path=%s, remoteAddr=%s", r.Method, r.URL.Path, r.RemoteAddr)
+
+	w.Header().Set("Tus-Resumable", tusVersion)
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(config.GetMaxUploadSize(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		logger.Warnf("Unsupported tus version: %q", r.Header.Get("Tus-Resumable"))
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(tusUploadsPath, "/")), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		createTusUpload(w, r)
+	case id == "":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case !isValidTusID(id):
+		http.Error(w, "Upload not found", http.StatusNotFound)
+	case r.Method == http.MethodHead:
+		headTusUpload(w, r, id)
+	case r.Method == http.MethodPatch:
+		patchTusUpload(w, r, id)
+	case r.Method == http.MethodDelete:
+		deleteTusUpload(w, r, id)
+	default:
+		logger.Warnf("Invalid method for resumable upload endpoint: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// createTusUpload handles POST: validates the token, size and filename and allocates the upload
+func createTusUpload(w http.ResponseWriter, r *http.Request) {
+	claims, err := verifyJWT(r)
+	if err != nil {
+		logger.Errorf("JWT verification failed: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		return
+	}
+
+	if err := job.AuthorizeStorageKeys(claims.Subject, claims.Job.StorageKeys); err != nil {
+		logger.Warnf("Storage key authorization failed for subject %s: %v", claims.Subject, err)
+		http.Error(w, fmt.Sprintf("Storage keys not authorized: %v", err), http.StatusForbidden)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		logger.Warnf("Invalid Upload-Length: %q", r.Header.Get("Upload-Length"))
+		http.Error(w, "Invalid or missing Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if maxSize := config.GetMaxUploadSizeFor(claims.Subject); length > maxSize {
+		logger.Warnf("Resumable upload too large: %d bytes (max: %d)", length, maxSize)
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		logger.Warnf("Invalid Upload-Metadata: %v", err)
+		http.Error(w, "Invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	filename := filepath.Base(metadata["filename"])
+	if metadata["filename"] == "" || filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		logger.Warn("Missing or invalid filename in Upload-Metadata")
+		http.Error(w, "Upload-Metadata must include a filename", http.StatusBadRequest)
+		return
+	}
+
+	upload := tusUpload{
+		ID:        newTusID(),
+		Subject:   claims.Subject,
+		Length:    length,
+		Filename:  filename,
+		CreatedAt: time.Now(),
+	}
+	if err := saveTusUpload(upload); err != nil {
+		logger.Errorf("Failed to create resumable upload: %v", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Infof("Resumable upload created: id=%s, subject=%s, file=%s, length=%d", upload.ID, upload.Subject, filename, length)
+	w.Header().Set("Location", tusUploadsPath+upload.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// headTusUpload handles HEAD: reports how much of the upload has been received
+func headTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	upload, _, ok := authorizeTusUpload(w, r, id)
+	if !ok {
+		return
+	}
+
+	offset, err := tusOffset(id)
+	if err != nil {
+		logger.Errorf("Failed to read offset of upload %s: %v", id, err)
+		http.Error(w, "Failed to read upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchTusUpload handles PATCH: appends a chunk and queues the job once the upload is complete
+func patchTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	upload, claims, ok := authorizeTusUpload(w, r, id)
+	if !ok {
+		return
+	}
+
+	lock := tusLock(id)
+	if !lock.TryLock() {
+		logger.Warnf("Concurrent chunk rejected for upload %s", id)
+		http.Error(w, "Another chunk is being written to this upload", http.StatusConflict)
+		return
+	}
+	defer lock.Unlock()
+
+	// The upload may have been completed or removed while we were authorizing
+	if !tusUploadExists(id) {
+		tusLocks.CompareAndDelete(id, lock)
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+
+	offset, err := tusOffset(id)
+	if err != nil {
+		logger.Errorf("Failed to read offset of upload %s: %v", id, err)
+		http.Error(w, "Failed to read upload", http.StatusInternalServerError)
+		return
+	}
+	requested, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requested != offset {
+		logger.Warnf("Offset mismatch for upload %s: got %q, at %d", id, r.Header.Get("Upload-Offset"), offset)
+		http.Error(w, "Upload-Offset does not match the current offset", http.StatusConflict)
+		return
+	}
+
+	remaining := upload.Length - offset
+	if r.ContentLength > remaining {
+		logger.Warnf("Chunk for upload %s exceeds Upload-Length: %d > %d remaining", id, r.ContentLength, remaining)
+		http.Error(w, "Chunk exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Append what arrives; bytes received before a dropped connection are kept so the client can resume
+	written, copyErr := appendTusChunk(id, r.Body, remaining)
+	offset += written
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if copyErr != nil {
+		logger.Warnf("Chunk for upload %s interrupted at offset %d: %v", id, offset, copyErr)
+		http.Error(w, "Failed to write chunk", http.StatusInternalServerError)
+		return
+	}
+	logger.Debugf("Chunk written to upload %s: %d bytes, offset %d/%d", id, written, offset, upload.Length)
+
+	if offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// The last chunk landed: hand the file over to the regular upload path
+	finalHash, ok := completeTusUpload(w, claims, upload)
+	if !ok {
+		return
+	}
+	w.Header().Set("Pixerve-Job-Hash", finalHash)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeTusUpload hashes a finished upload and queues it with the job spec of the completing token.
+// The upload is only removed once the job is queued; if queueing fails the client can retry by
+// sending an empty PATCH at the final offset.
+func completeTusUpload(w http.ResponseWriter, claims *models.PixerveJWT, upload tusUpload) (string, bool) {
+	if err := job.AuthorizeStorageKeys(claims.Subject, claims.Job.StorageKeys); err != nil {
+		logger.Warnf("Storage key authorization failed for subject %s: %v", claims.Subject, err)
+		http.Error(w, fmt.Sprintf("Storage keys not authorized: %v", err), http.StatusForbidden)
+		return "", false
+	}
+
+	dir := tusDir(upload.ID)
+	hashSum, err := hashFile(filepath.Join(dir, tusDataFile))
+	if err != nil {
+		logger.Errorf("Failed to hash upload %s: %v", upload.ID, err)
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return "", false
+	}
+
+	// Stage the file under its original name, as a multipart upload would be. The data is linked
+	// rather than moved, so it stays in the upload until the job is queued.
+	stagingDir, err := createStagingDir()
+	if err != nil {
+		http.Error(w, "Failed to create temp directory", http.StatusInternalServerError)
+		return "", false
+	}
+	defer os.RemoveAll(stagingDir)
+
+	if err := os.Link(filepath.Join(dir, tusDataFile), filepath.Join(stagingDir, upload.Filename)); err != nil {
+		logger.Errorf("Failed to stage upload %s: %v", upload.ID, err)
+		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+		return "", false
+	}
+
+	finalHash, expectedFiles, ok := queueUpload(w, claims, stagingDir, upload.Filename, hashSum)
+	if !ok {
+		logger.Warnf("Resumable upload %s kept for retry after queueing failed", upload.ID)
+		return "", false
+	}
+	removeTusUpload(upload.ID)
+
+	logger.Infof("Resumable upload %s completed: hash=%s, files=%v", upload.ID, finalHash, expectedFiles)
+	return finalHash, true
+}
+
+// deleteTusUpload handles DELETE: abandons an unfinished upload
+func deleteTusUpload(w http.ResponseWriter, r *http.Request, id string) {
+	if _, _, ok := authorizeTusUpload(w, r, id); !ok {
+		return
+	}
+
+	lock := tusLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !tusUploadExists(id) {
+		tusLocks.CompareAndDelete(id, lock)
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	removeTusUpload(id)
+
+	logger.Infof("Resumable upload terminated: %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeTusUpload verifies the request's JWT and loads the upload, which must belong to the token's subject
+func authorizeTusUpload(w http.ResponseWriter, r *http.Request, id string) (tusUpload, *models.PixerveJWT, bool) {
+	claims, err := verifyJWT(r)
+	if err != nil {
+		logger.Errorf("JWT verification failed: %v", err)
+		http.Error(w, fmt.Sprintf("Invalid token: %v", err), http.StatusUnauthorized)
+		return tusUpload{}, nil, false
+	}
+
+	upload, err := loadTusUpload(id)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Errorf("Failed to load upload %s: %v", id, err)
+		}
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return tusUpload{}, nil, false
+	}
+	if upload.Subject != claims.Subject {
+		// Uploads of other subjects are indistinguishable from missing ones
+		logger.Warnf("Subject %s attempted to access upload %s of another subject", claims.Subject, id)
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return tusUpload{}, nil, false
+	}
+	return upload, claims, true
+}
+
+// CleanupStaleUploads removes resumable uploads created more than maxAge ago that were never completed
+func CleanupStaleUploads(maxAge time.Duration) (int, error) {
+	dirs, err := filepath.Glob(filepath.Join(os.TempDir(), tusDirPrefix+"*"))
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, dir := range dirs {
+		id := strings.TrimPrefix(filepath.Base(dir), tusDirPrefix)
+		upload, err := loadTusUpload(id)
+		if err == nil && upload.CreatedAt.After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Errorf("Failed to remove stale upload %s: %v", dir, err)
+			continue
+		}
+		tusLocks.Delete(id)
+		removed++
+	}
+	return removed, nil
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated "key base64value" pairs
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// newTusID returns a random upload ID
+func newTusID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isValidTusID reports whether id has the format produced by newTusID
+func isValidTusID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func tusDir(id string) string {
+	return filepath.Join(os.TempDir(), tusDirPrefix+id)
+}
+
+func tusLock(id string) *sync.Mutex {
+	lock, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// saveTusUpload creates the upload directory with its info file and an empty data file
+func saveTusUpload(upload tusUpload) error {
+	dir := tusDir(upload.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, tusInfoFile), data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, tusDataFile), nil, 0644); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+func loadTusUpload(id string) (tusUpload, error) {
+	data, err := os.ReadFile(filepath.Join(tusDir(id), tusInfoFile))
+	if err != nil {
+		return tusUpload{}, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return tusUpload{}, fmt.Errorf("invalid upload info: %w", err)
+	}
+	return upload, nil
+}
+
+// tusUploadExists reports whether the upload has not been completed or removed
+func tusUploadExists(id string) bool {
+	_, err := os.Stat(filepath.Join(tusDir(id), tusInfoFile))
+	return err == nil
+}
+
+func removeTusUpload(id string) {
+	if err := os.RemoveAll(tusDir(id)); err != nil {
+		logger.Errorf("Failed to remove upload %s: %v", id, err)
+	}
+	tusLocks.Delete(id)
+}
+
+// tusOffset returns how many bytes of an upload have been received
+func tusOffset(id string) (int64, error) {
+	info, err := os.Stat(filepath.Join(tusDir(id), tusDataFile))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// appendTusChunk appends at most limit bytes from reader to the upload's data file
+func appendTusChunk(id string, reader io.Reader, limit int64) (int64, error) {
+	f, err := os.OpenFile(filepath.Join(tusDir(id), tusDataFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, io.LimitReader(reader, limit))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// hashFile computes the SHA256 hash of a file without loading it into memory
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
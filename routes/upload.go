@@ -3,8 +3,10 @@ package routes
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,10 +17,12 @@ import (
 	"pixerve/job"
 	"pixerve/logger"
 	"pixerve/models"
+	"pixerve/taskQueue"
 	"pixerve/utils"
 )
 
-// verifyJWT verifies the JWT from the request and returns the claims
+// verifyJWT verifies the JWT from the request and returns the claims.
+// Key material, issuer, audience and clock skew come from server configuration (see utils.LoadVerifyConfig).
 func verifyJWT(r *http.Request) (*models.PixerveJWT, error) {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
@@ -32,10 +36,14 @@ func verifyJWT(r *http.Request) (*models.PixerveJWT, error) {
 		return nil, fmt.Errorf("invalid authorization header format")
 	}
 
+	verifyConfig, err := utils.LoadVerifyConfig()
+	if err != nil {
+		logger.Errorf("Failed to load JWT verification keys: %v", err)
+		return nil, fmt.Errorf("JWT verification is not configured correctly")
+	}
+
 	logger.Debug("Verifying JWT token")
-	claims, err := utils.VerifyPixerveJWT(token, utils.VerifyConfig{
-		SecretKey: []byte(config.SHARED_JWT_SECRET),
-	})
+	claims, err := utils.VerifyPixerveJWT(token, verifyConfig)
 	if err != nil {
 		logger.Errorf("JWT verification failed: %v", err)
 		return nil, err
@@ -44,18 +52,72 @@ func verifyJWT(r *http.Request) (*models.PixerveJWT, error) {
 	return claims, nil
 }
 
-// computeHash computes SHA256 hash from io.Reader
-func computeHash(reader io.Reader) (string, error) {
-	logger.Debug("Computing SHA256 hash")
+// multipartOverhead is the allowance for multipart boundaries, part headers and small
+// form fields on top of a subject's file size limit when capping the request body
+const multipartOverhead = 1 << 20 // 1 MB
+
+var (
+	// errUploadTooLarge is returned when an uploaded file exceeds the subject's size limit
+	errUploadTooLarge = errors.New("upload exceeds the maximum size")
+	// errNoFilePart is returned when a multipart form has no (further) file part
+	errNoFilePart = errors.New("no file part in multipart form")
+)
+
+// nextFilePart returns the "file" part of a multipart request, skipping any other fields
+func nextFilePart(reader *multipart.Reader) (*multipart.Part, error) {
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errNoFilePart
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" && part.FileName() != "" {
+			if name := part.FileName(); name == "." || name == ".." || name == string(filepath.Separator) {
+				part.Close()
+				return nil, fmt.Errorf("invalid file name %q", name)
+			}
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// streamToFile copies reader into destPath while computing its SHA256 hash, without
+// buffering the content in memory. At most limit bytes are accepted; a larger body
+// fails with errUploadTooLarge. The partial file is removed on any error.
+func streamToFile(reader io.Reader, destPath string, limit int64) (string, int64, error) {
+	logger.Debugf("Streaming upload to %s (limit %d bytes)", destPath, limit)
+	f, err := os.OpenFile(destPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", 0, err
+	}
+
 	hash := sha256.New()
-	_, err := io.Copy(hash, reader)
+	// Read one byte past the limit to detect oversized uploads
+	written, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(reader, limit+1))
+	if err == nil && written > limit {
+		err = errUploadTooLarge
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		logger.Errorf("Failed to compute hash: %v", err)
-		return "", err
+		os.Remove(destPath)
+		return "", written, err
 	}
+
 	hashStr := hex.EncodeToString(hash.Sum(nil))
 	logger.Debugf("Hash computed: %s", hashStr)
-	return hashStr, nil
+	return hashStr, written, nil
+}
+
+// isTooLarge reports whether err means the upload exceeded its size limit,
+// either for the file itself or for the request body as a whole
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.Is(err, errUploadTooLarge) || errors.As(err, &maxBytesErr)
 }
 
 // checkDuplicateUpload checks if user is uploading a duplicate and returns the final hash to use
@@ -108,30 +170,39 @@ func checkDuplicateUpload(hashSum string, userSubject string) (string, bool, err
 	return finalHash, false, nil
 }
 
-// createTempDir creates temp directory with hash name
-func createTempDir(hash string) (string, error) {
-	tempDir := filepath.Join(os.TempDir(), hash)
-	logger.Debugf("Creating temp directory: %s", tempDir)
-	err := os.MkdirAll(tempDir, 0755)
+// createStagingDir creates a uniquely named directory to stream an upload into before its hash is known.
+// The name cannot collide with job directories, which are named after the hash.
+func createStagingDir() (string, error) {
+	stagingDir, err := os.MkdirTemp(os.TempDir(), ".pixerve-upload-*")
 	if err != nil {
-		logger.Errorf("Failed to create temp directory %s: %v", tempDir, err)
+		logger.Errorf("Failed to create staging directory: %v", err)
+		return "", err
+	}
+	logger.Debugf("Staging directory created: %s", stagingDir)
+	return stagingDir, nil
+}
+
+// promoteStagingDir renames a staging directory to the job directory for hash
+func promoteStagingDir(stagingDir, hash string) (string, error) {
+	tempDir := filepath.Join(os.TempDir(), hash)
+	logger.Debugf("Moving %s to job directory %s", stagingDir, tempDir)
+	if err := os.Rename(stagingDir, tempDir); err != nil {
+		logger.Errorf("Failed to move staging directory to %s: %v", tempDir, err)
 		return "", err
 	}
-	logger.Debugf("Temp directory created successfully: %s", tempDir)
 	return tempDir, nil
 }
 
-// saveFile saves data to file in dir
-func saveFile(dir, filename string, data []byte) error {
-	destPath := filepath.Join(dir, filename)
-	logger.Debugf("Saving file: %s", destPath)
-	err := os.WriteFile(destPath, data, 0644)
-	if err != nil {
-		logger.Errorf("Failed to save file %s: %v", destPath, err)
-		return err
+// demoteJobDir undoes promoteStagingDir for a job that could not be queued, so the caller's
+// cleanup of the staging directory removes it. A job directory left behind would make every
+// retry of the upload look like a duplicate.
+func demoteJobDir(tempDir, stagingDir string) {
+	if err := os.Rename(tempDir, stagingDir); err == nil {
+		return
+	}
+	if err := os.RemoveAll(tempDir); err != nil {
+		logger.Errorf("Failed to remove job directory %s of unqueued upload: %v", tempDir, err)
 	}
-	logger.Debugf("File saved successfully: %s (%d bytes)", destPath, len(data))
-	return nil
 }
 
 // respondSuccess sends success response
@@ -218,114 +289,151 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Infof("JWT verified successfully for subject: %s", claims.Subject)
 
-	// Parse multipart form
-	logger.Debug("Parsing multipart form data")
-	err = r.ParseMultipartForm(32 << 20) // 32 MB max
+	// Only allow the subject to reference storage keys registered to it
+	if err := job.AuthorizeStorageKeys(claims.Subject, claims.Job.StorageKeys); err != nil {
+		logger.Warnf("Storage key authorization failed for subject %s: %v", claims.Subject, err)
+		http.Error(w, fmt.Sprintf("Storage keys not authorized: %v", err), http.StatusForbidden)
+		return
+	}
+
+	// Enforce the subject's size limit before and while reading the body
+	maxFileSize := config.GetMaxUploadSizeFor(claims.Subject)
+	if r.ContentLength > maxFileSize+multipartOverhead {
+		logger.Warnf("Upload too large: content-length %d bytes (max: %d)", r.ContentLength, maxFileSize)
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize+multipartOverhead)
+
+	// Stream the file part of the multipart form
+	logger.Debug("Reading multipart form data")
+	reader, err := r.MultipartReader()
 	if err != nil {
-		logger.Errorf("Failed to parse multipart form: %v", err)
+		logger.Errorf("Failed to read multipart form: %v", err)
 		http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	part, err := nextFilePart(reader)
 	if err != nil {
 		logger.Errorf("Failed to get file from form: %v", err)
+		if isTooLarge(err) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Failed to get file from form", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
-	logger.Infof("File received: %s, size: %d bytes", header.Filename, header.Size)
+	filename := part.FileName()
+	logger.Infof("File received: %s", filename)
 
-	// Check file size limit (100MB) to prevent memory exhaustion
-	const maxFileSize = 100 << 20 // 100 MB
-	if header.Size > maxFileSize {
-		logger.Warnf("File too large: %d bytes (max: %d)", header.Size, maxFileSize)
-		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+	stagingDir, err := createStagingDir()
+	if err != nil {
+		http.Error(w, "Failed to create temp directory", http.StatusInternalServerError)
 		return
 	}
+	// Removed on every error path; a no-op once the directory has been promoted
+	defer os.RemoveAll(stagingDir)
 
-	// Compute SHA256 hash
-	logger.Debug("Computing SHA256 hash of file")
-	hashSum, err := computeHash(file)
+	// Write the file to disk while computing its SHA256 hash
+	hashSum, size, err := streamToFile(part, filepath.Join(stagingDir, filename), maxFileSize)
 	if err != nil {
-		logger.Errorf("Failed to compute file hash: %v", err)
+		if isTooLarge(err) {
+			logger.Warnf("File too large: more than %d bytes (max: %d)", size, maxFileSize)
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		logger.Errorf("Failed to save uploaded file: %v", err)
 		http.Error(w, "Failed to read file", http.StatusInternalServerError)
 		return
 	}
-	logger.Debugf("File hash computed: %s", hashSum)
+	logger.Debugf("File streamed successfully: %s (%d bytes, hash %s)", filename, size, hashSum)
 
-	// Check for duplicate uploads (user-aware)
-	logger.Debug("Checking for duplicate uploads")
-	finalHash, isDuplicate, err := checkDuplicateUpload(hashSum, claims.Subject)
-	if err != nil {
-		logger.Errorf("Failed to check for duplicates: %v", err)
-		http.Error(w, err.Error(), http.StatusConflict)
+	finalHash, expectedFiles, ok := queueUpload(w, claims, stagingDir, filename, hashSum)
+	if !ok {
 		return
 	}
 
-	if isDuplicate {
-		logger.Warnf("Upload rejected: duplicate file for user %s", claims.Subject)
-		http.Error(w, fmt.Sprintf("File with hash %s is already being processed by this user", hashSum), http.StatusConflict)
-		return
+	logger.Infof("Upload completed successfully: hash=%s, files=%v", finalHash, expectedFiles)
+	respondSuccess(w, finalHash, expectedFiles)
+}
+
+// queueUpload turns a fully received upload into a job: it resolves duplicates, moves the staging
+// directory holding filename into the job directory, writes instructions.json and enqueues the job.
+// Shared by UploadHandler and the tus endpoint. On failure the error response has already been
+// written and ok is false.
+func queueUpload(w http.ResponseWriter, claims *models.PixerveJWT, stagingDir, filename, hashSum string) (string, []string, bool) {
+	finalHash, expectedFiles, err := enqueueUpload(claims, stagingDir, filename, hashSum, "")
+	if err != nil {
+		http.Error(w, err.Message, err.Status)
+		return "", nil, false
 	}
+	return finalHash, expectedFiles, true
+}
 
-	logger.Debugf("Using hash for processing: %s", finalHash)
+// uploadError is a failure to queue an upload along with the response status it maps to
+type uploadError struct {
+	Status  int
+	Message string
+}
 
-	// Reset file pointer to beginning
-	file.Seek(0, 0)
+func (e *uploadError) Error() string { return e.Message }
 
-	// Create temp directory with final hash
-	logger.Debugf("Creating temporary directory: %s", finalHash)
-	tempDir, err := createTempDir(finalHash)
+// enqueueUpload is queueUpload without the HTTP response, for callers that queue several
+// files per request. If group is set, the job is added to that job group.
+func enqueueUpload(claims *models.PixerveJWT, stagingDir, filename, hashSum, group string) (string, []string, *uploadError) {
+	// Parse job from claims
+	logger.Debug("Parsing job specifications from JWT claims")
+	combinedJob, err := job.ParseTokenIntoJobsFromClaims(claims)
 	if err != nil {
-		logger.Errorf("Failed to create temp directory: %v", err)
-		http.Error(w, "Failed to create temp directory", http.StatusInternalServerError)
-		return
+		logger.Errorf("Failed to parse job from claims: %v", err)
+		return "", nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("Failed to parse job: %v", err)}
 	}
-	logger.Debugf("Temporary directory created: %s", tempDir)
+	logger.Infof("Job parsed successfully: %d conversion jobs", len(combinedJob.ConversionJobs))
 
-	// Read file data
-	logger.Debug("Reading file data into memory")
-	data, err := io.ReadAll(file)
+	// Check for duplicate uploads (user-aware)
+	logger.Debug("Checking for duplicate uploads")
+	finalHash, isDuplicate, err := checkDuplicateUpload(hashSum, claims.Subject)
 	if err != nil {
-		logger.Errorf("Failed to read file data: %v", err)
-		http.Error(w, "Failed to read file data", http.StatusInternalServerError)
-		return
+		logger.Errorf("Failed to check for duplicates: %v", err)
+		return "", nil, &uploadError{http.StatusConflict, err.Error()}
 	}
-	logger.Debugf("File data read successfully: %d bytes", len(data))
 
-	// Save file with original name
-	logger.Debugf("Saving file to temp directory: %s", header.Filename)
-	err = saveFile(tempDir, header.Filename, data)
-	if err != nil {
-		logger.Errorf("Failed to save file: %v", err)
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
-		return
+	if isDuplicate {
+		logger.Warnf("Upload rejected: duplicate file for user %s", claims.Subject)
+		return "", nil, &uploadError{http.StatusConflict, fmt.Sprintf("File with hash %s is already being processed by this user", hashSum)}
 	}
-	logger.Debugf("File saved successfully: %s", filepath.Join(tempDir, header.Filename))
 
-	// Parse job from claims
-	logger.Debug("Parsing job specifications from JWT claims")
-	combinedJob, err := job.ParseTokenIntoJobsFromClaims(claims)
+	logger.Debugf("Using hash for processing: %s", finalHash)
+
+	// Move the staged upload into the job directory named after the final hash
+	tempDir, err := promoteStagingDir(stagingDir, finalHash)
 	if err != nil {
-		logger.Errorf("Failed to parse job from claims: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to parse job: %v", err), http.StatusBadRequest)
-		return
+		return "", nil, &uploadError{http.StatusInternalServerError, "Failed to create temp directory"}
 	}
-	logger.Infof("Job parsed successfully: %d conversion jobs", len(combinedJob.ConversionJobs))
+	logger.Debugf("Temporary directory created: %s", tempDir)
+	queued := false
+	defer func() {
+		if !queued {
+			demoteJobDir(tempDir, stagingDir)
+		}
+	}()
 
 	// Calculate expected output filenames
 	logger.Debug("Calculating expected output filenames")
-	expectedFiles := calculateExpectedFiles(finalHash, header.Filename, combinedJob.ConversionJobs)
+	expectedFiles := calculateExpectedFiles(finalHash, filename, combinedJob.ConversionJobs)
 	logger.Debugf("Expected output files: %v", expectedFiles)
 
 	// Create instructions
 	instr := job.JobInstructions{
 		FilePath:     tempDir,
-		OriginalFile: header.Filename,
+		OriginalFile: filename,
 		Hash:         finalHash,
 		Job:          combinedJob,
+		Group:        group,
+		Subject:      claims.Subject,
 	}
 
 	// Write instructions.json
@@ -333,15 +441,25 @@ func UploadHandler(w http.ResponseWriter, r *http.Request) {
 	err = job.WriteInstructions(tempDir, instr)
 	if err != nil {
 		logger.Errorf("Failed to write instructions: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to write instructions: %v", err), http.StatusInternalServerError)
-		return
+		return "", nil, &uploadError{http.StatusInternalServerError, fmt.Sprintf("Failed to write instructions: %v", err)}
 	}
 	logger.Debug("Job instructions written successfully")
 
 	// Add to pending jobs
 	logger.Info("Adding job to pending queue")
-	job.AddPendingJob(tempDir)
+	if group != "" {
+		err = job.AddPendingGroupJob(tempDir, combinedJob.Priority, group, filename, claims.Subject)
+	} else {
+		err = job.AddPendingJobFor(tempDir, combinedJob.Priority, claims.Subject)
+	}
+	if err != nil {
+		logger.Errorf("Failed to queue job: %v", err)
+		if errors.Is(err, taskQueue.ErrJobActive) {
+			return "", nil, &uploadError{http.StatusConflict, "An identical image is currently being processed"}
+		}
+		return "", nil, &uploadError{http.StatusInternalServerError, "Failed to queue job"}
+	}
 
-	logger.Infof("Upload completed successfully: hash=%s, files=%v", finalHash, expectedFiles)
-	respondSuccess(w, finalHash, expectedFiles)
+	queued = true
+	return finalHash, expectedFiles, nil
 }
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"time"
 
+	"pixerve/models"
+
 	pebble "github.com/cockroachdb/pebble"
 )
 
@@ -12,8 +14,11 @@ import (
 type SuccessRecord struct {
 	Hash      string    `json:"hash"`
 	Timestamp time.Time `json:"timestamp"`
-	JobData   string    `json:"job_data"`   // JSON string of the job instructions
-	FileCount int       `json:"file_count"` // Number of files generated
+	JobData   string    `json:"job_data"`          // JSON string of the job instructions
+	FileCount int       `json:"file_count"`        // Number of files generated
+	Subject   string    `json:"subject,omitempty"` // JWT subject that enqueued the job
+	// Outputs lists each file written to each storage backend with its checksums
+	Outputs []models.WrittenOutput `json:"outputs,omitempty"`
 }
 
 var db *pebble.DB
@@ -31,13 +36,21 @@ func Init(dbPath string) error {
 // Close closes the success store
 func Close() error {
 	if db != nil {
-		return db.Close()
+		err := db.Close()
+		db = nil
+		return err
 	}
 	return nil
 }
 
 // StoreSuccess stores a successful job completion
 func StoreSuccess(hash string, jobData interface{}, fileCount int) error {
+	return StoreSuccessWithOutputs(hash, "", jobData, fileCount, nil)
+}
+
+// StoreSuccessWithOutputs stores a successful job completion along with the subject that
+// enqueued it and the outputs it wrote with their checksums, so deliveries can be audited later
+func StoreSuccessWithOutputs(hash, subject string, jobData interface{}, fileCount int, outputs []models.WrittenOutput) error {
 	if db == nil {
 		return fmt.Errorf("success store not initialized")
 	}
@@ -53,6 +66,8 @@ func StoreSuccess(hash string, jobData interface{}, fileCount int) error {
 		Timestamp: time.Now(),
 		JobData:   string(jobJSON),
 		FileCount: fileCount,
+		Subject:   subject,
+		Outputs:   outputs,
 	}
 
 	data, err := json.Marshal(record)
@@ -1,32 +1,14 @@
 package taskQueue
 
-import (
-	"os"
-	"path/filepath"
-)
-
 // Backwards-compatible wrapper around the generic DBQueue for the convert queue.
+// The convert queue also holds the persisted job records (see jobs.go).
 
 var ConvertQueue *DBQueue
 
-// getConvertQueueDataFile returns the path to the convert queue database file.
-// This function checks the PIXERVE_DATA_DIR environment variable for configurability,
-// defaulting to "data" subdirectory if not set. This allows server administrators
-// to customize the data directory location for different deployment environments.
-//
-// The path is constructed as: PIXERVE_DATA_DIR/ConvertQueue.db or data/ConvertQueue.db
-//
-// Returns: full path to the ConvertQueue.db file
-func getConvertQueueDataFile() string {
-	dataDir := os.Getenv("PIXERVE_DATA_DIR")
-	if dataDir == "" {
-		dataDir = "data"
-	}
-	return filepath.Join(dataDir, "ConvertQueue.db")
-}
-
-func OpenConvertQueueDB() error {
-	q, err := OpenQueue(getConvertQueueDataFile())
+// OpenConvertQueueDB opens the convert queue database at dataFile.
+// The path is normally config.GetQueueDBPath().
+func OpenConvertQueueDB(dataFile string) error {
+	q, err := OpenQueue(dataFile)
 	if err != nil {
 		return err
 	}
@@ -34,6 +16,16 @@ func OpenConvertQueueDB() error {
 	return nil
 }
 
+// CloseConvertQueueDB closes the convert queue database
+func CloseConvertQueueDB() error {
+	if ConvertQueue == nil {
+		return nil
+	}
+	err := ConvertQueue.Close()
+	ConvertQueue = nil
+	return err
+}
+
 func AddToConvertQueue(key string, value []byte) error {
 	return ConvertQueue.Add(key, value)
 }
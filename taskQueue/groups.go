@@ -0,0 +1,264 @@
+package taskQueue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// GroupRecord is the persisted state of a job group created by a batch upload.
+// Members are added while the batch is being received; once Sealed, the group is complete
+// when every member job reaches a terminal status.
+type GroupRecord struct {
+	ID              string            `json:"id"`
+	Subject         string            `json:"subject"`
+	Members         []GroupMember     `json:"members"`
+	CallbackURL     string            `json:"callback_url,omitempty"`
+	CallbackHeaders map[string]string `json:"callback_headers,omitempty"`
+	Sealed          bool              `json:"sealed"`                 // no more members will be added
+	CompletedAt     time.Time         `json:"completed_at,omitempty"` // set once when the last member finished
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+// GroupMember is one job of a group along with the file it was created from
+type GroupMember struct {
+	Hash     string `json:"hash"`
+	Filename string `json:"filename"`
+}
+
+var (
+	// ErrGroupNotFound is returned when no record exists for a group ID
+	ErrGroupNotFound = errors.New("group not found")
+	// ErrGroupSealed is returned when adding a member to a sealed group
+	ErrGroupSealed = errors.New("group is sealed")
+)
+
+// Key layout:
+//
+//	group:<id> -> JSON GroupRecord
+const groupPrefix = "group:"
+
+func groupKey(id string) []byte {
+	return []byte(groupPrefix + id)
+}
+
+// getGroup loads a group record; callers must hold q.mu
+func (q *DBQueue) getGroup(id string) (GroupRecord, error) {
+	value, closer, err := q.DB.Get(groupKey(id))
+	if err != nil {
+		if errors.Is(err, pebble.ErrNotFound) {
+			return GroupRecord{}, ErrGroupNotFound
+		}
+		return GroupRecord{}, err
+	}
+	defer closer.Close()
+
+	var rec GroupRecord
+	if err := json.Unmarshal(value, &rec); err != nil {
+		return GroupRecord{}, fmt.Errorf("failed to unmarshal group record %s: %w", id, err)
+	}
+	return rec, nil
+}
+
+// setGroup writes a group record into batch, stamping UpdatedAt
+func setGroup(batch *pebble.Batch, rec *GroupRecord) error {
+	rec.UpdatedAt = time.Now()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal group record %s: %w", rec.ID, err)
+	}
+	return batch.Set(groupKey(rec.ID), data, nil)
+}
+
+// CreateGroup persists a new, unsealed group without members
+func (q *DBQueue) CreateGroup(rec GroupRecord) (GroupRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec.Members = nil
+	rec.Sealed = false
+	rec.CompletedAt = time.Time{}
+	rec.CreatedAt = time.Now()
+	err := q.commit(func(batch *pebble.Batch) error { return setGroup(batch, &rec) })
+	return rec, err
+}
+
+// AddGroupMember records a job as a member of an unsealed group.
+// Members are added before their job is enqueued, so the group cannot complete without them.
+func (q *DBQueue) AddGroupMember(id string, member GroupMember) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getGroup(id)
+	if err != nil {
+		return err
+	}
+	if rec.Sealed {
+		return ErrGroupSealed
+	}
+	rec.Members = append(rec.Members, member)
+	return q.commit(func(batch *pebble.Batch) error { return setGroup(batch, &rec) })
+}
+
+// RemoveGroupMember drops a member whose job could not be enqueued
+func (q *DBQueue) RemoveGroupMember(id, hash string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getGroup(id)
+	if err != nil {
+		return err
+	}
+	members := rec.Members[:0]
+	for _, member := range rec.Members {
+		if member.Hash != hash {
+			members = append(members, member)
+		}
+	}
+	rec.Members = members
+	return q.commit(func(batch *pebble.Batch) error { return setGroup(batch, &rec) })
+}
+
+// SealGroup marks a group as having all its members
+func (q *DBQueue) SealGroup(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getGroup(id)
+	if err != nil {
+		return err
+	}
+	rec.Sealed = true
+	return q.commit(func(batch *pebble.Batch) error { return setGroup(batch, &rec) })
+}
+
+// GetGroup returns a group record along with the current record of each member job.
+// Members whose job record has been purged are omitted from the returned jobs.
+func (q *DBQueue) GetGroup(id string) (GroupRecord, []JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getGroup(id)
+	if err != nil {
+		return GroupRecord{}, nil, err
+	}
+	jobs, err := q.groupJobs(rec)
+	return rec, jobs, err
+}
+
+// groupJobs loads the job records of a group's members; callers must hold q.mu
+func (q *DBQueue) groupJobs(rec GroupRecord) ([]JobRecord, error) {
+	jobs := make([]JobRecord, 0, len(rec.Members))
+	for _, member := range rec.Members {
+		job, err := q.getJob(member.Hash)
+		if errors.Is(err, ErrJobNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// CompleteGroup marks a sealed group as completed if every member job has reached a
+// terminal status. It returns true only for the call that completed the group, so the
+// group-level callback is sent exactly once.
+func (q *DBQueue) CompleteGroup(id string) (GroupRecord, []JobRecord, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getGroup(id)
+	if err != nil {
+		return GroupRecord{}, nil, false, err
+	}
+	if !rec.Sealed || !rec.CompletedAt.IsZero() {
+		return rec, nil, false, nil
+	}
+	jobs, err := q.groupJobs(rec)
+	if err != nil {
+		return rec, nil, false, err
+	}
+	for _, job := range jobs {
+		if !job.Status.Terminal() {
+			return rec, jobs, false, nil
+		}
+	}
+
+	rec.CompletedAt = time.Now()
+	if err := q.commit(func(batch *pebble.Batch) error { return setGroup(batch, &rec) }); err != nil {
+		return rec, jobs, false, err
+	}
+	return rec, jobs, true, nil
+}
+
+// ListOpenGroups returns sealed groups that have not completed yet
+func (q *DBQueue) ListOpenGroups() ([]GroupRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.listGroups(func(rec GroupRecord) bool { return rec.Sealed && rec.CompletedAt.IsZero() })
+}
+
+// listGroups scans group records matching keep; callers must hold q.mu
+func (q *DBQueue) listGroups(keep func(GroupRecord) bool) ([]GroupRecord, error) {
+	iter, err := q.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(groupPrefix),
+		UpperBound: []byte(groupPrefix + "\xff"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	var records []GroupRecord
+	for iter.First(); iter.Valid(); iter.Next() {
+		var rec GroupRecord
+		if err := json.Unmarshal(iter.Value(), &rec); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal group record %s: %w", iter.Key(), err)
+		}
+		if keep(rec) {
+			records = append(records, rec)
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("iteration error: %w", err)
+	}
+	return records, nil
+}
+
+// PurgeGroups deletes groups that completed more than maxAge ago, and groups that were
+// never sealed (an interrupted batch upload) and were created more than maxAge ago
+func (q *DBQueue) PurgeGroups(maxAge time.Duration) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	records, err := q.listGroups(func(rec GroupRecord) bool {
+		if rec.Sealed {
+			return !rec.CompletedAt.IsZero() && rec.CompletedAt.Before(cutoff)
+		}
+		return rec.CreatedAt.Before(cutoff)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = q.commit(func(batch *pebble.Batch) error {
+		for _, rec := range records {
+			if err := batch.Delete(groupKey(rec.ID), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
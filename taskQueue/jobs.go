@@ -0,0 +1,635 @@
+package taskQueue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"pixerve/models"
+
+	"github.com/cockroachdb/pebble"
+)
+
+// JobStatus is the persisted lifecycle state of a queued job
+type JobStatus string
+
+const (
+	JobPending    JobStatus = "pending"
+	JobProcessing JobStatus = "processing"
+	JobCompleted  JobStatus = "completed"
+	JobFailed     JobStatus = "failed"
+	JobCancelled  JobStatus = "cancelled"
+	// JobDeadLetter holds jobs that exhausted their retries; they can be inspected and requeued
+	JobDeadLetter JobStatus = "dead_letter"
+)
+
+// Job priorities, matching models.JobSpec.Priority
+const (
+	PriorityRealtime = 0
+	PriorityQueued   = 1
+)
+
+// NormalizePriority maps a requested priority onto one of the supported lanes
+func NormalizePriority(priority int) int {
+	if priority <= PriorityRealtime {
+		return PriorityRealtime
+	}
+	return PriorityQueued
+}
+
+// Terminal reports whether the status is final
+func (s JobStatus) Terminal() bool {
+	return s == JobCompleted || s == JobFailed || s == JobCancelled || s == JobDeadLetter
+}
+
+// Attempt records the outcome of one processing attempt of a job.
+// Outcome is the status the job moved to afterwards; JobPending means a retry was scheduled.
+type Attempt struct {
+	Number     int       `json:"number"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Outcome    JobStatus `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	RetryAt    time.Time `json:"retry_at,omitempty"`
+}
+
+// JobRecord is the persisted state of a job in the queue.
+// While a job is processing, LeaseOwner holds it until LeaseUntil; an owner that stops
+// renewing (e.g. because the process crashed) loses the job back to the pending state.
+type JobRecord struct {
+	Hash       string    `json:"hash"`
+	Dir        string    `json:"dir"` // job directory containing instructions.json
+	Status     JobStatus `json:"status"`
+	Priority   int       `json:"priority"` // PriorityRealtime or PriorityQueued
+	Attempts   int       `json:"attempts"` // attempts since the job was last (re)queued
+	LeaseOwner string    `json:"lease_owner,omitempty"`
+	LeaseUntil time.Time `json:"lease_until,omitempty"`
+	StartedAt  time.Time `json:"started_at,omitempty"` // start of the current attempt
+	NotBefore  time.Time `json:"not_before,omitempty"` // earliest time a pending retry may be leased
+	Error      string    `json:"error,omitempty"`
+	History    []Attempt `json:"history,omitempty"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	// CancelRequested is set when cancellation of a processing job was requested
+	CancelRequested bool `json:"cancel_requested,omitempty"`
+	// Outputs lists what the last finished attempt wrote to storage backends
+	Outputs []models.WrittenOutput `json:"outputs,omitempty"`
+	// Group is the ID of the batch upload group the job belongs to, if any
+	Group string `json:"group,omitempty"`
+	// Subject is the JWT subject that uploaded the job, if known
+	Subject string `json:"subject,omitempty"`
+}
+
+var (
+	// ErrJobNotFound is returned when no record exists for a hash
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobActive is returned when enqueuing a job that is currently leased
+	ErrJobActive = errors.New("job is currently processing")
+	// ErrLeaseLost is returned when the caller no longer holds the lease on a job
+	ErrLeaseLost = errors.New("job lease lost")
+	// ErrJobNotPending is returned when an operation requires a pending job
+	ErrJobNotPending = errors.New("job is not pending")
+	// ErrJobNotProcessing is returned when an operation requires a processing job
+	ErrJobNotProcessing = errors.New("job is not processing")
+	// ErrJobNotDeadLettered is returned when requeuing a job that is not in the dead-letter list
+	ErrJobNotDeadLettered = errors.New("job is not in the dead-letter list")
+)
+
+// Key layout:
+//
+//	job:<hash>                                  -> JSON JobRecord
+//	pending:<priority>:<enqueued nanos>:<hash>  -> empty; per-priority FIFO index of pending jobs
+const (
+	jobPrefix     = "job:"
+	pendingPrefix = "pending:"
+)
+
+func jobKey(hash string) []byte {
+	return []byte(jobPrefix + hash)
+}
+
+func pendingKey(rec JobRecord) []byte {
+	return []byte(fmt.Sprintf("%s%020d:%s", pendingLanePrefix(rec.Priority), rec.EnqueuedAt.UnixNano(), rec.Hash))
+}
+
+func pendingLanePrefix(priority int) string {
+	return fmt.Sprintf("%s%d:", pendingPrefix, priority)
+}
+
+// LeasePolicy tells Lease which pending jobs the caller has capacity for
+type LeasePolicy struct {
+	// AllowQueued permits leasing queued jobs; realtime jobs are always eligible
+	AllowQueued bool
+	// AgingAfter promotes queued jobs that have waited at least this long so they compete
+	// with realtime jobs in arrival order, regardless of AllowQueued. Zero disables aging.
+	AgingAfter time.Duration
+}
+
+// Aged reports whether a pending job has waited long enough to be promoted under policy
+func (p LeasePolicy) Aged(rec JobRecord, now time.Time) bool {
+	return rec.Priority != PriorityRealtime && p.AgingAfter > 0 && now.Sub(rec.EnqueuedAt) >= p.AgingAfter
+}
+
+// getJob loads a record; callers must hold q.mu
+func (q *DBQueue) getJob(hash string) (JobRecord, error) {
+	value, closer, err := q.DB.Get(jobKey(hash))
+	if err != nil {
+		if errors.Is(err, pebble.ErrNotFound) {
+			return JobRecord{}, ErrJobNotFound
+		}
+		return JobRecord{}, err
+	}
+	defer closer.Close()
+
+	var rec JobRecord
+	if err := json.Unmarshal(value, &rec); err != nil {
+		return JobRecord{}, fmt.Errorf("failed to unmarshal job record %s: %w", hash, err)
+	}
+	return rec, nil
+}
+
+// setJob writes a record into batch, stamping UpdatedAt
+func setJob(batch *pebble.Batch, rec *JobRecord) error {
+	rec.UpdatedAt = time.Now()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job record %s: %w", rec.Hash, err)
+	}
+	return batch.Set(jobKey(rec.Hash), data, nil)
+}
+
+// commit applies fn to a fresh batch and commits it durably
+func (q *DBQueue) commit(fn func(batch *pebble.Batch) error) error {
+	batch := q.DB.NewBatch()
+	defer batch.Close()
+	if err := fn(batch); err != nil {
+		return err
+	}
+	return batch.Commit(pebble.Sync)
+}
+
+// Enqueue adds a job as pending with the given priority. Re-enqueuing a hash whose previous
+// run finished starts it over; re-enqueuing a hash that is already pending keeps its place in line.
+func (q *DBQueue) Enqueue(hash, dir string, priority int) (JobRecord, error) {
+	return q.EnqueueFor(hash, dir, priority, "", "")
+}
+
+// EnqueueInGroup is Enqueue for a job that belongs to a group (see GroupRecord)
+func (q *DBQueue) EnqueueInGroup(hash, dir string, priority int, group string) (JobRecord, error) {
+	return q.EnqueueFor(hash, dir, priority, group, "")
+}
+
+// EnqueueFor is EnqueueInGroup for a job uploaded by subject; group may be empty
+func (q *DBQueue) EnqueueFor(hash, dir string, priority int, group, subject string) (JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	existing, err := q.getJob(hash)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		return JobRecord{}, err
+	}
+	if err == nil {
+		switch existing.Status {
+		case JobProcessing:
+			return existing, ErrJobActive
+		case JobPending:
+			existing.Dir = dir
+			existing.Group = group
+			existing.Subject = subject
+			err := q.commit(func(batch *pebble.Batch) error { return setJob(batch, &existing) })
+			return existing, err
+		}
+	}
+
+	rec := JobRecord{
+		Hash:       hash,
+		Dir:        dir,
+		Status:     JobPending,
+		Priority:   NormalizePriority(priority),
+		EnqueuedAt: time.Now(),
+		Group:      group,
+		Subject:    subject,
+	}
+	err = q.commit(func(batch *pebble.Batch) error {
+		if err := setJob(batch, &rec); err != nil {
+			return err
+		}
+		return batch.Set(pendingKey(rec), nil, nil)
+	})
+	return rec, err
+}
+
+// Lease claims the next pending job for owner until ttl elapses.
+// The oldest realtime job is served first, unless the oldest queued job has aged past
+// policy.AgingAfter and arrived earlier. Otherwise a queued job is leased only if
+// policy.AllowQueued is set. Returns nil without error when no eligible job is pending.
+func (q *DBQueue) Lease(owner string, ttl time.Duration, policy LeasePolicy) (*JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	realtimeKey, realtime, err := q.headPending(PriorityRealtime)
+	if err != nil {
+		return nil, err
+	}
+	queuedKey, queued, err := q.headPending(PriorityQueued)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case queued != nil && policy.Aged(*queued, now) && (realtime == nil || queued.EnqueuedAt.Before(realtime.EnqueuedAt)):
+		return q.lease(queuedKey, *queued, owner, ttl)
+	case realtime != nil:
+		return q.lease(realtimeKey, *realtime, owner, ttl)
+	case queued != nil && policy.AllowQueued:
+		return q.lease(queuedKey, *queued, owner, ttl)
+	default:
+		return nil, nil
+	}
+}
+
+// headPending returns the oldest pending job in a priority lane that is due to run,
+// dropping stale index entries. Callers must hold q.mu.
+func (q *DBQueue) headPending(priority int) ([]byte, *JobRecord, error) {
+	prefix := pendingLanePrefix(priority)
+	iter, err := q.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(prefix),
+		UpperBound: []byte(prefix + "\xff"),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	now := time.Now()
+	for iter.First(); iter.Valid(); iter.Next() {
+		indexKey := append([]byte{}, iter.Key()...)
+		hash := string(indexKey[strings.LastIndexByte(string(indexKey), ':')+1:])
+
+		rec, err := q.getJob(hash)
+		if err != nil && !errors.Is(err, ErrJobNotFound) {
+			return nil, nil, err
+		}
+		if err != nil || rec.Status != JobPending {
+			// Stale index entry; drop it and keep looking
+			if err := q.DB.Delete(indexKey, pebble.Sync); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if rec.NotBefore.After(now) {
+			// Retry backoff has not elapsed yet
+			continue
+		}
+		return indexKey, &rec, nil
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, nil, fmt.Errorf("iteration error: %w", err)
+	}
+	return nil, nil, nil
+}
+
+// lease moves a pending job to processing under owner's lease. Callers must hold q.mu.
+func (q *DBQueue) lease(indexKey []byte, rec JobRecord, owner string, ttl time.Duration) (*JobRecord, error) {
+	now := time.Now()
+	rec.Status = JobProcessing
+	rec.Attempts++
+	rec.LeaseOwner = owner
+	rec.LeaseUntil = now.Add(ttl)
+	rec.StartedAt = now
+	rec.NotBefore = time.Time{}
+	err := q.commit(func(batch *pebble.Batch) error {
+		if err := batch.Delete(indexKey, nil); err != nil {
+			return err
+		}
+		return setJob(batch, &rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// ExtendLease renews owner's lease on a processing job
+func (q *DBQueue) ExtendLease(hash, owner string, ttl time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return err
+	}
+	if rec.Status != JobProcessing || rec.LeaseOwner != owner {
+		return ErrLeaseLost
+	}
+	rec.LeaseUntil = time.Now().Add(ttl)
+	return q.commit(func(batch *pebble.Batch) error { return setJob(batch, &rec) })
+}
+
+// Ack records the outcome of a leased job, appends it to the attempt history and releases the lease.
+// status must be terminal; errMsg is stored for failed jobs.
+func (q *DBQueue) Ack(hash, owner string, status JobStatus, errMsg string) error {
+	return q.AckWithOutputs(hash, owner, status, errMsg, nil)
+}
+
+// AckWithOutputs is Ack that also records the outputs the attempt wrote to storage backends
+func (q *DBQueue) AckWithOutputs(hash, owner string, status JobStatus, errMsg string, outputs []models.WrittenOutput) error {
+	if !status.Terminal() {
+		return fmt.Errorf("cannot ack job with non-terminal status %s", status)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return err
+	}
+	if rec.Status != JobProcessing || rec.LeaseOwner != owner {
+		return ErrLeaseLost
+	}
+	recordAttempt(&rec, status, errMsg, time.Time{})
+	rec.Outputs = outputs
+	return q.commit(func(batch *pebble.Batch) error { return finish(batch, &rec, status, errMsg) })
+}
+
+// RequestCancel flags a processing job for cancellation. The flag is persisted so the
+// job is settled as cancelled instead of resumed if the server restarts before the
+// worker stops. Returns the job's record, with ErrJobNotProcessing if it was in any other state.
+func (q *DBQueue) RequestCancel(hash string) (JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return JobRecord{}, err
+	}
+	if rec.Status != JobProcessing {
+		return rec, ErrJobNotProcessing
+	}
+	rec.CancelRequested = true
+	err = q.commit(func(batch *pebble.Batch) error { return setJob(batch, &rec) })
+	return rec, err
+}
+
+// Retry records a failed attempt of a leased job and returns it to the pending state,
+// to be leased again no earlier than notBefore. The job keeps its place in its priority lane.
+func (q *DBQueue) Retry(hash, owner, errMsg string, notBefore time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return err
+	}
+	if rec.Status != JobProcessing || rec.LeaseOwner != owner {
+		return ErrLeaseLost
+	}
+	recordAttempt(&rec, JobPending, errMsg, notBefore)
+	rec.Error = errMsg
+	rec.NotBefore = notBefore
+	return q.commit(func(batch *pebble.Batch) error { return requeue(batch, &rec) })
+}
+
+// RequeueDeadLetter moves a dead-lettered job back to the pending state with a fresh retry budget.
+// The attempt history is kept. Returns ErrJobNotDeadLettered for jobs in any other state.
+func (q *DBQueue) RequeueDeadLetter(hash string) (JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return JobRecord{}, err
+	}
+	if rec.Status != JobDeadLetter {
+		return rec, ErrJobNotDeadLettered
+	}
+	rec.Attempts = 0
+	rec.Error = ""
+	rec.NotBefore = time.Time{}
+	rec.EnqueuedAt = time.Now()
+	err = q.commit(func(batch *pebble.Batch) error { return requeue(batch, &rec) })
+	return rec, err
+}
+
+// recordAttempt appends the outcome of the current attempt to rec's history
+func recordAttempt(rec *JobRecord, outcome JobStatus, errMsg string, retryAt time.Time) {
+	rec.History = append(rec.History, Attempt{
+		Number:     len(rec.History) + 1,
+		StartedAt:  rec.StartedAt,
+		FinishedAt: time.Now(),
+		Outcome:    outcome,
+		Error:      errMsg,
+		RetryAt:    retryAt,
+	})
+}
+
+// requeue moves rec into the pending state within batch, releasing any lease
+func requeue(batch *pebble.Batch, rec *JobRecord) error {
+	rec.Status = JobPending
+	rec.LeaseOwner = ""
+	rec.LeaseUntil = time.Time{}
+	rec.StartedAt = time.Time{}
+	rec.CancelRequested = false
+	if err := setJob(batch, rec); err != nil {
+		return err
+	}
+	return batch.Set(pendingKey(*rec), nil, nil)
+}
+
+// Finish records a terminal status for a job that is not leased by the caller,
+// such as one found unrecoverable at startup
+func (q *DBQueue) Finish(hash string, status JobStatus, errMsg string) error {
+	if !status.Terminal() {
+		return fmt.Errorf("cannot finish job with non-terminal status %s", status)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return err
+	}
+	return q.commit(func(batch *pebble.Batch) error {
+		if rec.Status == JobPending {
+			if err := batch.Delete(pendingKey(rec), nil); err != nil {
+				return err
+			}
+		}
+		return finish(batch, &rec, status, errMsg)
+	})
+}
+
+// finish moves rec into a terminal status within batch
+func finish(batch *pebble.Batch, rec *JobRecord, status JobStatus, errMsg string) error {
+	rec.Status = status
+	rec.Error = errMsg
+	rec.LeaseOwner = ""
+	rec.LeaseUntil = time.Time{}
+	rec.StartedAt = time.Time{}
+	return setJob(batch, rec)
+}
+
+// RecoverLeases returns processing jobs to the pending state when their lease has expired
+// or is held by an owner other than owner (a previous run of the server).
+// Recovered jobs keep their original position in the queue, and the interrupted attempt is
+// recorded in their history. Jobs whose cancellation was requested are settled as cancelled
+// instead and not counted.
+func (q *DBQueue) RecoverLeases(owner string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	records, err := q.listJobs(JobProcessing)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	recovered := 0
+	err = q.commit(func(batch *pebble.Batch) error {
+		for i := range records {
+			rec := &records[i]
+			if rec.LeaseOwner == owner && rec.LeaseUntil.After(now) {
+				continue
+			}
+			if rec.CancelRequested {
+				recordAttempt(rec, JobCancelled, "cancelled before the server restarted", time.Time{})
+				if err := finish(batch, rec, JobCancelled, ""); err != nil {
+					return err
+				}
+				continue
+			}
+			recordAttempt(rec, JobPending, "interrupted: lease expired or server restarted", time.Time{})
+			if err := requeue(batch, rec); err != nil {
+				return err
+			}
+			recovered++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return recovered, nil
+}
+
+// Remove deletes a pending job from the queue. Jobs in any other state are left untouched.
+func (q *DBQueue) Remove(hash string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return err
+	}
+	if rec.Status != JobPending {
+		return ErrJobNotPending
+	}
+	return q.commit(func(batch *pebble.Batch) error {
+		if err := batch.Delete(pendingKey(rec), nil); err != nil {
+			return err
+		}
+		return batch.Delete(jobKey(hash), nil)
+	})
+}
+
+// CancelPending marks a pending job as cancelled so it is never leased.
+// Returns the job's record, with ErrJobNotPending if it was in any other state.
+func (q *DBQueue) CancelPending(hash string) (JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	rec, err := q.getJob(hash)
+	if err != nil {
+		return JobRecord{}, err
+	}
+	if rec.Status != JobPending {
+		return rec, ErrJobNotPending
+	}
+	err = q.commit(func(batch *pebble.Batch) error {
+		if err := batch.Delete(pendingKey(rec), nil); err != nil {
+			return err
+		}
+		return finish(batch, &rec, JobCancelled, "")
+	})
+	return rec, err
+}
+
+// GetJob returns the record for a job
+func (q *DBQueue) GetJob(hash string) (JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.getJob(hash)
+}
+
+// ListJobs returns all jobs with the given status, or every job if status is empty
+func (q *DBQueue) ListJobs(status JobStatus) ([]JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.listJobs(status)
+}
+
+// listJobs scans job records; callers must hold q.mu
+func (q *DBQueue) listJobs(status JobStatus) ([]JobRecord, error) {
+	iter, err := q.DB.NewIter(&pebble.IterOptions{
+		LowerBound: []byte(jobPrefix),
+		UpperBound: []byte(jobPrefix + "\xff"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create iterator: %w", err)
+	}
+	defer iter.Close()
+
+	var records []JobRecord
+	for iter.First(); iter.Valid(); iter.Next() {
+		var rec JobRecord
+		if err := json.Unmarshal(iter.Value(), &rec); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal job record %s: %w", iter.Key(), err)
+		}
+		if status == "" || rec.Status == status {
+			records = append(records, rec)
+		}
+	}
+
+	if err := iter.Error(); err != nil {
+		return nil, fmt.Errorf("iteration error: %w", err)
+	}
+	return records, nil
+}
+
+// PurgeJobs deletes finished job records last updated more than maxAge ago
+// and returns the deleted records
+func (q *DBQueue) PurgeJobs(maxAge time.Duration) ([]JobRecord, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	records, err := q.listJobs("")
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	var purged []JobRecord
+	err = q.commit(func(batch *pebble.Batch) error {
+		for _, rec := range records {
+			if !rec.Status.Terminal() || rec.UpdatedAt.After(cutoff) {
+				continue
+			}
+			if err := batch.Delete(jobKey(rec.Hash), nil); err != nil {
+				return err
+			}
+			purged = append(purged, rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
@@ -2,6 +2,7 @@ package taskQueue
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/cockroachdb/pebble"
 )
@@ -10,6 +11,8 @@ import (
 type DBQueue struct {
 	DB       *pebble.DB
 	DataFile string
+
+	mu sync.Mutex // serializes job state transitions
 }
 
 // OpenQueue opens (or creates) a pebble DB at the given dataFile path and
@@ -0,0 +1,213 @@
+package tests
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/job"
+	"pixerve/routes"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// newBatchRequest builds a batch upload request with one "file" part per entry of files
+func newBatchRequest(t *testing.T, token string, files map[string][]byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, content := range files {
+		part, err := writer.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		part.Write(content)
+	}
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload/batch", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestBatchUploadWithArchives(t *testing.T) {
+	openTestQueue(t, "test_batch_queue.db")
+
+	secret := []byte("batch-test-secret-key-at-least-32-bytes!")
+	t.Setenv("PIXERVE_JWT_SECRET", string(secret))
+	t.Setenv("PIXERVE_SUBJECT_UPLOAD_LIMITS", "batch-tenant=1KB")
+
+	token := signTestJWT(t, secret, jose.HS256, "", map[string]any{
+		"sub": "batch-tenant",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"job": map[string]any{"directHost": true},
+	})
+
+	var zipped bytes.Buffer
+	zw := zip.NewWriter(&zipped)
+	for name, content := range map[string]string{
+		"photos/a.jpg":            "zip-a",
+		"photos/b.jpg":            "zip-b",
+		"photos/.DS_Store":        "hidden",
+		"__MACOSX/photos/._a.jpg": "fork",
+	} {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+
+	var tarred bytes.Buffer
+	gz := gzip.NewWriter(&tarred)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "c.png", Mode: 0644, Size: 5, Typeflag: tar.TypeReg})
+	tw.Write([]byte("tar-c"))
+	tw.Close()
+	gz.Close()
+
+	rec := httptest.NewRecorder()
+	routes.BatchUploadHandler(rec, newBatchRequest(t, token, map[string][]byte{
+		"one.jpg":    []byte("plain-one"),
+		"images.zip": zipped.Bytes(),
+		"more.tgz":   tarred.Bytes(),
+		"huge.jpg":   bytes.Repeat([]byte("h"), 2048),
+	}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response routes.BatchUploadResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Group == "" {
+		t.Fatal("Expected a group ID")
+	}
+
+	queued := map[string]routes.BatchFileResult{}
+	for _, file := range response.Files {
+		if file.Error != "" {
+			if file.Filename != "huge.jpg" {
+				t.Errorf("Unexpected rejection of %s: %s", file.Filename, file.Error)
+			}
+			continue
+		}
+		queued[file.Filename] = file
+		t.Cleanup(func() { os.RemoveAll(filepath.Join(os.TempDir(), file.Hash)) })
+	}
+	for _, name := range []string{"one.jpg", "a.jpg", "b.jpg", "c.png"} {
+		if file, ok := queued[name]; !ok || file.Hash == "" {
+			t.Errorf("Expected %s to be queued, got %+v", name, file)
+		}
+	}
+	if len(queued) != 4 {
+		t.Errorf("Expected 4 queued files, got %d: %+v", len(queued), response.Files)
+	}
+
+	status, err := job.GetGroupStatus(response.Group)
+	if err != nil {
+		t.Fatalf("Failed to get group status: %v", err)
+	}
+	if status.State != job.GroupStatePending || status.Total != 4 || status.Counts["pending"] != 4 {
+		t.Errorf("Expected 4 pending members, got %+v", status)
+	}
+
+	// Group status is only visible to the subject that uploaded the batch
+	groupStatus := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/groups/status?id="+response.Group, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		routes.GroupStatusHandler(rec, req)
+		return rec
+	}
+	if rec := groupStatus(token); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the uploading subject, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := groupStatus(""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", rec.Code)
+	}
+	otherToken := signTestJWT(t, secret, jose.HS256, "", map[string]any{
+		"sub": "other-tenant",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	if rec := groupStatus(otherToken); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another subject, got %d", rec.Code)
+	}
+}
+
+func TestGroupCompletionCallback(t *testing.T) {
+	openTestQueue(t, "test_group_queue.db")
+
+	callbacks := make(chan map[string]any, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		json.NewDecoder(r.Body).Decode(&payload)
+		callbacks <- payload
+	}))
+	defer server.Close()
+
+	groupID, err := job.CreateGroup("group-tenant", server.URL, nil)
+	if err != nil {
+		t.Fatalf("Failed to create group: %v", err)
+	}
+	for _, hash := range []string{"grouphash1", "grouphash2"} {
+		if err := job.AddPendingGroupJob(filepath.Join(t.TempDir(), hash), 1, groupID, hash+".jpg", "group-tenant"); err != nil {
+			t.Fatalf("Failed to add group job: %v", err)
+		}
+	}
+	if err := job.SealGroup(groupID); err != nil {
+		t.Fatalf("Failed to seal group: %v", err)
+	}
+
+	// Finishing one member does not complete the group
+	if _, err := job.CancelJob("grouphash1", false); err != nil {
+		t.Fatalf("Failed to cancel job: %v", err)
+	}
+	select {
+	case payload := <-callbacks:
+		t.Fatalf("Unexpected callback before every member finished: %v", payload)
+	default:
+	}
+
+	status, _ := job.GetGroupStatus(groupID)
+	if status.State != job.GroupStateProcessing || status.Counts["cancelled"] != 1 {
+		t.Errorf("Expected group still processing with one cancelled member, got %+v", status)
+	}
+
+	// The last member finishing sends exactly one group callback
+	if _, err := job.CancelJob("grouphash2", false); err != nil {
+		t.Fatalf("Failed to cancel job: %v", err)
+	}
+	select {
+	case payload := <-callbacks:
+		if payload["group"] != groupID || payload["status"] != job.GroupStateFailed || payload["total"] != float64(2) {
+			t.Errorf("Unexpected callback payload: %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a group callback")
+	}
+
+	job.SealGroup(groupID)
+	select {
+	case payload := <-callbacks:
+		t.Errorf("Group callback sent twice: %v", payload)
+	default:
+	}
+
+	status, _ = job.GetGroupStatus(groupID)
+	if status.State != job.GroupStateFailed || status.CompletedAt == nil {
+		t.Errorf("Expected completed failed group, got %+v", status)
+	}
+}
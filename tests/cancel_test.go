@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/job"
+	"pixerve/models"
+	"pixerve/routes"
+	"pixerve/taskQueue"
+	writerbackends "pixerve/writerBackends"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestCancelProcessingJob(t *testing.T) {
+	q := openTestQueue(t, "test_cancel_queue.db")
+
+	if _, err := q.Enqueue("inflight", "/tmp/inflight", taskQueue.PriorityRealtime); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	if rec, err := q.Lease("previous-run", time.Minute, taskQueue.LeasePolicy{}); err != nil || rec == nil {
+		t.Fatalf("Failed to lease: %v", err)
+	}
+
+	if !job.IsJobCancellable("inflight") {
+		t.Error("Expected processing job to be cancellable")
+	}
+
+	state, err := job.CancelJob("inflight", true)
+	if err != nil {
+		t.Fatalf("Failed to cancel processing job: %v", err)
+	}
+	if state != job.JobStateProcessing {
+		t.Errorf("Expected processing state, got %v", state)
+	}
+
+	state, rec, exists := job.GetJobDetails("inflight")
+	if !exists || state != job.JobStateProcessing || !rec.CancelRequested {
+		t.Errorf("Expected processing job with cancel requested, got %v %+v", state, rec)
+	}
+
+	// A cancelled job interrupted by a restart is settled, not resumed
+	recovered, err := q.RecoverLeases("current-run")
+	if err != nil {
+		t.Fatalf("Failed to recover leases: %v", err)
+	}
+	if recovered != 0 {
+		t.Errorf("Expected no jobs to be resumed, got %d", recovered)
+	}
+	if state, _ := job.GetJobState("inflight"); state != job.JobStateCancelled {
+		t.Errorf("Expected cancelled state, got %v", state)
+	}
+
+	if _, err := job.CancelJob("inflight", false); err == nil || !strings.Contains(err.Error(), "already cancelled") {
+		t.Errorf("Expected already cancelled error, got %v", err)
+	}
+}
+
+func TestCancelAndStatusRequireOwner(t *testing.T) {
+	q := openTestQueue(t, "test_cancel_owner_queue.db")
+
+	secret := []byte("cancel-test-secret-key-at-least-32-bytes")
+	t.Setenv("PIXERVE_JWT_SECRET", string(secret))
+	tokenFor := func(subject string) string {
+		return signTestJWT(t, secret, jose.HS256, "", map[string]any{
+			"sub": subject,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"job": map[string]any{"directHost": true},
+		})
+	}
+	call := func(handler http.HandlerFunc, method, target, token string) int {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	jobDir := filepath.Join(t.TempDir(), "ownedhash")
+	os.MkdirAll(jobDir, 0755)
+	if _, err := q.EnqueueFor("ownedhash", jobDir, taskQueue.PriorityRealtime, "", "alice"); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+
+	if code := call(routes.JobStatusHandler, http.MethodGet, "/status?hash=ownedhash", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for status without a token, got %d", code)
+	}
+	if code := call(routes.JobStatusHandler, http.MethodGet, "/status?hash=ownedhash", tokenFor("bob")); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for status of another subject's job, got %d", code)
+	}
+	if code := call(routes.JobStatusHandler, http.MethodGet, "/status?hash=ownedhash", tokenFor("alice")); code != http.StatusOK {
+		t.Errorf("Expected 200 for status of own job, got %d", code)
+	}
+
+	if code := call(routes.CancelJobHandler, http.MethodDelete, "/cancel?hash=ownedhash&deleteOutputs=true", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for cancel without a token, got %d", code)
+	}
+	if code := call(routes.CancelJobHandler, http.MethodDelete, "/cancel?hash=ownedhash&deleteOutputs=true", tokenFor("bob")); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for cancelling another subject's job, got %d", code)
+	}
+	if state, _ := job.GetJobState("ownedhash"); state != job.JobStatePending {
+		t.Errorf("Expected job to stay pending, got %v", state)
+	}
+	if _, err := os.Stat(jobDir); err != nil {
+		t.Errorf("Expected job directory to be kept, got %v", err)
+	}
+
+	if code := call(routes.CancelJobHandler, http.MethodDelete, "/cancel?hash=ownedhash", tokenFor("alice")); code != http.StatusNoContent {
+		t.Errorf("Expected 204 for cancelling own job, got %d", code)
+	}
+	if state, _ := job.GetJobState("ownedhash"); state != job.JobStateCancelled {
+		t.Errorf("Expected cancelled state, got %v", state)
+	}
+}
+
+func TestLostLeaseKeepsJobDirectory(t *testing.T) {
+	t.Setenv("PIXERVE_SERVE_DIR", t.TempDir())
+
+	combined, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{DirectHost: true, KeepOriginal: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse job: %v", err)
+	}
+	jobDir := t.TempDir()
+	os.WriteFile(filepath.Join(jobDir, "photo.jpg"), []byte("original"), 0644)
+	instr := job.JobInstructions{FilePath: jobDir, OriginalFile: "photo.jpg", Hash: "leasehash_user", Job: combined}
+	if err := job.WriteInstructions(jobDir, instr); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+
+	// The run stops, but the job is not cancelled: another instance will process it
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(taskQueue.ErrLeaseLost)
+	if err := job.ProcessJob(ctx, jobDir); err == nil {
+		t.Fatal("Expected the interrupted job to fail")
+	}
+	if _, err := os.Stat(filepath.Join(jobDir, "instructions.json")); err != nil {
+		t.Errorf("Expected the job directory to be kept after losing the lease, got %v", err)
+	}
+}
+
+func TestWriteImageCancelAndDelete(t *testing.T) {
+	accessInfo := map[string]string{
+		"baseDir":  t.TempDir(),
+		"folder":   "cancel",
+		"filename": "image.jpg",
+	}
+	fullPath := filepath.Join(accessInfo["baseDir"], "cancel", "image.jpg")
+
+	// Writes with a cancelled context are aborted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := writerbackends.WriteImage(ctx, accessInfo, strings.NewReader("data"), "directServe"); err == nil {
+		t.Error("Expected cancelled write to fail")
+	}
+
+	if _, err := writerbackends.WriteImage(context.Background(), accessInfo, strings.NewReader("data"), "directServe"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := writerbackends.DeleteImage(context.Background(), accessInfo, "directServe"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := os.Stat(fullPath); !os.IsNotExist(err) {
+		t.Error("Expected written file to be deleted")
+	}
+
+	// Deleting an output that no longer exists is not an error
+	if err := writerbackends.DeleteImage(context.Background(), accessInfo, "directServe"); err != nil {
+		t.Errorf("Expected deleting a missing output to succeed, got %v", err)
+	}
+}
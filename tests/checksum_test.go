@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/job"
+	"pixerve/models"
+	"pixerve/success"
+	writerbackends "pixerve/writerBackends"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestWriteImageChecksums(t *testing.T) {
+	data := "checksummed-image-data"
+	sha := sha256.Sum256([]byte(data))
+	md := md5.Sum([]byte(data))
+
+	// directServe renames a complete, verified temporary file into place
+	baseDir := t.TempDir()
+	accessInfo := map[string]string{"baseDir": baseDir, "folder": "sums", "filename": "a.jpg"}
+	sums, err := writerbackends.WriteImage(context.Background(), accessInfo, strings.NewReader(data), "directServe")
+	if err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if sums.Size != int64(len(data)) || sums.SHA256 != hex.EncodeToString(sha[:]) || sums.MD5 != hex.EncodeToString(md[:]) {
+		t.Errorf("Unexpected checksums: %+v", sums)
+	}
+	entries, _ := os.ReadDir(filepath.Join(baseDir, "sums"))
+	if len(entries) != 1 || entries[0].Name() != "a.jpg" {
+		t.Errorf("Expected only the final file, got %v", entries)
+	}
+	if info, err := os.Stat(filepath.Join(baseDir, "sums", "a.jpg")); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected a world-readable file, got %v, %v", info, err)
+	}
+
+	// A failed write leaves neither the target nor a temporary file behind
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed := map[string]string{"baseDir": baseDir, "folder": "sums", "filename": "b.jpg"}
+	if _, err := writerbackends.WriteImage(ctx, failed, strings.NewReader(data), "directServe"); err == nil {
+		t.Error("Expected cancelled write to fail")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(baseDir, "sums")); len(entries) != 1 {
+		t.Errorf("Expected failed write to leave nothing behind, got %v", entries)
+	}
+
+	// S3 objects are checked against their ETag and removed if it does not match
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s3Info := map[string]string{
+		"accessKey":    "minio",
+		"secretKey":    "minio-secret",
+		"region":       "us-east-1",
+		"bucket":       "images",
+		"objectPath":   "sums/a.jpg",
+		"endpoint":     strings.TrimPrefix(server.URL, "http://"),
+		"disableTLS":   "true",
+		"usePathStyle": "true",
+	}
+	if _, err := writerbackends.WriteImage(context.Background(), s3Info, strings.NewReader(data), "s3"); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	fake.corrupt = true
+	_, err = writerbackends.WriteImage(context.Background(), s3Info, strings.NewReader(data), "s3")
+	if !errors.Is(err, writerbackends.ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch for a damaged object, got %v", err)
+	}
+	if _, ok := fake.objects["/images/sums/a.jpg"]; ok {
+		t.Error("Expected the damaged object to be deleted")
+	}
+
+	// SFTP uploads replace the target through a temporary file
+	root := t.TempDir()
+	sftpServer := startFakeSFTP(t, root)
+	host, port, _ := net.SplitHostPort(sftpServer.listener.Addr().String())
+	sftpInfo := map[string]string{
+		"key":                "checksum-sftp-key",
+		"host":               host,
+		"port":               port,
+		"user":               "pixerve",
+		"password":           "secret",
+		"remoteDir":          root,
+		"objectPath":         "sums/a.jpg",
+		"hostKeyFingerprint": ssh.FingerprintSHA256(sftpServer.hostKey.PublicKey()),
+	}
+	defer writerbackends.InvalidateClients("checksum-sftp-key")
+	for _, content := range []string{"first version", data} {
+		sums, err := writerbackends.WriteImage(context.Background(), sftpInfo, strings.NewReader(content), "sftp")
+		if err != nil {
+			t.Fatalf("Failed to upload: %v", err)
+		}
+		if sums.Size != int64(len(content)) {
+			t.Errorf("Expected size %d, got %d", len(content), sums.Size)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "sums", "a.jpg")); string(got) != data {
+		t.Errorf("Expected the second upload to replace the first, got %q", got)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, "sums")); len(entries) != 1 {
+		t.Errorf("Expected only the final remote file, got %v", entries)
+	}
+}
+
+func TestSuccessRecordChecksums(t *testing.T) {
+	defer success.Close()
+	if err := success.Init("test_checksum_success.db"); err != nil {
+		t.Fatalf("Failed to initialize success store: %v", err)
+	}
+	serveDir := t.TempDir()
+	t.Setenv("PIXERVE_SERVE_DIR", serveDir)
+
+	combined, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{DirectHost: true, KeepOriginal: true, SubDir: "sums"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse job: %v", err)
+	}
+
+	jobDir := t.TempDir()
+	original := "original-bytes"
+	os.WriteFile(filepath.Join(jobDir, "photo.jpg"), []byte(original), 0644)
+	instr := job.JobInstructions{FilePath: jobDir, OriginalFile: "photo.jpg", Hash: "sumhash_user", Job: combined}
+	if err := job.WriteInstructions(jobDir, instr); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+	if err := job.ProcessJob(context.Background(), jobDir); err != nil {
+		t.Fatalf("Failed to process job: %v", err)
+	}
+
+	record, err := success.GetSuccess("sumhash_user")
+	if err != nil || record == nil {
+		t.Fatalf("Expected success record, got %v, %v", record, err)
+	}
+	sha := sha256.Sum256([]byte(original))
+	if len(record.Outputs) != 1 {
+		t.Fatalf("Expected one recorded output, got %+v", record.Outputs)
+	}
+	output := record.Outputs[0]
+	if !output.Complete || output.File != "sumhash_user_photo.jpg" || output.SHA256 != hex.EncodeToString(sha[:]) || output.Size != int64(len(original)) {
+		t.Errorf("Unexpected recorded output: %+v", output)
+	}
+}
@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"pixerve/credentials"
+	"pixerve/job"
+	"pixerve/models"
+	"testing"
+)
+
+func TestResolveWriterJobs(t *testing.T) {
+	// Initialize credentials store for testing
+	testDBPath := "test_credentials.db"
+	defer credentials.CloseDB()
+
+	err := credentials.OpenDB(testDBPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize credentials store: %v", err)
+	}
+
+	// Register a complete S3 bundle and an incomplete SFTP bundle
+	err = credentials.StoreCredentials("s3-key-123", map[string]string{
+		"accessKey": "AKIA123",
+		"secretKey": "secret",
+		"region":    "us-east-1",
+		"bucket":    "test-bucket",
+	})
+	if err != nil {
+		t.Fatalf("Failed to store S3 credentials: %v", err)
+	}
+
+	err = credentials.StoreCredentials("sftp-key-456", map[string]string{
+		"host": "sftp.example.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to store SFTP credentials: %v", err)
+	}
+
+	// Test resolving a registered key together with directServe
+	resolved, err := job.ResolveWriterJobs([]models.WriterJob{
+		{Type: "s3", Credentials: map[string]string{"key": "s3-key-123"}},
+		{Type: "directServe", Credentials: map[string]string{}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to resolve writer jobs: %v", err)
+	}
+
+	if len(resolved) != 2 {
+		t.Fatalf("Expected 2 resolved writer jobs, got %d", len(resolved))
+	}
+
+	if resolved[0].Credentials["bucket"] != "test-bucket" {
+		t.Errorf("Expected bucket test-bucket, got %s", resolved[0].Credentials["bucket"])
+	}
+
+	if resolved[0].Credentials["key"] != "s3-key-123" {
+		t.Errorf("Expected storage key to be kept, got %s", resolved[0].Credentials["key"])
+	}
+
+	// Test unknown storage key
+	_, err = job.ResolveWriterJobs([]models.WriterJob{
+		{Type: "gcs", Credentials: map[string]string{"key": "missing-key"}},
+	})
+	if err == nil {
+		t.Fatal("Expected error for unregistered storage key")
+	}
+
+	if !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Test incomplete bundle
+	_, err = job.ResolveWriterJobs([]models.WriterJob{
+		{Type: "sftp", Credentials: map[string]string{"key": "sftp-key-456"}},
+	})
+	if err == nil {
+		t.Fatal("Expected error for incomplete SFTP bundle")
+	}
+}
+
+func TestAuthorizeStorageKeys(t *testing.T) {
+	// Initialize credentials store for testing
+	testDBPath := "test_credentials_owner.db"
+	defer credentials.CloseDB()
+
+	err := credentials.OpenDB(testDBPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize credentials store: %v", err)
+	}
+
+	err = credentials.RegisterCredentials("owned-key", credentials.Metadata{
+		Owner: "user-123",
+		Type:  "s3",
+	}, map[string]string{
+		"accessKey": "AKIA123",
+		"secretKey": "secret",
+		"region":    "us-east-1",
+		"bucket":    "test-bucket",
+	})
+	if err != nil {
+		t.Fatalf("Failed to register credentials: %v", err)
+	}
+
+	// Test owner referencing its own key
+	if err := job.AuthorizeStorageKeys("user-123", map[string]string{"s3": "owned-key"}); err != nil {
+		t.Errorf("Expected owner to be authorized, got %v", err)
+	}
+
+	// Test another subject referencing the key
+	err = job.AuthorizeStorageKeys("user-456", map[string]string{"s3": "owned-key"})
+	if !errors.Is(err, job.ErrStorageKeyNotOwned) {
+		t.Errorf("Expected ErrStorageKeyNotOwned, got %v", err)
+	}
+
+	// Test key used with the wrong backend type
+	if err := job.AuthorizeStorageKeys("user-123", map[string]string{"gcs": "owned-key"}); err == nil {
+		t.Error("Expected error for backend type mismatch")
+	}
+
+	// Test metadata never exposes secret values
+	list, err := credentials.ListMetadata("user-123")
+	if err != nil {
+		t.Fatalf("Failed to list metadata: %v", err)
+	}
+
+	if len(list) != 1 {
+		t.Fatalf("Expected 1 metadata record, got %d", len(list))
+	}
+
+	if list[0].AccessKey != "owned-key" || len(list[0].Fields) != 4 {
+		t.Errorf("Unexpected metadata record: %+v", list[0])
+	}
+
+	// Test deregistration removes metadata too
+	if err := credentials.DeleteCredentials("owned-key"); err != nil {
+		t.Fatalf("Failed to delete credentials: %v", err)
+	}
+
+	if _, err := credentials.GetMetadata("owned-key"); !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after deletion, got %v", err)
+	}
+}
+
+func TestCredentialsEncryption(t *testing.T) {
+	// Initialize credentials store for testing
+	testDBPath := "test_credentials_encrypted.db"
+	defer func() {
+		credentials.SetMasterKey(nil)
+		credentials.CloseDB()
+	}()
+
+	err := credentials.OpenDB(testDBPath)
+	if err != nil {
+		t.Fatalf("Failed to initialize credentials store: %v", err)
+	}
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+
+	if err := credentials.SetMasterKey(oldKey); err != nil {
+		t.Fatalf("Failed to set master key: %v", err)
+	}
+
+	// Test encrypted round trip
+	err = credentials.StoreCredentials("encrypted-key", map[string]string{"secretKey": "top-secret"})
+	if err != nil {
+		t.Fatalf("Failed to store credentials: %v", err)
+	}
+
+	creds, err := credentials.GetCredentials("encrypted-key")
+	if err != nil {
+		t.Fatalf("Failed to get credentials: %v", err)
+	}
+
+	if creds["secretKey"] != "top-secret" {
+		t.Errorf("Expected decrypted secretKey, got %s", creds["secretKey"])
+	}
+
+	// Test startup check refuses a missing or wrong key
+	credentials.SetMasterKey(nil)
+	if err := credentials.VerifyMasterKey(); err == nil {
+		t.Error("Expected error when encrypted records exist without a master key")
+	}
+
+	if _, err := credentials.GetCredentials("encrypted-key"); err == nil {
+		t.Error("Expected error reading encrypted credentials without a master key")
+	}
+
+	credentials.SetMasterKey(newKey)
+	if err := credentials.VerifyMasterKey(); err == nil {
+		t.Error("Expected error when records were encrypted with a different master key")
+	}
+
+	// Test rotation to the new key
+	credentials.SetMasterKey(oldKey)
+	count, err := credentials.Reencrypt(newKey)
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt credentials: %v", err)
+	}
+
+	if count != 1 {
+		t.Errorf("Expected 1 re-encrypted record, got %d", count)
+	}
+
+	if err := credentials.VerifyMasterKey(); err != nil {
+		t.Errorf("Expected new key to verify after rotation, got %v", err)
+	}
+
+	creds, err = credentials.GetCredentials("encrypted-key")
+	if err != nil {
+		t.Fatalf("Failed to get credentials after rotation: %v", err)
+	}
+
+	if creds["secretKey"] != "top-secret" {
+		t.Errorf("Expected secretKey to survive rotation, got %s", creds["secretKey"])
+	}
+}
@@ -1,7 +1,12 @@
 package tests
 
 import (
+	"context"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
 	"path/filepath"
 	"pixerve/encoder"
 	"pixerve/models"
@@ -108,3 +113,96 @@ func getExpectedExtension(encoderName string) string {
 		return encoderName
 	}
 }
+
+func TestFitDimensions(t *testing.T) {
+	tests := []struct {
+		srcW, srcH, w, h int
+		want             image.Point
+	}{
+		{400, 200, 100, 100, image.Pt(100, 50)},
+		{200, 400, 100, 100, image.Pt(50, 100)},
+		{400, 200, 200, 0, image.Pt(200, 100)},
+		{400, 200, 0, 50, image.Pt(100, 50)},
+		{400, 200, 0, 0, image.Pt(400, 200)},
+		{400, 200, 800, 800, image.Pt(800, 400)},
+	}
+
+	for _, tt := range tests {
+		got := encoder.FitDimensions(tt.srcW, tt.srcH, tt.w, tt.h)
+		if got != tt.want {
+			t.Errorf("FitDimensions(%d, %d, %d, %d) = %v, want %v", tt.srcW, tt.srcH, tt.w, tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestNativePipeline(t *testing.T) {
+	encoder.RegisterDefaults()
+
+	// Create a 400x200 source with a transparent half
+	tempDir := t.TempDir()
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			alpha := uint8(255)
+			if x >= 200 {
+				alpha = 0
+			}
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: alpha})
+		}
+	}
+
+	inputPath := filepath.Join(tempDir, "source.png")
+	f, err := os.Create(inputPath)
+	if err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	if err := png.Encode(f, src); err != nil {
+		t.Fatalf("Failed to encode source: %v", err)
+	}
+	f.Close()
+
+	pipeline := encoder.NewPipeline(inputPath)
+	ctx := context.Background()
+
+	// Test several sizes and formats from a single pipeline
+	outputs := []struct {
+		format string
+		opts   encoder.EncodeOptions
+		want   image.Point
+	}{
+		{"jpg", encoder.EncodeOptions{Width: 100, Height: 100, Quality: 80}, image.Pt(100, 50)},
+		{"png", encoder.EncodeOptions{Width: 100, Height: 100}, image.Pt(100, 50)},
+		{"jpg", encoder.EncodeOptions{Width: 200, Quality: 90}, image.Pt(200, 100)},
+		{"png", encoder.EncodeOptions{}, image.Pt(400, 200)},
+	}
+
+	for i, out := range outputs {
+		outputPath := filepath.Join(tempDir, fmt.Sprintf("out_%d.%s", i, out.format))
+		if err := pipeline.Encode(ctx, out.format, outputPath, out.opts); err != nil {
+			t.Fatalf("Failed to encode %s: %v", out.format, err)
+		}
+
+		result, err := os.Open(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to open output: %v", err)
+		}
+		cfg, format, err := image.DecodeConfig(result)
+		result.Close()
+		if err != nil {
+			t.Fatalf("Failed to decode output %s: %v", outputPath, err)
+		}
+
+		if cfg.Width != out.want.X || cfg.Height != out.want.Y {
+			t.Errorf("Output %s: expected %v, got %dx%d", outputPath, out.want, cfg.Width, cfg.Height)
+		}
+		if (out.format == "jpg" && format != "jpeg") || (out.format == "png" && format != "png") {
+			t.Errorf("Output %s: expected format %s, got %s", outputPath, out.format, format)
+		}
+	}
+
+	// Test unknown format
+	err = pipeline.Encode(ctx, "unknown", filepath.Join(tempDir, "out.unknown"), encoder.EncodeOptions{})
+	if err == nil {
+		t.Error("Expected error for unknown encoder")
+	}
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/routes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileServerCaching(t *testing.T) {
+	// Outputs are named after the SHA-256 of the upload
+	variant := strings.Repeat("ab", 32) + "_user_photo.jpg_300_400_.jpg"
+	serveDir := t.TempDir()
+	os.MkdirAll(filepath.Join(serveDir, "tenant"), 0755)
+	os.MkdirAll(filepath.Join(serveDir, "previews"), 0755)
+	os.WriteFile(filepath.Join(serveDir, "tenant", variant), []byte("variant"), 0644)
+	os.WriteFile(filepath.Join(serveDir, "previews", "p.jpg"), []byte("preview"), 0644)
+	os.WriteFile(filepath.Join(serveDir, "tenant", "logo.png"), []byte("logo"), 0644)
+
+	server := http.StripPrefix("/files", routes.FileServer(serveDir))
+	get := func(url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/files/tenant/"+variant, nil)
+	if rec.Code != http.StatusOK || rec.Body.String() != "variant" {
+		t.Fatalf("Expected file content, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+		t.Errorf("Unexpected default Cache-Control: %q", got)
+	}
+	// Names without a content hash can be overwritten, so they are never immutable
+	if got := get("/files/tenant/logo.png", nil).Header().Get("Cache-Control"); got != "public, no-cache" {
+		t.Errorf("Expected revalidation for a file without a content hash, got %q", got)
+	}
+	etag := rec.Header().Get("ETag")
+	if len(etag) < 3 || etag[0] != '"' || etag[:2] == "W/" {
+		t.Fatalf("Expected a strong ETag, got %q", etag)
+	}
+
+	// Matching validator: 304 without a body, keeping the cache headers
+	rec = get("/files/tenant/"+variant, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("Expected empty 304, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("ETag") != etag || rec.Header().Get("Cache-Control") == "" {
+		t.Errorf("Expected ETag and Cache-Control on 304, got %v", rec.Header())
+	}
+
+	// Changed content gets a new ETag
+	path := filepath.Join(serveDir, "tenant", variant)
+	os.WriteFile(path, []byte("variant-v2"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	rec = get("/files/tenant/"+variant, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("Expected 200 with a new ETag after a change, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// Per-prefix rules, longest prefix wins
+	t.Setenv("PIXERVE_CACHE_CONTROL", "/=public, max-age=31536000, immutable;/previews/=no-cache")
+	if got := get("/files/previews/p.jpg", nil).Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("Expected no-cache for previews, got %q", got)
+	}
+	if got := get("/files/tenant/"+variant, nil).Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+		t.Errorf("Expected immutable for tenant files, got %q", got)
+	}
+
+	// Directory listings can be disabled
+	if rec := get("/files/tenant/", nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected directory listing by default, got %d", rec.Code)
+	}
+	t.Setenv("PIXERVE_DIRECTORY_LISTING", "false")
+	for _, url := range []string{"/files/tenant/", "/files/tenant", "/files/"} {
+		if rec := get(url, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s with listings disabled, got %d", url, rec.Code)
+		}
+	}
+	if rec := get("/files/tenant/"+variant, nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected files to stay reachable with listings disabled, got %d", rec.Code)
+	}
+}
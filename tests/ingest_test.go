@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"pixerve/routes"
+	"pixerve/utils"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestIsInternalAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		internal bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"93.184.216.34", false},
+		{"2606:4700::1111", false},
+	}
+
+	for _, tt := range tests {
+		if got := utils.IsInternalAddress(netip.MustParseAddr(tt.addr)); got != tt.internal {
+			t.Errorf("IsInternalAddress(%s) = %v, want %v", tt.addr, got, tt.internal)
+		}
+	}
+}
+
+// newIngestRequest signs a token for sourceURL and builds an /ingest request carrying it
+func newIngestRequest(t *testing.T, secret []byte, sourceURL string) *http.Request {
+	t.Helper()
+	token := signTestJWT(t, secret, jose.HS256, "", map[string]any{
+		"sub": "ingest-tenant",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"job": map[string]any{"directHost": true, "sourceUrl": sourceURL},
+	})
+	req := httptest.NewRequest(http.MethodPost, "/ingest", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestIngestFromURL(t *testing.T) {
+	openTestQueue(t, "test_ingest_queue.db")
+
+	secret := []byte("ingest-test-secret-key-at-least-32-bytes")
+	t.Setenv("PIXERVE_JWT_SECRET", string(secret))
+	t.Setenv("PIXERVE_SUBJECT_UPLOAD_LIMITS", "ingest-tenant=1KB")
+
+	image := bytes.Repeat([]byte("i"), 256)
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/photo.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(image)
+		case "/big.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(bytes.Repeat([]byte("b"), 2048))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	ingest := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.IngestHandler(rec, newIngestRequest(t, secret, origin.URL+path))
+		return rec
+	}
+
+	// The test origin listens on loopback, which is blocked by default
+	if rec := ingest("/photo.png"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for loopback source, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	t.Setenv("PIXERVE_INGEST_ALLOWED_NETWORKS", "127.0.0.0/8")
+
+	// Host allowlist applies on top of the network check
+	t.Setenv("PIXERVE_INGEST_ALLOWED_HOSTS", "images.example.com")
+	if rec := ingest("/photo.png"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for host outside the allowlist, got %d: %s", rec.Code, rec.Body.String())
+	}
+	t.Setenv("PIXERVE_INGEST_ALLOWED_HOSTS", "")
+
+	if rec := ingest("/page.html"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for non-image source, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := ingest("/big.png"); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized source, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := ingest("/missing.png"); rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 for origin error, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := ingest("/photo.png")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	jobDir := filepath.Join(os.TempDir(), response.Hash)
+	t.Cleanup(func() { os.RemoveAll(jobDir) })
+
+	stored, err := os.ReadFile(filepath.Join(jobDir, "photo.png"))
+	if err != nil {
+		t.Fatalf("Failed to read ingested file: %v", err)
+	}
+	if !bytes.Equal(stored, image) {
+		t.Error("Ingested file does not match the source content")
+	}
+}
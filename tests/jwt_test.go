@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"pixerve/models"
+	"pixerve/utils"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// signTestJWT signs claims with the given key, algorithm and optional key id
+func signTestJWT(t *testing.T, key any, alg jose.SignatureAlgorithm, kid string, claims any) string {
+	t.Helper()
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if kid != "" {
+		opts = opts.WithHeader("kid", kid)
+	}
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: alg, Key: key}, opts)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestVerifyJWTWithECDSAPublicKeyPEM(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	publicKey, err := utils.ParsePublicKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("Failed to parse public key PEM: %v", err)
+	}
+
+	claims := map[string]any{
+		"sub": "user-123",
+		"iss": "test-issuer",
+		"aud": "pixerve",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	token := signTestJWT(t, privateKey, jose.ES256, "", claims)
+
+	// Test successful verification with issuer and audience checks
+	verified, err := utils.VerifyPixerveJWT(token, utils.VerifyConfig{
+		PublicKey:        publicKey,
+		ExpectedIssuer:   "test-issuer",
+		ExpectedAudience: "pixerve",
+	})
+	if err != nil {
+		t.Fatalf("Failed to verify ES256 token: %v", err)
+	}
+
+	if verified.Subject != "user-123" {
+		t.Errorf("Expected subject user-123, got %s", verified.Subject)
+	}
+
+	// Test audience mismatch
+	_, err = utils.VerifyPixerveJWT(token, utils.VerifyConfig{
+		PublicKey:        publicKey,
+		ExpectedAudience: "other-service",
+	})
+	if !errors.Is(err, utils.ErrInvalidAudience) {
+		t.Errorf("Expected ErrInvalidAudience, got %v", err)
+	}
+
+	// Test that an HMAC secret does not verify an ES256 token
+	_, err = utils.VerifyPixerveJWT(token, utils.VerifyConfig{SecretKey: []byte("test-secret-key")})
+	if err == nil {
+		t.Error("Expected ES256 token to be rejected by HMAC-only config")
+	}
+}
+
+func TestVerifyJWTWithJWKSFile(t *testing.T) {
+	firstKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	secondKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	keySet := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &firstKey.PublicKey, KeyID: "rsa-1", Algorithm: string(jose.RS256), Use: "sig"},
+		{Key: &secondKey.PublicKey, KeyID: "ec-1", Algorithm: string(jose.ES256), Use: "sig"},
+	}}
+	data, err := json.Marshal(keySet)
+	if err != nil {
+		t.Fatalf("Failed to marshal key set: %v", err)
+	}
+
+	jwksPath := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksPath, data, 0644); err != nil {
+		t.Fatalf("Failed to write JWKS file: %v", err)
+	}
+
+	loaded, err := utils.LoadJWKSFile(jwksPath)
+	if err != nil {
+		t.Fatalf("Failed to load JWKS file: %v", err)
+	}
+
+	claims := models.PixerveJWT{Subject: "user-456", ExpiresAt: time.Now().Add(time.Hour).Unix()}
+
+	// Test kid-based selection for both keys
+	for _, tc := range []struct {
+		key any
+		alg jose.SignatureAlgorithm
+		kid string
+	}{
+		{firstKey, jose.RS256, "rsa-1"},
+		{secondKey, jose.ES256, "ec-1"},
+	} {
+		token := signTestJWT(t, tc.key, tc.alg, tc.kid, claims)
+		if _, err := utils.VerifyPixerveJWT(token, utils.VerifyConfig{KeySet: loaded}); err != nil {
+			t.Errorf("Failed to verify token with kid %s: %v", tc.kid, err)
+		}
+	}
+
+	// Test unknown kid
+	token := signTestJWT(t, firstKey, jose.RS256, "rotated-away", claims)
+	if _, err := utils.VerifyPixerveJWT(token, utils.VerifyConfig{KeySet: loaded}); err == nil {
+		t.Error("Expected token with unknown kid to be rejected")
+	}
+}
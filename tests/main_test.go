@@ -3,12 +3,16 @@ package tests
 import (
 	"os"
 	"path/filepath"
+	"pixerve/encoder"
 	"testing"
 )
 
 // TestMain is the main test function that runs before and after all tests
 // It automatically cleans up test database directories after all tests complete
 func TestMain(m *testing.M) {
+	// Encoders are registered once at startup, as in main
+	encoder.RegisterDefaults()
+
 	// Run all tests
 	code := m.Run()
 
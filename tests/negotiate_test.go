@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/models"
+	"pixerve/routes"
+	"pixerve/success"
+	"testing"
+)
+
+func TestNegotiatedImageHandler(t *testing.T) {
+	defer success.Close()
+	if err := success.Init("test_negotiate_success.db"); err != nil {
+		t.Fatalf("Failed to initialize success store: %v", err)
+	}
+	serveDir := t.TempDir()
+	t.Setenv("PIXERVE_SERVE_DIR", serveDir)
+
+	// 400x300 in three formats, 200x150 only as AVIF
+	os.MkdirAll(filepath.Join(serveDir, "tenant"), 0755)
+	files := map[string]string{
+		"abc123_photo.jpg_300_400_.avif": "avif-400",
+		"abc123_photo.jpg_300_400_.webp": "webp-400",
+		"abc123_photo.jpg_300_400_.jpg":  "jpg-400",
+		"abc123_photo.jpg_150_200_.avif": "avif-200",
+	}
+	var outputs []models.WrittenOutput
+	for name, content := range files {
+		os.WriteFile(filepath.Join(serveDir, "tenant", name), []byte(content), 0644)
+		outputs = append(outputs, models.WrittenOutput{Backend: "directServe", File: name, Complete: true})
+	}
+	success.StoreSuccessWithOutputs("abc123", "", nil, len(outputs), outputs)
+
+	// A numbered duplicate upload shares the hash prefix but is a different job
+	foreign := "abc123_2_other.jpg_150_200_.jpg"
+	os.WriteFile(filepath.Join(serveDir, "tenant", foreign), []byte("foreign-jpg-200"), 0644)
+	success.StoreSuccessWithOutputs("abc123_2", "", nil, 1, []models.WrittenOutput{{Backend: "directServe", File: foreign, Complete: true}})
+
+	get := func(url, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		routes.NegotiatedImageHandler(rec, req)
+		return rec
+	}
+
+	cases := []struct {
+		name, url, accept string
+		preference        string
+		wantCode          int
+		wantBody          string
+	}{
+		{"browser with avif", "/images/tenant/abc123/400x300", "image/avif,image/webp,image/*,*/*;q=0.8", "", http.StatusOK, "avif-400"},
+		{"webp only", "/images/tenant/abc123/400x300", "image/webp,image/*;q=0.8", "", http.StatusOK, "webp-400"},
+		{"wildcard only", "/images/tenant/abc123/400x300", "image/*", "", http.StatusOK, "jpg-400"},
+		{"no accept header", "/images/tenant/abc123/400x300", "", "", http.StatusOK, "jpg-400"},
+		{"avif refused", "/images/tenant/abc123/400x300", "image/avif;q=0,image/webp", "", http.StatusOK, "webp-400"},
+		{"higher q wins", "/images/tenant/abc123/400x300", "image/avif;q=0.5,image/webp;q=0.9", "", http.StatusOK, "webp-400"},
+		{"server preference", "/images/tenant/abc123/400x300", "image/avif,image/webp", "webp,avif,jpg", http.StatusOK, "webp-400"},
+		{"fallback to jpg", "/images/tenant/abc123/400x300", "image/gif", "", http.StatusOK, "jpg-400"},
+		{"no universal fallback", "/images/tenant/abc123/200x150", "image/jpeg", "", http.StatusNotAcceptable, ""},
+		{"duplicate upload", "/images/tenant/abc123_2/200x150", "image/jpeg", "", http.StatusOK, "foreign-jpg-200"},
+		{"unknown job", "/images/tenant/abc999/400x300", "image/avif", "", http.StatusNotFound, ""},
+		{"missing size", "/images/tenant/abc123/800x600", "image/avif", "", http.StatusNotFound, ""},
+		{"wrong subdir", "/images/other/abc123/400x300", "image/avif", "", http.StatusNotFound, ""},
+		{"traversal", "/images/../tenant/abc123/400x300", "image/avif", "", http.StatusOK, "avif-400"},
+		{"invalid size", "/images/tenant/abc123/wide", "image/avif", "", http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("PIXERVE_FORMAT_PREFERENCE", tc.preference)
+			rec := get(tc.url, tc.accept)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.wantCode, rec.Code, rec.Body.String())
+			}
+			if rec.Header().Get("Vary") != "Accept" && tc.wantCode != http.StatusBadRequest {
+				t.Errorf("Expected Vary: Accept, got %q", rec.Header().Get("Vary"))
+			}
+			if tc.wantBody != "" {
+				if body, _ := io.ReadAll(rec.Body); string(body) != tc.wantBody {
+					t.Errorf("Expected %q, got %q", tc.wantBody, body)
+				}
+			}
+		})
+	}
+
+	rec := get("/images/tenant/abc123/400x300", "image/webp")
+	if got := rec.Header().Get("Content-Type"); got != "image/webp" {
+		t.Errorf("Expected image/webp content type, got %q", got)
+	}
+	if got := rec.Header().Get("Content-Location"); got != "/files/tenant/abc123_photo.jpg_300_400_.webp" {
+		t.Errorf("Unexpected Content-Location: %q", got)
+	}
+}
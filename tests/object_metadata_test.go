@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/credentials"
+	"pixerve/job"
+	"pixerve/models"
+	"pixerve/success"
+	writerbackends "pixerve/writerBackends"
+	"strings"
+	"testing"
+)
+
+func TestObjectMetadataValidation(t *testing.T) {
+	valid := map[string]string{"tenant": "acme", "cost-center": "42", "build_id": "a b"}
+	if err := writerbackends.ValidateObjectMetadata(valid); err != nil {
+		t.Errorf("Expected valid metadata, got %v", err)
+	}
+
+	invalid := []map[string]string{
+		{"Tenant": "acme"},
+		{"tenant id": "acme"},
+		{"pixerve-hash": "forged"},
+		{"tenant": "ünïcode"},
+		{"tenant": "a\r\nX-Injected: 1"},
+		{"tenant": strings.Repeat("x", 2048)},
+	}
+	for _, metadata := range invalid {
+		if err := writerbackends.ValidateObjectMetadata(metadata); err == nil {
+			t.Errorf("Expected metadata %q to be rejected", metadata)
+		}
+	}
+
+	if err := writerbackends.ValidateCacheControl("public, max-age=31536000, immutable"); err != nil {
+		t.Errorf("Expected valid cacheControl, got %v", err)
+	}
+	if err := writerbackends.ValidateCacheControl("max-age=60\r\nX-Injected: 1"); err == nil {
+		t.Error("Expected cacheControl with a line break to be rejected")
+	}
+
+	_, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{Metadata: map[string]string{"pixerve-source": "forged"}},
+	})
+	if err == nil {
+		t.Error("Expected job metadata with the reserved prefix to be rejected")
+	}
+	if err := writerbackends.ValidateAccessInfo("gcs", map[string]string{
+		"credentialsJSON": "e30", "bucket": "b", "metadata.Team": "web",
+	}); err == nil {
+		t.Error("Expected bundle metadata with an upper case name to be rejected")
+	}
+
+	// Job and bundle metadata each within their limit can still exceed S3's 2 KB once merged
+	merged := map[string]string{
+		"metadata.job-notes":    strings.Repeat("j", 1000),
+		"metadata.bundle-notes": strings.Repeat("b", 1000),
+	}
+	for name, value := range writerbackends.ProvenanceMetadata("hash_user", "photo.jpg", "webp", 100, 100) {
+		merged[writerbackends.MetadataFieldPrefix+name] = value
+	}
+	if err := writerbackends.ValidateObjectMetadataSize(merged); err == nil {
+		t.Error("Expected merged metadata above 2 KB to be rejected")
+	}
+	delete(merged, "metadata.bundle-notes")
+	if err := writerbackends.ValidateObjectMetadataSize(merged); err != nil {
+		t.Errorf("Expected merged metadata within 2 KB, got %v", err)
+	}
+
+	// Long source filenames are shortened without splitting an escape
+	longName := strings.Repeat("ü", 200) + ".jpg"
+	source := writerbackends.ProvenanceMetadata("hash_user", longName, "copy", 0, 0)["pixerve-source"]
+	if len(source) > 256 || !strings.HasPrefix(source, "%C3%BC") || !strings.Contains(source, "...") {
+		t.Errorf("Expected a shortened source of at most 256 bytes, got %d: %q", len(source), source)
+	}
+	if i := strings.Index(source, "..."); strings.LastIndexByte(source[:i], '%') > i-3 {
+		t.Errorf("Expected the shortened source not to end in a partial escape, got %q", source[:i])
+	}
+
+	for ext, want := range map[string]string{"jpg": "image/jpeg", "webp": "image/webp", "avif": "image/avif", "PNG": "image/png", "": "application/octet-stream"} {
+		if got := writerbackends.ContentTypeForExt(ext); got != want {
+			t.Errorf("ContentTypeForExt(%q) = %q, want %q", ext, got, want)
+		}
+	}
+}
+
+func TestS3ObjectMetadata(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	defer credentials.CloseDB()
+	if err := credentials.OpenDB("test_object_metadata_credentials.db"); err != nil {
+		t.Fatalf("Failed to initialize credentials store: %v", err)
+	}
+	defer success.Close()
+	if err := success.Init("test_object_metadata_success.db"); err != nil {
+		t.Fatalf("Failed to initialize success store: %v", err)
+	}
+
+	bundle := map[string]string{
+		"accessKey":     "minio",
+		"secretKey":     "minio-secret",
+		"region":        "us-east-1",
+		"bucket":        "images",
+		"endpoint":      server.URL,
+		"usePathStyle":  "true",
+		"pathTemplate":  "{hash}/{format}/{width}x{height}",
+		"cacheControl":  "no-cache",
+		"metadata.team": "web",
+		"metadata.tier": "bundle",
+	}
+	if err := credentials.RegisterCredentials("s3-metadata", credentials.Metadata{Owner: "user-1", Type: "s3"}, bundle); err != nil {
+		t.Fatalf("Failed to register credentials: %v", err)
+	}
+
+	combined, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{
+			KeepOriginal: true,
+			StorageKeys:  map[string]string{"s3": "s3-metadata"},
+			CacheControl: "public, max-age=31536000, immutable",
+			Metadata:     map[string]string{"tier": "job"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse job: %v", err)
+	}
+
+	jobDir := t.TempDir()
+	os.WriteFile(filepath.Join(jobDir, "my photo.jpg"), []byte("original-bytes"), 0644)
+	instr := job.JobInstructions{FilePath: jobDir, OriginalFile: "my photo.jpg", Hash: "metahash_user", Job: combined}
+	if err := job.WriteInstructions(jobDir, instr); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+	if err := job.ProcessJob(context.Background(), jobDir); err != nil {
+		t.Fatalf("Failed to process job: %v", err)
+	}
+
+	var put *http.Request
+	for _, r := range fake.requests {
+		if r.Method == http.MethodPut {
+			put = r
+		}
+	}
+	if put == nil || put.URL.Path != "/images/metahash_user/original/0x0" {
+		t.Fatalf("Expected upload of /images/metahash_user/original/0x0, got %v", fake.objects)
+	}
+
+	// The path has no extension; the content type comes from the output itself
+	want := map[string]string{
+		"Content-Type":               "image/jpeg",
+		"Cache-Control":              "public, max-age=31536000, immutable",
+		"X-Amz-Meta-Team":            "web",
+		"X-Amz-Meta-Tier":            "job",
+		"X-Amz-Meta-Pixerve-Hash":    "metahash_user",
+		"X-Amz-Meta-Pixerve-Source":  "my%20photo.jpg",
+		"X-Amz-Meta-Pixerve-Encoder": "copy",
+	}
+	for header, value := range want {
+		if got := put.Header.Get(header); got != value {
+			t.Errorf("Expected %s %q, got %q", header, value, got)
+		}
+	}
+	if got := put.Header.Get("X-Amz-Meta-Pixerve-Width"); got != "" {
+		t.Errorf("Expected no dimensions for the kept original, got width %q", got)
+	}
+}
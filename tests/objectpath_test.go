@@ -0,0 +1,77 @@
+package tests
+
+import (
+	"pixerve/job"
+	"pixerve/models"
+	writerbackends "pixerve/writerBackends"
+	"testing"
+)
+
+func TestRenderPath(t *testing.T) {
+	vars := writerbackends.PathVars{
+		Prefix:   "tenant-a",
+		SubDir:   "avatars",
+		Hash:     "abc_user",
+		Name:     "photo",
+		Format:   "webp",
+		Width:    300,
+		Height:   400,
+		Ext:      "webp",
+		Filename: "abc_user_photo_400_300_.webp",
+	}
+
+	tests := []struct {
+		template string
+		vars     writerbackends.PathVars
+		expected string
+	}{
+		{"", vars, "tenant-a/avatars/abc_user_photo_400_300_.webp"},
+		{"{prefix}/{subDir}/{hash}/{format}/{width}x{height}.{ext}", vars, "tenant-a/avatars/abc_user/webp/300x400.webp"},
+		{"{prefix}/{subDir}/{filename}", writerbackends.PathVars{Filename: "a.jpg"}, "a.jpg"},
+		{"/{prefix}//{name}-{hash}.{ext}/", vars, "tenant-a/photo-abc_user.webp"},
+	}
+	for _, tt := range tests {
+		got, err := writerbackends.RenderPath(tt.template, tt.vars)
+		if err != nil {
+			t.Errorf("RenderPath(%q) failed: %v", tt.template, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("RenderPath(%q) = %q, expected %q", tt.template, got, tt.expected)
+		}
+	}
+
+	for _, template := range []string{"{bucket}/{filename}", "{subDir}/../{filename}", "{prefix}/{subDir}"} {
+		if _, err := writerbackends.RenderPath(template, writerbackends.PathVars{Filename: "a.jpg"}); err == nil {
+			t.Errorf("Expected RenderPath(%q) to fail", template)
+		}
+	}
+}
+
+func TestValidatePathTemplate(t *testing.T) {
+	valid := []string{"", "{filename}", "{prefix}/{hash}/{format}/{width}x{height}.{ext}"}
+	for _, template := range valid {
+		if err := writerbackends.ValidatePathTemplate(template); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", template, err)
+		}
+	}
+
+	invalid := []string{"{hash}.{ext}", "{hash}/{format}/{width}.{ext}", "{unknown}/{filename}", "../{filename}"}
+	for _, template := range invalid {
+		if err := writerbackends.ValidatePathTemplate(template); err == nil {
+			t.Errorf("Expected %q to be rejected", template)
+		}
+	}
+
+	if err := writerbackends.ValidateAccessInfo("gcs", map[string]string{
+		"credentialsJSON": "{}",
+		"bucket":          "b",
+		"pathTemplate":    "{name}.{ext}",
+	}); err == nil {
+		t.Error("Expected a bundle with a colliding path template to be rejected")
+	}
+
+	if _, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{Job: models.JobSpec{PathTemplate: "{name}"}}); err == nil {
+		t.Error("Expected a job with a colliding path template to be rejected")
+	}
+}
@@ -2,22 +2,37 @@ package tests
 
 import (
 	"fmt"
+	"os"
 	"pixerve/job"
+	"pixerve/taskQueue"
 	"testing"
 )
 
+// openTestQueue opens a fresh job queue database for the duration of a test
+func openTestQueue(t *testing.T, path string) *taskQueue.DBQueue {
+	t.Helper()
+	os.RemoveAll(path)
+	if err := taskQueue.OpenConvertQueueDB(path); err != nil {
+		t.Fatalf("Failed to open test queue: %v", err)
+	}
+	t.Cleanup(func() {
+		taskQueue.CloseConvertQueueDB()
+	})
+	return taskQueue.ConvertQueue
+}
+
 func TestPendingJobs(t *testing.T) {
-	// Clear any existing pending jobs for clean test
-	// Note: In a real scenario, you'd use a separate test database
+	// Use a separate test database for a clean queue
+	openTestQueue(t, "test_pending_queue.db")
 
 	// Test adding pending jobs
 	job1 := "/tmp/job1"
 	job2 := "/tmp/job2"
 	job3 := "/tmp/job3"
 
-	job.AddPendingJob(job1)
-	job.AddPendingJob(job2)
-	job.AddPendingJob(job3)
+	job.AddPendingJob(job1, 0)
+	job.AddPendingJob(job2, 0)
+	job.AddPendingJob(job3, 0)
 
 	// Test getting pending jobs
 	pending := job.GetPendingJobs()
@@ -73,13 +88,15 @@ func TestPendingJobs(t *testing.T) {
 }
 
 func TestPendingJobsConcurrency(t *testing.T) {
+	openTestQueue(t, "test_pending_concurrency_queue.db")
+
 	// Test concurrent access to pending jobs
 	done := make(chan bool, 10)
 
 	for i := 0; i < 10; i++ {
 		go func(id int) {
 			jobDir := fmt.Sprintf("/tmp/concurrent-job-%d", id)
-			job.AddPendingJob(jobDir)
+			job.AddPendingJob(jobDir, 0)
 
 			// Simulate some work
 			pending := job.GetPendingJobs()
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/job"
+	"pixerve/models"
+	"pixerve/routes"
+	"pixerve/success"
+	"pixerve/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestPrivateJobParsing(t *testing.T) {
+	if _, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{Job: models.JobSpec{Private: true}}); err == nil {
+		t.Error("Expected private without directHost to be rejected")
+	}
+
+	combined, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{Job: models.JobSpec{DirectHost: true, Private: true}})
+	if err != nil {
+		t.Fatalf("Failed to parse private job: %v", err)
+	}
+	if len(combined.WriterJobs) != 1 || combined.WriterJobs[0].Credentials["private"] != "true" {
+		t.Errorf("Expected a private directServe writer, got %+v", combined.WriterJobs)
+	}
+}
+
+func TestPrivateURLs(t *testing.T) {
+	defer success.Close()
+	if err := success.Init("test_private_success.db"); err != nil {
+		t.Fatalf("Failed to initialize success store: %v", err)
+	}
+	privateDir := t.TempDir()
+	t.Setenv("PIXERVE_PRIVATE_SERVE_DIR", privateDir)
+
+	secret := []byte("private-test-secret-key-at-least-32-bytes")
+	t.Setenv("PIXERVE_JWT_SECRET", string(secret))
+
+	hash := "abc123_private-tenant"
+	os.MkdirAll(filepath.Join(privateDir, "tenant"), 0755)
+	os.WriteFile(filepath.Join(privateDir, "tenant", hash+"_photo_300_400_.jpg"), []byte("private-bytes"), 0644)
+	os.WriteFile(filepath.Join(privateDir, "tenant", "other_someone_photo_300_400_.jpg"), []byte("other"), 0644)
+	// A duplicate upload is numbered rather than named after its subject
+	os.WriteFile(filepath.Join(privateDir, "tenant", "abc123_2_photo_300_400_.jpg"), []byte("duplicate"), 0644)
+
+	// Owners and outputs are recorded with the finished jobs
+	written := func(file string) []models.WrittenOutput {
+		return []models.WrittenOutput{{Backend: "directServe", File: file, Complete: true}}
+	}
+	success.StoreSuccessWithOutputs(hash, "private-tenant", nil, 1, written(hash+"_photo_300_400_.jpg"))
+	success.StoreSuccessWithOutputs("abc123_2", "private-tenant", nil, 1, written("abc123_2_photo_300_400_.jpg"))
+	success.StoreSuccessWithOutputs("other_someone", "someone", nil, 1, written("other_someone_photo_300_400_.jpg"))
+
+	tokenFor := func(subject string) string {
+		return signTestJWT(t, secret, jose.HS256, "", map[string]any{
+			"sub": subject,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"job": map[string]any{"directHost": true, "private": true, "subDir": "tenant"},
+		})
+	}
+	mintAs := func(subject, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/private-urls?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+tokenFor(subject))
+		rec := httptest.NewRecorder()
+		routes.PrivateURLsHandler(rec, req)
+		return rec
+	}
+	mint := func(query string) *httptest.ResponseRecorder {
+		return mintAs("private-tenant", query)
+	}
+	serve := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.PrivateFileHandler(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	// Disabled without a key
+	if rec := mint("hash=" + hash); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a private URL key, got %d", rec.Code)
+	}
+
+	key := []byte("private-url-key")
+	t.Setenv("PIXERVE_PRIVATE_URL_KEY", string(key))
+
+	rec := mint("hash=" + hash + "&ttl=10m")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response routes.PrivateURLsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.URLs) != 1 {
+		t.Fatalf("Expected one URL for the job's file, got %v", response.URLs)
+	}
+	if until := time.Until(response.Expires); until <= 0 || until > 10*time.Minute {
+		t.Errorf("Expected expiry within 10 minutes, got %s", response.Expires)
+	}
+
+	url := response.URLs[hash+"_photo_300_400_.jpg"]
+	if rec := serve(url); rec.Code != http.StatusOK || rec.Body.String() != "private-bytes" {
+		t.Fatalf("Expected the private file, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Signatures cover both the path and the expiry
+	if rec := serve(strings.Replace(url, "_photo_", "_other_", 1)); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a tampered path, got %d", rec.Code)
+	}
+	if rec := serve(strings.Replace(url, "expires=", "expires=9", 1)); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a tampered expiry, got %d", rec.Code)
+	}
+	expired := utils.PrivateURL(key, "tenant/"+hash+"_photo_300_400_.jpg", time.Now().Add(-time.Minute))
+	if rec := serve(expired); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for an expired URL, got %d", rec.Code)
+	}
+
+	// Only the token's own hashes, within the maximum validity
+	if rec := mint("hash=other_someone"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a foreign hash, got %d", rec.Code)
+	}
+	if rec := mint("hash=" + hash + "&ttl=10000h"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a ttl above the maximum, got %d", rec.Code)
+	}
+	if rec := mint("hash=missing_private-tenant"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a hash without files, got %d", rec.Code)
+	}
+
+	// Ownership comes from the job record, not the hash suffix
+	if rec := mint("hash=abc123_2"); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 for the subject's numbered duplicate upload, got %d", rec.Code)
+	}
+	if rec := mintAs("2", "hash=abc123_2"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a subject matching only the hash suffix, got %d", rec.Code)
+	}
+
+	// Only files the job itself wrote, even where another job's hash extends this one
+	os.WriteFile(filepath.Join(privateDir, "tenant", "def456_alice_photo_300_400_.jpg"), []byte("alice"), 0644)
+	os.WriteFile(filepath.Join(privateDir, "tenant", "def456_alice_x_photo_300_400_.jpg"), []byte("alice_x"), 0644)
+	success.StoreSuccessWithOutputs("def456_alice", "alice", nil, 1, written("def456_alice_photo_300_400_.jpg"))
+	success.StoreSuccessWithOutputs("def456_alice_x", "alice_x", nil, 1, written("def456_alice_x_photo_300_400_.jpg"))
+	for subject, want := range map[string]string{"alice": "def456_alice_photo_300_400_.jpg", "alice_x": "def456_alice_x_photo_300_400_.jpg"} {
+		rec := mintAs(subject, "hash=def456_"+subject)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200 for %s, got %d: %s", subject, rec.Code, rec.Body.String())
+		}
+		var response routes.PrivateURLsResponse
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		if _, ok := response.URLs[want]; !ok || len(response.URLs) != 1 {
+			t.Errorf("Expected only %s for %s, got %v", want, subject, response.URLs)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"pixerve/job"
+	"pixerve/taskQueue"
+	"testing"
+	"time"
+)
+
+func TestJobQueueLeaseAndAck(t *testing.T) {
+	q := openTestQueue(t, "test_job_queue.db")
+
+	// Test FIFO leasing
+	for _, hash := range []string{"hash-a", "hash-b"} {
+		if _, err := q.Enqueue(hash, "/tmp/"+hash, taskQueue.PriorityRealtime); err != nil {
+			t.Fatalf("Failed to enqueue %s: %v", hash, err)
+		}
+	}
+
+	rec, err := q.Lease("worker-1", time.Minute, taskQueue.LeasePolicy{AllowQueued: true})
+	if err != nil || rec == nil {
+		t.Fatalf("Failed to lease job: %v", err)
+	}
+	if rec.Hash != "hash-a" || rec.Status != taskQueue.JobProcessing || rec.Attempts != 1 {
+		t.Errorf("Unexpected leased record: %+v", rec)
+	}
+
+	// Test that re-enqueuing a processing job is rejected
+	if _, err := q.Enqueue("hash-a", "/tmp/hash-a", taskQueue.PriorityRealtime); !errors.Is(err, taskQueue.ErrJobActive) {
+		t.Errorf("Expected ErrJobActive, got %v", err)
+	}
+
+	// Test that only the lease owner can ack
+	if err := q.Ack("hash-a", "worker-2", taskQueue.JobCompleted, ""); !errors.Is(err, taskQueue.ErrLeaseLost) {
+		t.Errorf("Expected ErrLeaseLost, got %v", err)
+	}
+	if err := q.Ack("hash-a", "worker-1", taskQueue.JobCompleted, ""); err != nil {
+		t.Fatalf("Failed to ack job: %v", err)
+	}
+
+	completed, err := q.GetJob("hash-a")
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if completed.Status != taskQueue.JobCompleted || completed.LeaseOwner != "" {
+		t.Errorf("Expected released completed job, got %+v", completed)
+	}
+
+	// Test crash recovery: a lease held by a previous run returns to pending
+	rec, err = q.Lease("previous-run", time.Minute, taskQueue.LeasePolicy{AllowQueued: true})
+	if err != nil || rec == nil || rec.Hash != "hash-b" {
+		t.Fatalf("Failed to lease second job: %v, %+v", err, rec)
+	}
+	if next, _ := q.Lease("previous-run", time.Minute, taskQueue.LeasePolicy{AllowQueued: true}); next != nil {
+		t.Errorf("Expected empty queue, leased %s", next.Hash)
+	}
+
+	recovered, err := q.RecoverLeases("current-run")
+	if err != nil {
+		t.Fatalf("Failed to recover leases: %v", err)
+	}
+	if recovered != 1 {
+		t.Errorf("Expected 1 recovered job, got %d", recovered)
+	}
+
+	rec, err = q.Lease("current-run", time.Minute, taskQueue.LeasePolicy{AllowQueued: true})
+	if err != nil || rec == nil || rec.Hash != "hash-b" {
+		t.Fatalf("Expected recovered job to be leased again: %v, %+v", err, rec)
+	}
+	if rec.Attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", rec.Attempts)
+	}
+
+	// Test that live leases of the current run are not recovered
+	recovered, err = q.RecoverLeases("current-run")
+	if err != nil || recovered != 0 {
+		t.Errorf("Expected no recovered jobs, got %d (%v)", recovered, err)
+	}
+}
+
+func TestRecoverPendingJobs(t *testing.T) {
+	openTestQueue(t, "test_recover_queue.db")
+
+	// A job with instructions on disk and one whose directory is gone
+	validDir := filepath.Join(t.TempDir(), "validhash")
+	if err := os.MkdirAll(validDir, 0755); err != nil {
+		t.Fatalf("Failed to create job dir: %v", err)
+	}
+	if err := job.WriteInstructions(validDir, job.JobInstructions{FilePath: validDir, Hash: "validhash"}); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+	missingDir := filepath.Join(t.TempDir(), "missinghash")
+
+	if err := job.AddPendingJob(validDir, 0); err != nil {
+		t.Fatalf("Failed to add job: %v", err)
+	}
+	if err := job.AddPendingJob(missingDir, 0); err != nil {
+		t.Fatalf("Failed to add job: %v", err)
+	}
+
+	if err := job.RecoverPendingJobs(); err != nil {
+		t.Fatalf("Failed to recover jobs: %v", err)
+	}
+
+	pending := job.GetPendingJobs()
+	if len(pending) != 1 || pending[0] != validDir {
+		t.Errorf("Expected only %s pending, got %v", validDir, pending)
+	}
+
+	state, exists := job.GetJobState("missinghash")
+	if !exists || state != job.JobStateFailed {
+		t.Errorf("Expected job with missing directory to be failed, got %v (exists=%v)", state, exists)
+	}
+
+	// Test cancelling the remaining pending job
+	if _, err := job.CancelJob("validhash", false); err != nil {
+		t.Fatalf("Failed to cancel pending job: %v", err)
+	}
+	if state, _ := job.GetJobState("validhash"); state != job.JobStateCancelled {
+		t.Errorf("Expected cancelled state, got %v", state)
+	}
+	if _, err := os.Stat(validDir); !os.IsNotExist(err) {
+		t.Error("Expected cancelled job directory to be removed")
+	}
+}
+
+func TestJobQueuePriority(t *testing.T) {
+	q := openTestQueue(t, "test_priority_queue.db")
+
+	enqueue := func(hash string, priority int) {
+		t.Helper()
+		if _, err := q.Enqueue(hash, "/tmp/"+hash, priority); err != nil {
+			t.Fatalf("Failed to enqueue %s: %v", hash, err)
+		}
+	}
+	leaseHash := func(policy taskQueue.LeasePolicy) string {
+		t.Helper()
+		rec, err := q.Lease("worker", time.Minute, policy)
+		if err != nil {
+			t.Fatalf("Failed to lease: %v", err)
+		}
+		if rec == nil {
+			return ""
+		}
+		return rec.Hash
+	}
+
+	// Realtime jobs are served first even if they arrived later
+	enqueue("queued-1", taskQueue.PriorityQueued)
+	enqueue("realtime-1", taskQueue.PriorityRealtime)
+
+	if got := leaseHash(taskQueue.LeasePolicy{}); got != "realtime-1" {
+		t.Errorf("Expected realtime-1, got %q", got)
+	}
+
+	// Without queued capacity the queued job waits
+	if got := leaseHash(taskQueue.LeasePolicy{}); got != "" {
+		t.Errorf("Expected no eligible job, got %q", got)
+	}
+	if got := leaseHash(taskQueue.LeasePolicy{AllowQueued: true}); got != "queued-1" {
+		t.Errorf("Expected queued-1, got %q", got)
+	}
+
+	// An aged queued job competes with realtime jobs in arrival order
+	enqueue("queued-2", taskQueue.PriorityQueued)
+	time.Sleep(10 * time.Millisecond)
+	enqueue("realtime-2", taskQueue.PriorityRealtime)
+
+	aging := taskQueue.LeasePolicy{AgingAfter: 5 * time.Millisecond}
+	if got := leaseHash(aging); got != "queued-2" {
+		t.Errorf("Expected aged queued-2, got %q", got)
+	}
+	if got := leaseHash(aging); got != "realtime-2" {
+		t.Errorf("Expected realtime-2, got %q", got)
+	}
+
+	// Priorities outside the supported range are normalized
+	rec, err := q.Enqueue("queued-3", "/tmp/queued-3", 7)
+	if err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	if rec.Priority != taskQueue.PriorityQueued {
+		t.Errorf("Expected priority %d, got %d", taskQueue.PriorityQueued, rec.Priority)
+	}
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pixerve/job"
+	"pixerve/taskQueue"
+	writerbackends "pixerve/writerBackends"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"google.golang.org/api/googleapi"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{20, 30 * time.Second, time.Minute}, // capped
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			delay := job.BackoffDelay(tt.attempt, time.Second, time.Minute)
+			if delay < tt.min || delay > tt.max {
+				t.Errorf("BackoffDelay(%d) = %v, want between %v and %v", tt.attempt, delay, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+// s3Error builds an S3 API error as returned by the SDK for the given status and code
+func s3Error(status int, code string) error {
+	return &smithy.OperationError{
+		ServiceID:     "S3",
+		OperationName: "PutObject",
+		Err: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+			Err:      &smithy.GenericAPIError{Code: code, Message: code},
+		},
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	transient := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	if !job.IsRetryable(transient) {
+		t.Error("Expected network error to be retryable")
+	}
+	if !job.IsRetryable(&job.JobError{Err: fmt.Errorf("failed to write a.jpg to sftp: %w", transient)}) {
+		t.Error("Expected wrapped transient error to be retryable")
+	}
+	if job.IsRetryable(errors.New("something unexpected")) {
+		t.Error("Expected unclassified error not to be retryable")
+	}
+
+	// Storage backends: throttling and server errors are retried, rejected requests are not
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"s3 slow down", s3Error(http.StatusServiceUnavailable, "SlowDown"), true},
+		{"s3 internal error", s3Error(http.StatusInternalServerError, "InternalError"), true},
+		{"s3 access denied", s3Error(http.StatusForbidden, "AccessDenied"), false},
+		{"s3 missing bucket", s3Error(http.StatusNotFound, "NoSuchBucket"), false},
+		{"s3 metadata too large", s3Error(http.StatusBadRequest, "MetadataTooLarge"), false},
+		{"gcs unavailable", &googleapi.Error{Code: http.StatusServiceUnavailable}, true},
+		{"gcs rate limited", &googleapi.Error{Code: http.StatusTooManyRequests}, true},
+		{"gcs forbidden", &googleapi.Error{Code: http.StatusForbidden, Message: "does not have storage.objects.create access"}, false},
+		{"timeout", fmt.Errorf("upload: %w", context.DeadlineExceeded), true},
+		{"checksum mismatch", fmt.Errorf("%w: ETag differs", writerbackends.ErrChecksumMismatch), true},
+	}
+	for _, tt := range tests {
+		err := &job.JobError{Err: fmt.Errorf("failed to write a.jpg: %w", tt.err)}
+		if got := job.IsRetryable(err); got != tt.retryable {
+			t.Errorf("%s: IsRetryable = %v, want %v", tt.name, got, tt.retryable)
+		}
+	}
+
+	permanent := job.Permanent(errors.New("encoder unknown not found"))
+	if job.IsRetryable(permanent) {
+		t.Error("Expected permanent error not to be retryable")
+	}
+	if job.IsRetryable(&job.JobError{Err: fmt.Errorf("conversion failed: %w", permanent)}) {
+		t.Error("Expected wrapped permanent error not to be retryable")
+	}
+	if permanent.Error() != "encoder unknown not found" {
+		t.Errorf("Permanent should not change the message, got %q", permanent.Error())
+	}
+
+	if job.IsRetryable(context.Canceled) {
+		t.Error("Expected cancellation not to be retryable")
+	}
+}
+
+func TestRetryAndDeadLetter(t *testing.T) {
+	q := openTestQueue(t, "test_retry_queue.db")
+
+	jobDir := filepath.Join(t.TempDir(), "retryhash")
+	if _, err := q.Enqueue("retryhash", jobDir, taskQueue.PriorityRealtime); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	policy := taskQueue.LeasePolicy{AllowQueued: true}
+
+	rec, err := q.Lease("worker", time.Minute, policy)
+	if err != nil || rec == nil {
+		t.Fatalf("Failed to lease: %v", err)
+	}
+
+	// A retry that is due is leased again as the next attempt
+	if err := q.Retry("retryhash", "worker", "s3 timeout", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to schedule retry: %v", err)
+	}
+	rec, err = q.Lease("worker", time.Minute, policy)
+	if err != nil || rec == nil {
+		t.Fatalf("Expected due retry to be leased: %v", err)
+	}
+	if rec.Attempts != 2 || len(rec.History) != 1 || rec.History[0].Error != "s3 timeout" {
+		t.Errorf("Unexpected record after retry: %+v", rec)
+	}
+
+	// A retry still in backoff is not leased
+	if _, err := q.Enqueue("backoffhash", "/tmp/backoffhash", taskQueue.PriorityRealtime); err != nil {
+		t.Fatalf("Failed to enqueue: %v", err)
+	}
+	if next, _ := q.Lease("worker", time.Minute, policy); next == nil || next.Hash != "backoffhash" {
+		t.Fatalf("Failed to lease backoffhash: %+v", next)
+	}
+	if err := q.Retry("backoffhash", "worker", "sftp dial error", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to schedule retry: %v", err)
+	}
+	if next, _ := q.Lease("worker", time.Minute, policy); next != nil {
+		t.Errorf("Expected job in backoff not to be leased, got %s", next.Hash)
+	}
+
+	// Exhausted retries go to the dead-letter list
+	if err := q.Ack("retryhash", "worker", taskQueue.JobDeadLetter, "s3 timeout"); err != nil {
+		t.Fatalf("Failed to dead-letter job: %v", err)
+	}
+	dead, err := job.ListDeadLetterJobs()
+	if err != nil || len(dead) != 1 || dead[0].Hash != "retryhash" {
+		t.Fatalf("Expected one dead-letter job, got %v (%v)", dead, err)
+	}
+	if len(dead[0].History) != 2 || dead[0].History[1].Outcome != taskQueue.JobDeadLetter {
+		t.Errorf("Expected attempt history to be kept, got %+v", dead[0].History)
+	}
+
+	// Requeue fails while the job files are missing
+	if _, err := job.RequeueDeadLetterJob("retryhash"); !errors.Is(err, job.ErrJobFilesMissing) {
+		t.Errorf("Expected ErrJobFilesMissing, got %v", err)
+	}
+
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		t.Fatalf("Failed to create job dir: %v", err)
+	}
+	if err := job.WriteInstructions(jobDir, job.JobInstructions{FilePath: jobDir, Hash: "retryhash"}); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+
+	requeued, err := job.RequeueDeadLetterJob("retryhash")
+	if err != nil {
+		t.Fatalf("Failed to requeue: %v", err)
+	}
+	if requeued.Status != taskQueue.JobPending || requeued.Attempts != 0 || len(requeued.History) != 2 {
+		t.Errorf("Unexpected requeued record: %+v", requeued)
+	}
+
+	// Only dead-lettered jobs can be requeued
+	if _, err := job.RequeueDeadLetterJob("retryhash"); !errors.Is(err, taskQueue.ErrJobNotDeadLettered) {
+		t.Errorf("Expected ErrJobNotDeadLettered, got %v", err)
+	}
+}
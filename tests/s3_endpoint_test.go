@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	writerbackends "pixerve/writerBackends"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is a minimal path-style S3-compatible server holding objects in memory
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	requests []*http.Request
+	corrupt  bool // store a damaged copy of uploaded objects
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r)
+
+	switch {
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		if f.corrupt {
+			data = append(data, '!')
+		}
+		f.objects[r.URL.Path] = data
+		sum := md5.Sum(data)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == http.MethodHead && strings.Count(r.URL.Path, "/") == 1:
+		// HeadBucket
+	case r.Method == http.MethodHead:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3CompatibleEndpoint(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	accessInfo := map[string]string{
+		"accessKey":    "minio",
+		"secretKey":    "minio-secret",
+		"sessionToken": "session-123",
+		"region":       "us-east-1",
+		"bucket":       "images",
+		"objectPath":   "photos/a.jpg",
+		"endpoint":     strings.TrimPrefix(server.URL, "http://"),
+		"disableTLS":   "true",
+		"usePathStyle": "true",
+	}
+	if err := writerbackends.ValidateAccessInfo("s3", accessInfo); err != nil {
+		t.Fatalf("Expected valid bundle, got %v", err)
+	}
+
+	ctx := context.Background()
+	writer, _ := writerbackends.Get("s3")
+	if err := writer.HealthCheck(ctx, accessInfo); err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	if _, err := writerbackends.WriteImage(ctx, accessInfo, strings.NewReader("image-data"), "s3"); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	if got := string(fake.objects["/images/photos/a.jpg"]); got != "image-data" {
+		t.Errorf("Expected path-style object /images/photos/a.jpg, got %v", fake.objects)
+	}
+	for _, r := range fake.requests {
+		if r.Header.Get("X-Amz-Security-Token") != "session-123" {
+			t.Errorf("Expected session token on %s %s", r.Method, r.URL.Path)
+		}
+	}
+
+	if info, err := writer.Stat(ctx, accessInfo); err != nil || info.Size != int64(len("image-data")) {
+		t.Errorf("Unexpected stat result: %+v, %v", info, err)
+	}
+	if err := writerbackends.DeleteImage(ctx, accessInfo, "s3"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := writer.Stat(ctx, accessInfo); !errors.Is(err, writerbackends.ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound after delete, got %v", err)
+	}
+}
+
+func TestS3EndpointValidation(t *testing.T) {
+	base := map[string]string{"accessKey": "a", "secretKey": "s", "region": "auto", "bucket": "b"}
+	with := func(fields map[string]string) map[string]string {
+		accessInfo := map[string]string{}
+		for k, v := range base {
+			accessInfo[k] = v
+		}
+		for k, v := range fields {
+			accessInfo[k] = v
+		}
+		return accessInfo
+	}
+
+	valid := []map[string]string{
+		{},
+		{"endpoint": "https://account.r2.cloudflarestorage.com"},
+		{"endpoint": "minio.internal:9000", "disableTLS": "true", "usePathStyle": "true"},
+	}
+	for _, fields := range valid {
+		if err := writerbackends.ValidateAccessInfo("s3", with(fields)); err != nil {
+			t.Errorf("Expected %v to be valid, got %v", fields, err)
+		}
+	}
+
+	invalid := []map[string]string{
+		{"endpoint": "ftp://minio.internal"},
+		{"endpoint": "https://"},
+		{"usePathStyle": "yes please"},
+		{"endpoint": "https://minio.internal", "disableTLS": "true"},
+	}
+	for _, fields := range invalid {
+		if err := writerbackends.ValidateAccessInfo("s3", with(fields)); err == nil {
+			t.Errorf("Expected %v to be rejected", fields)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package tests
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/credentials"
+	"pixerve/job"
+	"pixerve/models"
+	"pixerve/success"
+	writerbackends "pixerve/writerBackends"
+	"strings"
+	"testing"
+)
+
+func TestS3OptionsValidation(t *testing.T) {
+	customerKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	bundle := func(fields map[string]string) map[string]string {
+		accessInfo := map[string]string{"accessKey": "a", "secretKey": "s", "region": "us-east-1", "bucket": "images"}
+		for k, v := range fields {
+			accessInfo[k] = v
+		}
+		return accessInfo
+	}
+
+	valid := []map[string]string{
+		{"serverSideEncryption": "aws:kms", "sseKmsKeyId": "arn:aws:kms:us-east-1:111122223333:key/abc"},
+		{"serverSideEncryption": "AES256", "storageClass": "INTELLIGENT_TIERING", "acl": "private", "tags": "team=web&env=prod"},
+		{"sseCustomerKey": customerKey},
+		{"storageClass": "STANDARD", "original.storageClass": "STANDARD_IA", "original.tags": "tier=cold"},
+		// An output override replaces the bundle's encryption as a whole
+		{"sseCustomerKey": customerKey, "original.serverSideEncryption": "aws:kms"},
+		{"metadata.acl": "not-an-acl"},
+	}
+	for _, fields := range valid {
+		if err := writerbackends.ValidateAccessInfo("s3", bundle(fields)); err != nil {
+			t.Errorf("Expected %v to be valid, got %v", fields, err)
+		}
+	}
+
+	invalid := []map[string]string{
+		{"serverSideEncryption": "rot13"},
+		{"sseKmsKeyId": "arn:aws:kms:us-east-1:111122223333:key/abc"},
+		{"serverSideEncryption": "AES256", "sseKmsKeyId": "arn:aws:kms:us-east-1:111122223333:key/abc"},
+		{"sseCustomerKey": "c2hvcnQ="},
+		{"sseCustomerKey": customerKey, "serverSideEncryption": "AES256"},
+		{"storageClass": "COLD_STORAGE"},
+		{"acl": "everyone"},
+		{"tags": "a=1&b=2&c=3&d=4&e=5&f=6&g=7&h=8&i=9&j=10&k=11"},
+		{"tags": "a=1&a=2"},
+		{"original.storageClass": "COLD_STORAGE"},
+		{"serverSideEncryption": "AES256", "original.sseKmsKeyId": "arn:aws:kms:us-east-1:111122223333:key/abc"},
+	}
+	for _, fields := range invalid {
+		if err := writerbackends.ValidateAccessInfo("s3", bundle(fields)); err == nil {
+			t.Errorf("Expected %v to be rejected", fields)
+		}
+	}
+
+	_, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{Formats: map[string]models.FormatSpec{
+			"jpg": {Settings: models.FormatSettings{Quality: 80, S3: &models.S3Options{StorageClass: "COLD_STORAGE"}}, Sizes: [][]int{{8}}},
+		}},
+	})
+	if err == nil {
+		t.Error("Expected invalid per-format s3 settings to be rejected")
+	}
+	_, err = job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{KeepOriginal: true, OriginalS3: &models.S3Options{ACL: "everyone"}},
+	})
+	if err == nil {
+		t.Error("Expected invalid originalS3 settings to be rejected")
+	}
+}
+
+func TestS3StorageOptions(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	defer credentials.CloseDB()
+	if err := credentials.OpenDB("test_s3_options_credentials.db"); err != nil {
+		t.Fatalf("Failed to initialize credentials store: %v", err)
+	}
+	defer success.Close()
+	if err := success.Init("test_s3_options_success.db"); err != nil {
+		t.Fatalf("Failed to initialize success store: %v", err)
+	}
+
+	bundle := map[string]string{
+		"accessKey":             "minio",
+		"secretKey":             "minio-secret",
+		"region":                "us-east-1",
+		"bucket":                "images",
+		"endpoint":              server.URL,
+		"usePathStyle":          "true",
+		"pathTemplate":          "{format}/{filename}",
+		"serverSideEncryption":  "aws:kms",
+		"sseKmsKeyId":           "compliance-key",
+		"tags":                  "team=web",
+		"original.storageClass": "STANDARD_IA",
+		"original.tags":         "tier=cold",
+	}
+	if err := credentials.RegisterCredentials("s3-options", credentials.Metadata{Owner: "user-1", Type: "s3"}, bundle); err != nil {
+		t.Fatalf("Failed to register credentials: %v", err)
+	}
+
+	combined, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{
+			KeepOriginal: true,
+			StorageKeys:  map[string]string{"s3": "s3-options"},
+			Formats: map[string]models.FormatSpec{
+				"jpg": {
+					Settings: models.FormatSettings{Quality: 80, S3: &models.S3Options{ACL: "public-read", Tags: map[string]string{"variant": "true"}}},
+					Sizes:    [][]int{{8}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse job: %v", err)
+	}
+
+	jobDir := t.TempDir()
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	src.SetNRGBA(0, 0, color.NRGBA{R: 255, A: 255})
+	f, err := os.Create(filepath.Join(jobDir, "photo.png"))
+	if err != nil {
+		t.Fatalf("Failed to create source file: %v", err)
+	}
+	png.Encode(f, src)
+	f.Close()
+
+	instr := job.JobInstructions{FilePath: jobDir, OriginalFile: "photo.png", Hash: "s3opthash_user", Job: combined}
+	if err := job.WriteInstructions(jobDir, instr); err != nil {
+		t.Fatalf("Failed to write instructions: %v", err)
+	}
+	if err := job.ProcessJob(context.Background(), jobDir); err != nil {
+		t.Fatalf("Failed to process job: %v", err)
+	}
+
+	puts := map[string]*http.Request{}
+	for _, r := range fake.requests {
+		if r.Method == http.MethodPut {
+			puts[strings.Split(strings.TrimPrefix(r.URL.Path, "/images/"), "/")[0]] = r
+		}
+	}
+	if len(puts) != 2 {
+		t.Fatalf("Expected uploads of the original and the jpg output, got %v", fake.objects)
+	}
+
+	want := map[string]map[string]string{
+		"original": {
+			"X-Amz-Server-Side-Encryption":                "aws:kms",
+			"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "compliance-key",
+			"X-Amz-Storage-Class":                         "STANDARD_IA",
+			"X-Amz-Acl":                                   "",
+			"X-Amz-Tagging":                               "team=web&tier=cold",
+		},
+		"jpg": {
+			"X-Amz-Server-Side-Encryption":                "aws:kms",
+			"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id": "compliance-key",
+			"X-Amz-Storage-Class":                         "",
+			"X-Amz-Acl":                                   "public-read",
+			"X-Amz-Tagging":                               "team=web&variant=true",
+		},
+	}
+	for format, headers := range want {
+		put, ok := puts[format]
+		if !ok {
+			t.Errorf("Expected an upload below %s/", format)
+			continue
+		}
+		for header, value := range headers {
+			if got := put.Header.Get(header); got != value {
+				t.Errorf("Expected %s %q for %s output, got %q", header, value, format, got)
+			}
+		}
+	}
+}
+
+func TestS3CustomerKeyEncryption(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	rawKey := []byte(strings.Repeat("c", 32))
+	customerKey := base64.StdEncoding.EncodeToString(rawKey)
+	keySum := md5.Sum(rawKey)
+	accessInfo := map[string]string{
+		"accessKey":      "minio",
+		"secretKey":      "minio-secret",
+		"region":         "us-east-1",
+		"bucket":         "images",
+		"objectPath":     "sse-c/a.jpg",
+		"endpoint":       server.URL,
+		"usePathStyle":   "true",
+		"sseCustomerKey": customerKey,
+	}
+
+	ctx := context.Background()
+	if _, err := writerbackends.WriteImage(ctx, accessInfo, strings.NewReader("secret-image"), "s3"); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	writer, _ := writerbackends.Get("s3")
+	if _, err := writer.Stat(ctx, accessInfo); err != nil {
+		t.Fatalf("Failed to stat: %v", err)
+	}
+
+	// The upload, its verification and Stat all need the customer key
+	var methods []string
+	for _, r := range fake.requests {
+		methods = append(methods, r.Method)
+		if r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "AES256" ||
+			r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key") != customerKey ||
+			r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5") != base64.StdEncoding.EncodeToString(keySum[:]) {
+			t.Errorf("Expected SSE-C headers on %s %s", r.Method, r.URL.Path)
+		}
+	}
+	if strings.Join(methods, ",") != "PUT,HEAD,HEAD" {
+		t.Errorf("Expected upload, verification and stat requests, got %v", methods)
+	}
+}
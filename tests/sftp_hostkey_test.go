@@ -0,0 +1,241 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"pixerve/credentials"
+	writerbackends "pixerve/writerBackends"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// fakeSFTP is an SFTP server with password authentication whose host key can be swapped
+type fakeSFTP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	hostKey  ssh.Signer
+
+	connections atomic.Int32 // accepted so far
+	open        atomic.Int32 // currently open
+}
+
+func newHostKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+func startFakeSFTP(t *testing.T, root string) *fakeSFTP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &fakeSFTP{listener: listener, hostKey: newHostKey(t)}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.connections.Add(1)
+			server.open.Add(1)
+			go func() {
+				defer server.open.Add(-1)
+				server.serve(conn, root)
+			}()
+		}
+	}()
+	return server
+}
+
+func (f *fakeSFTP) setHostKey(signer ssh.Signer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hostKey = signer
+}
+
+func (f *fakeSFTP) serve(conn net.Conn, root string) {
+	defer conn.Close()
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() == "pixerve" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	f.mu.Lock()
+	config.AddHostKey(f.hostKey)
+	f.mu.Unlock()
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+		server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(root))
+		if err != nil {
+			return
+		}
+		server.Serve()
+		server.Close()
+	}
+}
+
+func TestSFTPHostKeyVerification(t *testing.T) {
+	root := t.TempDir()
+	server := startFakeSFTP(t, root)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	address := server.listener.Addr().String()
+	originalKey := server.hostKey.PublicKey()
+
+	bundle := func(fields map[string]string) map[string]string {
+		accessInfo := map[string]string{
+			"host":       host,
+			"port":       port,
+			"user":       "pixerve",
+			"password":   "secret",
+			"remoteDir":  root,
+			"objectPath": "tenant/a.jpg",
+		}
+		for k, v := range fields {
+			accessInfo[k] = v
+		}
+		return accessInfo
+	}
+
+	ctx := context.Background()
+	writer, _ := writerbackends.Get("sftp")
+
+	// Host key verification cannot be left out
+	if err := writerbackends.ValidateAccessInfo("sftp", bundle(nil)); err == nil {
+		t.Error("Expected a bundle without host key verification to be rejected")
+	}
+	if err := writerbackends.ValidateAccessInfo("sftp", bundle(map[string]string{"hostKeyFingerprint": "MD5:aa:bb"})); err == nil {
+		t.Error("Expected a non-SHA256 fingerprint to be rejected")
+	}
+
+	// Pinned fingerprint
+	pinned := bundle(map[string]string{"hostKeyFingerprint": ssh.FingerprintSHA256(originalKey)})
+	if err := writerbackends.ValidateAccessInfo("sftp", pinned); err != nil {
+		t.Fatalf("Expected pinned bundle to be valid, got %v", err)
+	}
+	if _, err := writerbackends.WriteImage(ctx, pinned, strings.NewReader("sftp-data"), "sftp"); err != nil {
+		t.Fatalf("Failed to upload with pinned fingerprint: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "tenant", "a.jpg")); err != nil || string(data) != "sftp-data" {
+		t.Errorf("Expected uploaded file, got %q, %v", data, err)
+	}
+
+	// Bundles from before remoteDir keep writing to the single file named by remotePath
+	legacy := bundle(map[string]string{"hostKeyFingerprint": ssh.FingerprintSHA256(originalKey), "remotePath": root + "/legacy.jpg"})
+	delete(legacy, "remoteDir")
+	if err := writerbackends.ValidateAccessInfo("sftp", legacy); err != nil {
+		t.Fatalf("Expected legacy bundle to be valid, got %v", err)
+	}
+	if _, err := writerbackends.WriteImage(ctx, legacy, strings.NewReader("legacy-data"), "sftp"); err != nil {
+		t.Fatalf("Failed to upload with legacy remotePath: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "legacy.jpg")); err != nil || string(data) != "legacy-data" {
+		t.Errorf("Expected the file at remotePath, got %q, %v", data, err)
+	}
+	both := bundle(map[string]string{"hostKeyFingerprint": ssh.FingerprintSHA256(originalKey), "remotePath": root + "/legacy.jpg"})
+	if err := writerbackends.ValidateAccessInfo("sftp", both); err == nil {
+		t.Error("Expected a bundle with both remoteDir and remotePath to be rejected")
+	}
+	wrongPin := bundle(map[string]string{"hostKeyFingerprint": ssh.FingerprintSHA256(newHostKey(t).PublicKey())})
+	if err := writer.HealthCheck(ctx, wrongPin); !errors.Is(err, writerbackends.ErrHostKeyMismatch) {
+		t.Errorf("Expected ErrHostKeyMismatch for a wrong fingerprint, got %v", err)
+	}
+
+	// known_hosts entries
+	knownHostsBundle := bundle(map[string]string{"knownHosts": knownhosts.Line([]string{address}, originalKey) + "\n"})
+	if err := writer.HealthCheck(ctx, knownHostsBundle); err != nil {
+		t.Errorf("Expected known host to be accepted, got %v", err)
+	}
+	otherKey := bundle(map[string]string{"knownHosts": knownhosts.Line([]string{address}, newHostKey(t).PublicKey())})
+	if err := writer.HealthCheck(ctx, otherKey); !errors.Is(err, writerbackends.ErrHostKeyMismatch) {
+		t.Errorf("Expected ErrHostKeyMismatch for a different known key, got %v", err)
+	}
+	otherHost := bundle(map[string]string{"knownHosts": knownhosts.Line([]string{"sftp.example.com"}, originalKey)})
+	if err := writer.HealthCheck(ctx, otherHost); !errors.Is(err, writerbackends.ErrUnknownHostKey) {
+		t.Errorf("Expected ErrUnknownHostKey without an entry for the host, got %v", err)
+	}
+
+	// Trust on first use records the key in the credentials store
+	defer credentials.CloseDB()
+	if err := credentials.OpenDB("test_sftp_hostkey_credentials.db"); err != nil {
+		t.Fatalf("Failed to initialize credentials store: %v", err)
+	}
+	tofuBundle := bundle(map[string]string{"trustOnFirstUse": "true"})
+	if err := credentials.RegisterCredentials("sftp-tofu", credentials.Metadata{Owner: "user-1", Type: "sftp"}, tofuBundle); err != nil {
+		t.Fatalf("Failed to register credentials: %v", err)
+	}
+	resolve := func() map[string]string {
+		stored, err := credentials.GetCredentials("sftp-tofu")
+		if err != nil {
+			t.Fatalf("Failed to load credentials: %v", err)
+		}
+		stored["key"] = "sftp-tofu"
+		return stored
+	}
+
+	if err := writer.HealthCheck(ctx, resolve()); err != nil {
+		t.Fatalf("Expected first connection to be trusted, got %v", err)
+	}
+	recorded := resolve()
+	if !strings.Contains(recorded["knownHosts"], knownhosts.Line([]string{address}, originalKey)) {
+		t.Errorf("Expected the host key to be recorded, got %q", recorded["knownHosts"])
+	}
+	if meta, err := credentials.GetMetadata("sftp-tofu"); err != nil || !strings.Contains(strings.Join(meta.Fields, ","), "knownHosts") {
+		t.Errorf("Expected metadata fields to include knownHosts, got %+v, %v", meta, err)
+	}
+	if err := writer.HealthCheck(ctx, recorded); err != nil {
+		t.Errorf("Expected recorded key to be accepted, got %v", err)
+	}
+
+	server.setHostKey(newHostKey(t))
+	if err := writer.HealthCheck(ctx, resolve()); !errors.Is(err, writerbackends.ErrHostKeyMismatch) {
+		t.Errorf("Expected ErrHostKeyMismatch after the host key changed, got %v", err)
+	}
+	if after := resolve(); after["knownHosts"] != recorded["knownHosts"] {
+		t.Errorf("Expected recorded key to be kept after a mismatch, got %q", after["knownHosts"])
+	}
+}
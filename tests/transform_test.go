@@ -0,0 +1,242 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/encoder"
+	"pixerve/job"
+	"pixerve/models"
+	"pixerve/routes"
+	"pixerve/success"
+	"pixerve/utils"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransformURLSignature(t *testing.T) {
+	key := []byte("transform-key")
+	url := utils.TransformURL(key, 300, 200, "webp", "tenant a/photo.jpg")
+	if !strings.HasSuffix(url, "/300x200/webp/tenant%20a/photo.jpg") {
+		t.Fatalf("Unexpected transform URL: %s", url)
+	}
+
+	signature, transformPath, _ := strings.Cut(strings.TrimPrefix(url, "/img/"), "/")
+	if !utils.VerifyTransformSignature(key, signature, "/"+transformPath) {
+		t.Error("Expected signature to verify")
+	}
+	if utils.VerifyTransformSignature(key, signature, "/3000x2000/webp/tenant%20a/photo.jpg") {
+		t.Error("Expected signature of a different size to be rejected")
+	}
+	if utils.VerifyTransformSignature([]byte("other-key"), signature, "/"+transformPath) {
+		t.Error("Expected signature with a different key to be rejected")
+	}
+}
+
+func TestTransformHandler(t *testing.T) {
+	serveDir := t.TempDir()
+	cacheDir := t.TempDir()
+	t.Setenv("PIXERVE_SERVE_DIR", serveDir)
+	t.Setenv("PIXERVE_TRANSFORM_CACHE_DIR", cacheDir)
+
+	// A 40x20 source in a tenant folder
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for x := 0; x < 40; x++ {
+		for y := 0; y < 20; y++ {
+			src.Set(x, y, color.RGBA{R: uint8(x * 6), G: 100, B: 200, A: 255})
+		}
+	}
+	os.MkdirAll(filepath.Join(serveDir, "tenant"), 0755)
+	f, err := os.Create(filepath.Join(serveDir, "tenant", "pic.png"))
+	if err != nil {
+		t.Fatalf("Failed to create source: %v", err)
+	}
+	png.Encode(f, src)
+	f.Close()
+
+	key := []byte("transform-test-key")
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		routes.TransformHandler(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	// Disabled without a key
+	if rec := get(utils.TransformURL(key, 10, 0, "png", "tenant/pic.png")); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without a transform key, got %d", rec.Code)
+	}
+
+	t.Setenv("PIXERVE_TRANSFORM_KEY", string(key))
+
+	// Valid signature: resized to fit the width, keeping the aspect ratio
+	url := utils.TransformURL(key, 10, 0, "png", "tenant/pic.png")
+	for i := 0; i < 2; i++ {
+		rec := get(url)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		img, err := png.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("Failed to decode variant: %v", err)
+		}
+		if size := img.Bounds().Size(); size != image.Pt(10, 5) {
+			t.Errorf("Expected 10x5 variant, got %v", size)
+		}
+	}
+
+	// The variant was encoded once and cached
+	var cached []string
+	filepath.Walk(cacheDir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			cached = append(cached, p)
+		}
+		return nil
+	})
+	if len(cached) != 1 {
+		t.Errorf("Expected one cached variant, got %v", cached)
+	}
+
+	// Changing the size without re-signing is rejected
+	tampered := strings.Replace(url, "/10x0/", "/4000x4000/", 1)
+	if rec := get(tampered); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for tampered URL, got %d", rec.Code)
+	}
+
+	// Paths cannot escape the serve directory, even when signed
+	if rec := get(utils.TransformURL(key, 10, 0, "png", "../../etc/passwd")); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for path outside the serve directory, got %d", rec.Code)
+	}
+
+	if rec := get(utils.TransformURL(key, 10, 0, "copy", "tenant/pic.png")); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unsupported format, got %d", rec.Code)
+	}
+	if rec := get(utils.TransformURL(key, 10, 0, "png", "tenant/missing.png")); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for missing source, got %d", rec.Code)
+	}
+}
+
+// TestTransformWhileJobsProcess serves transforms while jobs convert and encoders are registered
+// again; run with -race to check the encoder registries are safe for concurrent use
+func TestTransformWhileJobsProcess(t *testing.T) {
+	defer success.Close()
+	if err := success.Init("test_transform_jobs_success.db"); err != nil {
+		t.Fatalf("Failed to initialize success store: %v", err)
+	}
+	serveDir := t.TempDir()
+	t.Setenv("PIXERVE_SERVE_DIR", serveDir)
+	t.Setenv("PIXERVE_TRANSFORM_CACHE_DIR", t.TempDir())
+	key := []byte("transform-race-key")
+	t.Setenv("PIXERVE_TRANSFORM_KEY", string(key))
+	encoder.RegisterDefaults()
+
+	writePNG := func(path string) {
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatalf("Failed to create source: %v", err)
+		}
+		defer f.Close()
+		png.Encode(f, image.NewRGBA(image.Rect(0, 0, 16, 16)))
+	}
+	writePNG(filepath.Join(serveDir, "src.png"))
+
+	combined, err := job.ParseTokenIntoJobsFromClaims(&models.PixerveJWT{
+		Job: models.JobSpec{
+			DirectHost: true,
+			SubDir:     "race",
+			Formats:    map[string]models.FormatSpec{"png": {Sizes: [][]int{{8}}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse job: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		jobDir := t.TempDir()
+		writePNG(filepath.Join(jobDir, "photo.png"))
+		instr := job.JobInstructions{FilePath: jobDir, OriginalFile: "photo.png", Hash: fmt.Sprintf("racehash%d_user", i), Job: combined}
+		if err := job.WriteInstructions(jobDir, instr); err != nil {
+			t.Fatalf("Failed to write instructions: %v", err)
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if err := job.ProcessJob(context.Background(), jobDir); err != nil {
+				t.Errorf("Job failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			encoder.RegisterDefaults()
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		url := utils.TransformURL(key, 4+i, 0, "png", "src.png")
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			routes.TransformHandler(rec, httptest.NewRequest(http.MethodGet, url, nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("Expected 200 for %s, got %d: %s", url, rec.Code, rec.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestTransformOutlivesClient checks that a client going away mid-encode does not abort the
+// variant, which other requests may be waiting for
+func TestTransformOutlivesClient(t *testing.T) {
+	serveDir := t.TempDir()
+	t.Setenv("PIXERVE_SERVE_DIR", serveDir)
+	t.Setenv("PIXERVE_TRANSFORM_CACHE_DIR", t.TempDir())
+	key := []byte("transform-disconnect-key")
+	t.Setenv("PIXERVE_TRANSFORM_KEY", string(key))
+	os.WriteFile(filepath.Join(serveDir, "src.bin"), []byte("source"), 0644)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	encoder.Register("slowtest", "true", func(ctx context.Context, input, output string, opts encoder.EncodeOptions) error {
+		close(started)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return os.WriteFile(output, []byte("variant"), 0644)
+	})
+	t.Cleanup(func() { delete(encoder.Registry, "slowtest") })
+
+	url := utils.TransformURL(key, 4, 0, "slowtest", "src.bin")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rec := httptest.NewRecorder()
+		routes.TransformHandler(rec, httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx))
+		done <- rec
+	}()
+
+	<-started
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if rec := <-done; rec.Code != http.StatusOK || rec.Body.String() != "variant" {
+		t.Fatalf("Expected the variant despite the disconnect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The next request is served from the cache
+	rec := httptest.NewRecorder()
+	routes.TransformHandler(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "variant" {
+		t.Errorf("Expected the cached variant, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
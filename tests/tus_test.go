@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/job"
+	"pixerve/routes"
+	"pixerve/taskQueue"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestTusResumableUpload(t *testing.T) {
+	openTestQueue(t, "test_tus_queue.db")
+
+	secret := []byte("tus-test-secret-key-at-least-32-bytes-long")
+	t.Setenv("PIXERVE_JWT_SECRET", string(secret))
+	tokenFor := func(subject string) string {
+		return signTestJWT(t, secret, jose.HS256, "", map[string]any{
+			"sub": subject,
+			"iat": time.Now().Unix(),
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"job": map[string]any{"directHost": true},
+		})
+	}
+	token := tokenFor("mobile-client")
+
+	tusRequest := func(method, path, token string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		routes.TusHandler(rec, req)
+		return rec
+	}
+
+	content := bytes.Repeat([]byte("chunked"), 300)
+
+	// Create the upload
+	rec := tusRequest(http.MethodPost, "/uploads/", token, nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(content)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("photo.jpg")),
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	t.Cleanup(func() { routes.CleanupStaleUploads(0) })
+
+	patch := func(token string, offset int, chunk []byte) *httptest.ResponseRecorder {
+		return tusRequest(http.MethodPatch, location, token, chunk, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		})
+	}
+
+	// First chunk
+	half := len(content) / 2
+	if rec := patch(token, 0, content[:half]); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("Unexpected first chunk response: %d, offset %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// Resume: the server reports how much it has
+	rec = tusRequest(http.MethodHead, location, token, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(half) {
+		t.Fatalf("Unexpected HEAD response: %d, offset %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// Chunks at the wrong offset or from another subject are rejected
+	if rec := patch(token, 0, content[:half]); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for offset mismatch, got %d", rec.Code)
+	}
+	if rec := patch(tokenFor("someone-else"), half, content[half:]); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for another subject, got %d", rec.Code)
+	}
+
+	// A final chunk that cannot be queued keeps the upload, here because the same file is
+	// still being processed for this subject
+	sum := sha256.Sum256(content)
+	busyDir := filepath.Join(os.TempDir(), hex.EncodeToString(sum[:])+"_mobile-client")
+	os.MkdirAll(busyDir, 0755)
+	defer os.RemoveAll(busyDir)
+	if rec := patch(token, half, content[half:]); rec.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 while the file is being processed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := tusRequest(http.MethodHead, location, token, nil, nil); rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != strconv.Itoa(len(content)) {
+		t.Fatalf("Expected the finished upload to be kept, got %d, offset %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	os.RemoveAll(busyDir)
+
+	// Retrying with an empty chunk at the final offset queues the job
+	rec = patch(token, len(content), nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 for the retried completion, got %d: %s", rec.Code, rec.Body.String())
+	}
+	hash := rec.Header().Get("Pixerve-Job-Hash")
+	if hash == "" {
+		t.Fatal("Expected job hash header on completion")
+	}
+	jobDir := filepath.Join(os.TempDir(), hash)
+	t.Cleanup(func() { os.RemoveAll(jobDir) })
+
+	stored, err := os.ReadFile(filepath.Join(jobDir, "photo.jpg"))
+	if err != nil || !bytes.Equal(stored, content) {
+		t.Fatalf("Stored upload does not match the uploaded content (%v)", err)
+	}
+	if state, exists := job.GetJobState(hash); !exists || state != job.JobStatePending {
+		t.Errorf("Expected pending job, got %v (exists=%v)", state, exists)
+	}
+
+	// The finished upload is gone
+	if rec := tusRequest(http.MethodHead, location, token, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after completion, got %d", rec.Code)
+	}
+
+	// Unauthenticated chunks are rejected before any per-upload state is created
+	if rec := tusRequest(http.MethodPatch, "/uploads/0123456789abcdef0123456789abcdef", "invalid", []byte("x"), map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unauthenticated chunk, got %d", rec.Code)
+	}
+}
+
+func TestTusCompletionRetriesAfterQueueFailure(t *testing.T) {
+	openTestQueue(t, "test_tus_retry_queue.db")
+
+	secret := []byte("tus-test-secret-key-at-least-32-bytes-long")
+	t.Setenv("PIXERVE_JWT_SECRET", string(secret))
+	token := signTestJWT(t, secret, jose.HS256, "", map[string]any{
+		"sub": "retry-client",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"job": map[string]any{"directHost": true},
+	})
+
+	tusRequest := func(method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Authorization", "Bearer "+token)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		routes.TusHandler(rec, req)
+		return rec
+	}
+
+	content := bytes.Repeat([]byte("queue-failure"), 100)
+	rec := tusRequest(http.MethodPost, "/uploads/", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(len(content)),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte("retry.jpg")),
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	t.Cleanup(func() { routes.CleanupStaleUploads(0) })
+
+	patch := func(offset int, chunk []byte) *httptest.ResponseRecorder {
+		return tusRequest(http.MethodPatch, location, chunk, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		})
+	}
+
+	// The job directory is created, but the queue is unavailable
+	sum := sha256.Sum256(content)
+	jobDir := filepath.Join(os.TempDir(), hex.EncodeToString(sum[:])+"_retry-client")
+	t.Cleanup(func() { os.RemoveAll(jobDir) })
+	taskQueue.CloseConvertQueueDB()
+	if rec := patch(0, content); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500 while the queue is unavailable, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := os.Stat(jobDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected the job directory of the unqueued upload to be removed, got %v", err)
+	}
+
+	// Once the queue is back, retrying the completion queues the job
+	if err := taskQueue.OpenConvertQueueDB("test_tus_retry_queue.db"); err != nil {
+		t.Fatalf("Failed to reopen queue: %v", err)
+	}
+	rec = patch(len(content), nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 for the retried completion, got %d: %s", rec.Code, rec.Body.String())
+	}
+	stored, err := os.ReadFile(filepath.Join(jobDir, "retry.jpg"))
+	if err != nil || !bytes.Equal(stored, content) {
+		t.Fatalf("Stored upload does not match the uploaded content (%v)", err)
+	}
+	if state, exists := job.GetJobState(rec.Header().Get("Pixerve-Job-Hash")); !exists || state != job.JobStatePending {
+		t.Errorf("Expected pending job, got %v (exists=%v)", state, exists)
+	}
+}
@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"pixerve/config"
+	"pixerve/routes"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"1024", 1024, false},
+		{"10KB", 10 << 10, false},
+		{"250mb", 250 << 20, false},
+		{"2 GB", 2 << 30, false},
+		{"abc", 0, true},
+		{"-5MB", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := config.ParseByteSize(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, %v; want %d (error %v)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMaxUploadSizePerSubject(t *testing.T) {
+	t.Setenv("PIXERVE_MAX_UPLOAD_SIZE", "50MB")
+	t.Setenv("PIXERVE_SUBJECT_UPLOAD_LIMITS", "tenant-a=500MB, tenant-b=1KB")
+
+	if got := config.GetMaxUploadSizeFor("tenant-a"); got != 500<<20 {
+		t.Errorf("Expected 500MB for tenant-a, got %d", got)
+	}
+	if got := config.GetMaxUploadSizeFor("tenant-b"); got != 1<<10 {
+		t.Errorf("Expected 1KB for tenant-b, got %d", got)
+	}
+	if got := config.GetMaxUploadSizeFor("other"); got != 50<<20 {
+		t.Errorf("Expected default 50MB, got %d", got)
+	}
+}
+
+// newUploadRequest builds a multipart upload request carrying content as the file part
+func newUploadRequest(t *testing.T, token, filename string, content []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Failed to create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestUploadStreamsWithinSubjectLimit(t *testing.T) {
+	openTestQueue(t, "test_upload_queue.db")
+
+	secret := []byte("upload-test-secret-key-at-least-32-bytes")
+	t.Setenv("PIXERVE_JWT_SECRET", string(secret))
+	t.Setenv("PIXERVE_SUBJECT_UPLOAD_LIMITS", "limited-tenant=1KB")
+
+	token := signTestJWT(t, secret, jose.HS256, "", map[string]any{
+		"sub": "limited-tenant",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"job": map[string]any{"directHost": true},
+	})
+
+	// Over the subject's limit: rejected while streaming
+	rec := httptest.NewRecorder()
+	routes.UploadHandler(rec, newUploadRequest(t, token, "big.jpg", bytes.Repeat([]byte("x"), 2048)))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for oversized upload, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Within the limit: stored under the job directory named after the hash
+	content := bytes.Repeat([]byte("y"), 512)
+	rec = httptest.NewRecorder()
+	routes.UploadHandler(rec, newUploadRequest(t, token, "small.jpg", content))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	jobDir := filepath.Join(os.TempDir(), response.Hash)
+	t.Cleanup(func() { os.RemoveAll(jobDir) })
+
+	stored, err := os.ReadFile(filepath.Join(jobDir, "small.jpg"))
+	if err != nil {
+		t.Fatalf("Failed to read stored upload: %v", err)
+	}
+	if !bytes.Equal(stored, content) {
+		t.Error("Stored upload does not match the uploaded content")
+	}
+
+	// No staging directories are left behind
+	if matches, _ := filepath.Glob(filepath.Join(os.TempDir(), ".pixerve-upload-*")); len(matches) != 0 {
+		t.Errorf("Expected staging directories to be cleaned up, found %v", matches)
+	}
+}
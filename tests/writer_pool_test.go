@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"pixerve/config"
+	writerbackends "pixerve/writerBackends"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSFTPConnectionPooling(t *testing.T) {
+	root := t.TempDir()
+	server := startFakeSFTP(t, root)
+	host, port, _ := net.SplitHostPort(server.listener.Addr().String())
+
+	accessInfo := func(objectPath string) map[string]string {
+		return map[string]string{
+			"key":                "pool-sftp-key",
+			"host":               host,
+			"port":               port,
+			"user":               "pixerve",
+			"password":           "secret",
+			"remoteDir":          root,
+			"objectPath":         objectPath,
+			"hostKeyFingerprint": ssh.FingerprintSHA256(server.hostKey.PublicKey()),
+		}
+	}
+
+	// The variants of a job share one connection
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 12)
+	for i := range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := writerbackends.WriteImage(ctx, accessInfo(fmt.Sprintf("job/%d.jpg", i)), strings.NewReader("data"), "sftp")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Failed to upload: %v", err)
+		}
+	}
+	if n := server.connections.Load(); n != 1 {
+		t.Errorf("Expected 12 uploads to share one connection, got %d connections", n)
+	}
+
+	// Deregistering the bundle closes its connection
+	writerbackends.InvalidateClients("pool-sftp-key")
+	deadline := time.Now().Add(5 * time.Second)
+	for server.open.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := server.open.Load(); n != 0 {
+		t.Errorf("Expected the pooled connection to be closed, %d still open", n)
+	}
+	if err := writerbackends.DeleteImage(ctx, accessInfo("job/0.jpg"), "sftp"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if n := server.connections.Load(); n != 2 {
+		t.Errorf("Expected a new connection after invalidation, got %d connections", n)
+	}
+	writerbackends.InvalidateClients("pool-sftp-key")
+}
+
+// blockingWriter records how many writes run at once
+type blockingWriter struct {
+	memoryWriter
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (b *blockingWriter) Write(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	n := b.running.Add(1)
+	defer b.running.Add(-1)
+	for {
+		peak := b.peak.Load()
+		if n <= peak || b.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func TestWriterConcurrencyLimit(t *testing.T) {
+	t.Setenv("PIXERVE_WRITER_CONCURRENCY", "4,throttled=2")
+	if got := config.GetWriterConcurrency("throttled"); got != 2 {
+		t.Errorf("Expected override of 2, got %d", got)
+	}
+	if got := config.GetWriterConcurrency("s3"); got != 4 {
+		t.Errorf("Expected default of 4, got %d", got)
+	}
+
+	writer := &blockingWriter{}
+	writerbackends.Register("throttled", writer)
+	t.Cleanup(func() { delete(writerbackends.Registry, "throttled") })
+
+	var wg sync.WaitGroup
+	for range 6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			writerbackends.WriteImage(context.Background(), map[string]string{}, strings.NewReader("data"), "throttled")
+		}()
+	}
+	wg.Wait()
+	if peak := writer.peak.Load(); peak != 2 {
+		t.Errorf("Expected at most 2 concurrent writes, got %d", peak)
+	}
+
+	// Waiting for a slot respects cancellation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := writerbackends.WriteImage(ctx, map[string]string{}, strings.NewReader("data"), "throttled"); err == nil {
+		t.Error("Expected a cancelled write to fail")
+	}
+}
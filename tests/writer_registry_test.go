@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pixerve/routes"
+	writerbackends "pixerve/writerBackends"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryWriter is an in-memory backend keyed by accessInfo["filename"]
+type memoryWriter struct {
+	objects map[string][]byte
+}
+
+func (m *memoryWriter) Write(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	m.objects[accessInfo["filename"]] = data
+	return nil
+}
+
+func (m *memoryWriter) Delete(ctx context.Context, accessInfo map[string]string) error {
+	delete(m.objects, accessInfo["filename"])
+	return nil
+}
+
+func (m *memoryWriter) Stat(ctx context.Context, accessInfo map[string]string) (writerbackends.ObjectInfo, error) {
+	data, ok := m.objects[accessInfo["filename"]]
+	if !ok {
+		return writerbackends.ObjectInfo{}, writerbackends.ErrObjectNotFound
+	}
+	return writerbackends.ObjectInfo{Size: int64(len(data)), ModTime: time.Now()}, nil
+}
+
+func (m *memoryWriter) HealthCheck(ctx context.Context, accessInfo map[string]string) error {
+	return nil
+}
+
+func (m *memoryWriter) RequiredFields() []string {
+	return []string{"bucket", "token|password"}
+}
+
+func TestWriterRegistry(t *testing.T) {
+	memory := &memoryWriter{objects: map[string][]byte{}}
+	writerbackends.Register("memory", memory)
+	t.Cleanup(func() { delete(writerbackends.Registry, "memory") })
+
+	for _, backendType := range []string{"directServe", "gcs", "memory", "s3", "sftp"} {
+		if !slices.Contains(writerbackends.Types(), backendType) {
+			t.Errorf("Expected %s to be registered, got %v", backendType, writerbackends.Types())
+		}
+	}
+
+	// WriteImage and DeleteImage dispatch to the registered writer
+	ctx := context.Background()
+	accessInfo := map[string]string{"filename": "a.jpg"}
+	if _, err := writerbackends.WriteImage(ctx, accessInfo, strings.NewReader("data"), "memory"); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if info, err := memory.Stat(ctx, accessInfo); err != nil || info.Size != 4 {
+		t.Errorf("Expected a 4 byte object, got %+v, %v", info, err)
+	}
+	if err := writerbackends.DeleteImage(ctx, accessInfo, "memory"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := memory.Stat(ctx, accessInfo); !errors.Is(err, writerbackends.ErrObjectNotFound) {
+		t.Errorf("Expected ErrObjectNotFound after delete, got %v", err)
+	}
+	if _, err := writerbackends.WriteImage(ctx, accessInfo, strings.NewReader("data"), "nowhere"); err == nil {
+		t.Error("Expected unknown backend type to fail")
+	}
+
+	// Required fields, including alternatives
+	if err := writerbackends.ValidateAccessInfo("memory", map[string]string{"bucket": "b", "password": "p"}); err != nil {
+		t.Errorf("Expected complete bundle to validate, got %v", err)
+	}
+	err := writerbackends.ValidateAccessInfo("memory", map[string]string{})
+	if err == nil || !strings.Contains(err.Error(), "bucket, token or password") {
+		t.Errorf("Expected both missing fields to be named, got %v", err)
+	}
+
+	// StorageKeys types
+	if err := writerbackends.ValidateStorageType("memory"); err != nil {
+		t.Errorf("Expected registered type to be accepted, got %v", err)
+	}
+	if err := writerbackends.ValidateStorageType("directServe"); err == nil {
+		t.Error("Expected directServe to be rejected as a storage key type")
+	}
+	if err := writerbackends.ValidateStorageType("azure"); err == nil {
+		t.Error("Expected unregistered type to be rejected")
+	}
+
+	rec := httptest.NewRecorder()
+	routes.BackendsHandler(rec, httptest.NewRequest(http.MethodGet, "/backends", nil))
+	var backends []routes.BackendInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &backends); err != nil {
+		t.Fatalf("Failed to decode backends: %v", err)
+	}
+	found := false
+	for _, backend := range backends {
+		if backend.Type == "memory" {
+			found = slices.Equal(backend.RequiredFields, []string{"bucket", "token|password"})
+		}
+	}
+	if !found {
+		t.Errorf("Expected memory backend with its required fields, got %+v", backends)
+	}
+}
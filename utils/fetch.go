@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"pixerve/config"
+)
+
+var (
+	// ErrInvalidSourceURL is returned for URLs that are malformed or not http(s)
+	ErrInvalidSourceURL = errors.New("invalid source URL")
+	// ErrSourceNotAllowed is returned when a URL's host is not on the ingest allowlist
+	// or resolves to a private, loopback or otherwise internal address
+	ErrSourceNotAllowed = errors.New("source address not allowed")
+	// ErrSourceTooLarge is returned when the origin announces a body larger than the limit
+	ErrSourceTooLarge = errors.New("source exceeds the maximum size")
+	// ErrSourceNotImage is returned when the origin responds with a non-image content type
+	ErrSourceNotImage = errors.New("source is not an image")
+	// ErrSourceStatus is returned when the origin responds with a non-2xx status
+	ErrSourceStatus = errors.New("source responded with an error status")
+)
+
+// maxSourceRedirects bounds how many redirects a source fetch follows
+const maxSourceRedirects = 5
+
+// FetchOptions configures FetchSourceImage
+type FetchOptions struct {
+	MaxSize         int64          // largest body accepted, checked against Content-Length
+	Timeout         time.Duration  // covers connecting, redirects and reading the body
+	AllowedHosts    []string       // hostnames, "*.example.com" for subdomains; empty allows any host
+	AllowedNetworks []netip.Prefix // ranges exempt from the internal address block
+}
+
+// LoadFetchOptions builds FetchOptions from server configuration for the given size limit
+func LoadFetchOptions(maxSize int64) FetchOptions {
+	return FetchOptions{
+		MaxSize:         maxSize,
+		Timeout:         config.GetIngestTimeout(),
+		AllowedHosts:    config.GetIngestAllowedHosts(),
+		AllowedNetworks: config.GetIngestAllowedNetworks(),
+	}
+}
+
+// FetchSourceImage requests an image from an HTTP origin on behalf of a client.
+//
+// SSRF protections:
+// - Only http and https URLs without credentials are accepted
+// - Hosts must match opts.AllowedHosts when it is set, including on every redirect
+// - Connections to private, loopback, link-local and other reserved addresses are refused at dial time,
+// after DNS resolution, so redirects and DNS rebinding cannot reach internal services
+// - Environment proxies are ignored, since they would hide the real destination
+//
+// The response must be 2xx with an image/* content type and a Content-Length (if sent) within
+// opts.MaxSize. The caller must close the body and still enforce opts.MaxSize while reading it,
+// as origins may omit or understate Content-Length.
+func FetchSourceImage(ctx context.Context, rawURL string, opts FetchOptions) (*http.Response, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSourceURL, err)
+	}
+	if err := checkSourceURL(target, opts.AllowedHosts); err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return checkSourceAddress(address, opts.AllowedNetworks)
+		},
+	}
+	client := &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: opts.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxSourceRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxSourceRedirects)
+			}
+			return checkSourceURL(req.URL, opts.AllowedHosts)
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSourceURL, err)
+	}
+	req.Header.Set("User-Agent", "Pixerve/1.0")
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrSourceNotAllowed) || errors.Is(err, ErrInvalidSourceURL) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to fetch source: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d", ErrSourceStatus, resp.StatusCode)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "image/") {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: content type %q", ErrSourceNotImage, resp.Header.Get("Content-Type"))
+	}
+
+	if opts.MaxSize > 0 && resp.ContentLength > opts.MaxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes", ErrSourceTooLarge, resp.ContentLength)
+	}
+
+	return resp, nil
+}
+
+// checkSourceURL validates the scheme and host of a source or redirect URL
+func checkSourceURL(u *url.URL, allowedHosts []string) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q is not supported", ErrInvalidSourceURL, u.Scheme)
+	}
+	if u.User != nil {
+		return fmt.Errorf("%w: credentials in URLs are not supported", ErrInvalidSourceURL)
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidSourceURL)
+	}
+	if len(allowedHosts) > 0 && !hostAllowed(host, allowedHosts) {
+		return fmt.Errorf("%w: host %s is not on the allowlist", ErrSourceNotAllowed, host)
+	}
+	return nil
+}
+
+// hostAllowed matches a hostname against exact and "*.domain" allowlist entries
+func hostAllowed(host string, allowedHosts []string) bool {
+	for _, allowed := range allowedHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedNetworks are reserved ranges not covered by the netip.Addr classification helpers
+var blockedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),    // NAT64, can map to internal IPv4
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+}
+
+// checkSourceAddress refuses connections to internal addresses unless they are explicitly allowed
+func checkSourceAddress(address string, allowedNetworks []netip.Prefix) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSourceNotAllowed, address)
+	}
+	addr := addrPort.Addr().Unmap()
+
+	for _, prefix := range allowedNetworks {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+
+	if IsInternalAddress(addr) {
+		return fmt.Errorf("%w: %s is an internal address", ErrSourceNotAllowed, addr)
+	}
+	return nil
+}
+
+// IsInternalAddress reports whether addr is loopback, private, link-local, multicast or otherwise
+// reserved, i.e. not a destination a client-supplied URL should be able to reach
+func IsInternalAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range blockedNetworks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
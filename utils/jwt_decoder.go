@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"errors"
 	"fmt"
 	"time"
@@ -17,14 +20,80 @@ var (
 	ErrTokenNotYetValid = errors.New("token not yet valid")
 	ErrInvalidSignature = errors.New("invalid token signature")
 	ErrInvalidIssuer    = errors.New("invalid issuer")
+	ErrInvalidAudience  = errors.New("invalid audience")
+	ErrUnknownKeyID     = errors.New("unknown key id")
 )
 
 // VerifyConfig holds verification configuration
 type VerifyConfig struct {
-	SecretKey      []byte        // For HMAC (HS256)
-	PublicKey      any           // For RSA (RS256) - *rsa.PublicKey
-	ExpectedIssuer string        // Optional: validate issuer
-	ClockSkew      time.Duration // Optional: allow clock skew (default 0)
+	SecretKey        []byte              // For HMAC (HS256)
+	PublicKey        any                 // For RSA (RS256) or ECDSA (ES256/ES384/ES512) - *rsa.PublicKey or *ecdsa.PublicKey
+	KeySet           *jose.JSONWebKeySet // Optional: JWKS, key selected by the token's "kid" header
+	ExpectedIssuer   string              // Optional: validate issuer
+	ExpectedAudience string              // Optional: require this value in the "aud" claim
+	ClockSkew        time.Duration       // Optional: allow clock skew (default 0)
+}
+
+// algorithmsForKey returns the signature algorithms a verification key may be used with
+func algorithmsForKey(key any) []jose.SignatureAlgorithm {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return []jose.SignatureAlgorithm{jose.RS256}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return []jose.SignatureAlgorithm{jose.ES256}
+		case elliptic.P384():
+			return []jose.SignatureAlgorithm{jose.ES384}
+		case elliptic.P521():
+			return []jose.SignatureAlgorithm{jose.ES512}
+		}
+	case []byte:
+		return []jose.SignatureAlgorithm{jose.HS256}
+	}
+	return nil
+}
+
+// selectKey picks the verification key matching the token's algorithm and key id
+func selectKey(config VerifyConfig, header jose.Header) (any, error) {
+	alg := jose.SignatureAlgorithm(header.Algorithm)
+
+	if config.KeySet != nil && len(config.KeySet.Keys) > 0 {
+		var candidates []jose.JSONWebKey
+		if header.KeyID != "" {
+			candidates = config.KeySet.Key(header.KeyID)
+		} else if len(config.KeySet.Keys) == 1 {
+			// Tokens without a kid are only accepted when the set is unambiguous
+			candidates = config.KeySet.Keys
+		}
+		for _, jwk := range candidates {
+			if jwk.Algorithm != "" && jwk.Algorithm != string(alg) {
+				continue
+			}
+			for _, keyAlg := range algorithmsForKey(jwk.Key) {
+				if keyAlg == alg {
+					return jwk.Key, nil
+				}
+			}
+		}
+	}
+
+	if config.PublicKey != nil {
+		for _, keyAlg := range algorithmsForKey(config.PublicKey) {
+			if keyAlg == alg {
+				return config.PublicKey, nil
+			}
+		}
+	}
+
+	if config.SecretKey != nil && alg == jose.HS256 {
+		return config.SecretKey, nil
+	}
+
+	if config.KeySet != nil && header.KeyID != "" {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKeyID, header.KeyID)
+	}
+	return nil, fmt.Errorf("no verification key configured for algorithm %s", alg)
 }
 
 // VerifyPixerveJWT safely verifies and decodes a Pixerve JWT
@@ -39,7 +108,12 @@ func VerifyPixerveJWT(tokenString string, config VerifyConfig) (*models.PixerveJ
 		allowedAlgs = append(allowedAlgs, jose.HS256)
 	}
 	if config.PublicKey != nil {
-		allowedAlgs = append(allowedAlgs, jose.RS256)
+		allowedAlgs = append(allowedAlgs, algorithmsForKey(config.PublicKey)...)
+	}
+	if config.KeySet != nil {
+		for _, jwk := range config.KeySet.Keys {
+			allowedAlgs = append(allowedAlgs, algorithmsForKey(jwk.Key)...)
+		}
 	}
 
 	if len(allowedAlgs) == 0 {
@@ -51,20 +125,22 @@ func VerifyPixerveJWT(tokenString string, config VerifyConfig) (*models.PixerveJ
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
+	if len(tok.Headers) != 1 {
+		return nil, fmt.Errorf("%w: expected exactly one signature", ErrInvalidToken)
+	}
+
+	// Pick the key for this token's algorithm and kid
+	key, err := selectKey(config, tok.Headers[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
 
 	// Prepare claims struct
 	claims := &models.PixerveJWT{}
 
 	// Verify signature and extract claims
-	var verifyErr error
-	if config.SecretKey != nil {
-		verifyErr = tok.Claims(config.SecretKey, claims)
-	} else if config.PublicKey != nil {
-		verifyErr = tok.Claims(config.PublicKey, claims)
-	}
-
-	if verifyErr != nil {
-		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, verifyErr)
+	if err := tok.Claims(key, claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
 	}
 
 	// Validate timestamps
@@ -81,12 +157,23 @@ func VerifyPixerveJWT(tokenString string, config VerifyConfig) (*models.PixerveJ
 		return nil, ErrTokenNotYetValid
 	}
 
+	// Check not before
+	if claims.NotBefore > 0 && claims.NotBefore > (now+clockSkew) {
+		return nil, ErrTokenNotYetValid
+	}
+
 	// Validate issuer if specified
 	if config.ExpectedIssuer != "" && claims.Issuer != config.ExpectedIssuer {
 		return nil, fmt.Errorf("%w: expected '%s', got '%s'",
 			ErrInvalidIssuer, config.ExpectedIssuer, claims.Issuer)
 	}
 
+	// Validate audience if specified
+	if config.ExpectedAudience != "" && !claims.Audience.Contains(config.ExpectedAudience) {
+		return nil, fmt.Errorf("%w: expected '%s', got %v",
+			ErrInvalidAudience, config.ExpectedAudience, []string(claims.Audience))
+	}
+
 	return claims, nil
 }
 
@@ -127,10 +214,17 @@ func ExampleUsage() {
 		ClockSkew:      time.Minute * 5,
 	})
 
-	// RSA verification
+	// RSA/ECDSA verification
 	claims, err := VerifyPixerveJWT(token, VerifyConfig{
-		PublicKey:      publicKey, // *rsa.PublicKey
+		PublicKey:      publicKey, // *rsa.PublicKey or *ecdsa.PublicKey
 		ExpectedIssuer: "pixerve-api",
 	})
+
+	// JWKS verification (key chosen by the token's kid header)
+	keySet, err := LoadJWKSFile("/etc/pixerve/jwks.json")
+	claims, err := VerifyPixerveJWT(token, VerifyConfig{
+		KeySet:           keySet,
+		ExpectedAudience: "pixerve",
+	})
 }
 */
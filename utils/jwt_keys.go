@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"pixerve/config"
+	"pixerve/logger"
+
+	"github.com/go-jose/go-jose/v4"
+)
+
+// cachedKeyFile holds a parsed key file together with the file state it was parsed from
+type cachedKeyFile struct {
+	modTime time.Time
+	size    int64
+	value   any
+}
+
+var (
+	keyFileCache = make(map[string]cachedKeyFile) // path -> parsed contents
+	keyFileMu    sync.Mutex
+)
+
+// loadKeyFile returns the parsed contents of path, re-reading and re-parsing the file
+// only when its modification time or size has changed since the last call.
+// If a changed file fails to parse, the previously loaded value is kept and the error logged,
+// so a half-written key file never locks out valid tokens.
+func loadKeyFile(path string, parse func([]byte) (any, error)) (any, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key file %s: %w", path, err)
+	}
+
+	keyFileMu.Lock()
+	defer keyFileMu.Unlock()
+
+	cached, ok := keyFileCache[path]
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.value, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var value any
+		value, err = parse(data)
+		if err == nil {
+			if ok {
+				logger.Infof("Reloaded key file %s", path)
+			}
+			keyFileCache[path] = cachedKeyFile{modTime: info.ModTime(), size: info.Size(), value: value}
+			return value, nil
+		}
+	}
+
+	if ok {
+		logger.Errorf("Failed to reload key file %s, keeping previous keys: %v", path, err)
+		return cached.value, nil
+	}
+	return nil, fmt.Errorf("failed to load key file %s: %w", path, err)
+}
+
+// ParsePublicKeyPEM parses a PEM encoded RSA or ECDSA public key.
+// Accepts PKIX "PUBLIC KEY", PKCS#1 "RSA PUBLIC KEY" and "CERTIFICATE" blocks.
+func ParsePublicKeyPEM(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// LoadPublicKeyFile loads a PEM public key, reloading it when the file changes
+func LoadPublicKeyFile(path string) (any, error) {
+	return loadKeyFile(path, func(data []byte) (any, error) {
+		return ParsePublicKeyPEM(data)
+	})
+}
+
+// LoadJWKSFile loads a JSON Web Key Set, reloading it when the file changes
+func LoadJWKSFile(path string) (*jose.JSONWebKeySet, error) {
+	value, err := loadKeyFile(path, func(data []byte) (any, error) {
+		var keySet jose.JSONWebKeySet
+		if err := json.Unmarshal(data, &keySet); err != nil {
+			return nil, err
+		}
+		if len(keySet.Keys) == 0 {
+			return nil, errors.New("key set contains no keys")
+		}
+		return &keySet, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*jose.JSONWebKeySet), nil
+}
+
+// LoadVerifyConfig assembles the JWT verification settings from server configuration.
+// Key files are re-read when they change, so calling this per request picks up rotated keys.
+func LoadVerifyConfig() (VerifyConfig, error) {
+	secret, err := config.GetJWTSecret()
+	if err != nil {
+		return VerifyConfig{}, err
+	}
+
+	verifyConfig := VerifyConfig{
+		SecretKey:        secret,
+		ExpectedIssuer:   config.GetJWTIssuer(),
+		ExpectedAudience: config.GetJWTAudience(),
+		ClockSkew:        config.GetJWTClockSkew(),
+	}
+
+	if path := config.GetJWTPublicKeyFile(); path != "" {
+		publicKey, err := LoadPublicKeyFile(path)
+		if err != nil {
+			return VerifyConfig{}, err
+		}
+		verifyConfig.PublicKey = publicKey
+	}
+
+	if path := config.GetJWKSFile(); path != "" {
+		keySet, err := LoadJWKSFile(path)
+		if err != nil {
+			return VerifyConfig{}, err
+		}
+		verifyConfig.KeySet = keySet
+	}
+
+	return verifyConfig, nil
+}
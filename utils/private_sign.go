@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidURLSignature is returned for private URLs whose signature does not match
+	ErrInvalidURLSignature = errors.New("invalid URL signature")
+	// ErrURLExpired is returned for private URLs past their expiry
+	ErrURLExpired = errors.New("URL expired")
+)
+
+// privateURLMessage is the signed content of a private URL: the escaped file path and the expiry
+func privateURLMessage(escapedPath string, expires int64) string {
+	return fmt.Sprintf("%s\n%d", escapedPath, expires)
+}
+
+// PrivateURL builds a signed /private/ URL that serves the file at filePath (relative to the
+// private serve directory) until expires. The signature is the unpadded URL-safe base64
+// HMAC-SHA256 of "<escaped path>\n<expires unix seconds>".
+func PrivateURL(key []byte, filePath string, expires time.Time) string {
+	segments := strings.Split(strings.TrimPrefix(filePath, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	escapedPath := "/" + strings.Join(segments, "/")
+	signature := signHMAC(key, privateURLMessage(escapedPath, expires.Unix()))
+	return fmt.Sprintf("/private%s?expires=%d&sig=%s", escapedPath, expires.Unix(), signature)
+}
+
+// VerifyPrivateURL checks the expires and sig query values of a private URL for the escaped
+// file path below /private, returning ErrInvalidURLSignature or ErrURLExpired if it must be refused
+func VerifyPrivateURL(key []byte, escapedPath string, query url.Values, now time.Time) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return ErrInvalidURLSignature
+	}
+	if !verifyHMAC(key, query.Get("sig"), privateURLMessage(escapedPath, expires)) {
+		return ErrInvalidURLSignature
+	}
+	if now.Unix() > expires {
+		return ErrURLExpired
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SignTransformPath returns the signature for a transformation URL path of the form
+// "/<width>x<height>/<format>/<path>", as unpadded URL-safe base64 of its HMAC-SHA256.
+// The path must be URL-escaped exactly as it will appear in the request.
+func SignTransformPath(key []byte, transformPath string) string {
+	return signHMAC(key, transformPath)
+}
+
+// VerifyTransformSignature reports whether signature is valid for transformPath, in constant time
+func VerifyTransformSignature(key []byte, signature, transformPath string) bool {
+	return verifyHMAC(key, signature, transformPath)
+}
+
+// signHMAC returns the unpadded URL-safe base64 HMAC-SHA256 of message
+func signHMAC(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyHMAC reports whether signature is signHMAC(key, message), in constant time
+func verifyHMAC(key []byte, signature, message string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hmac.Equal(decoded, mac.Sum(nil))
+}
+
+// TransformURL builds a signed /img/ URL path that serves the file at filePath (relative to the
+// direct serve directory) resized to fit width x height and encoded as format.
+// A zero width or height is derived from the source aspect ratio.
+func TransformURL(key []byte, width, height int, format, filePath string) string {
+	segments := strings.Split(strings.TrimPrefix(filePath, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	transformPath := fmt.Sprintf("/%dx%d/%s/%s", width, height, url.PathEscape(format), strings.Join(segments, "/"))
+	return "/img/" + SignTransformPath(key, transformPath) + transformPath
+}
@@ -0,0 +1,73 @@
+package writerbackends
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// ErrChecksumMismatch is returned when a backend reports a different checksum or size for a
+// written object than was computed while streaming it; the object is removed again
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// Checksums describes the content streamed to a backend by WriteImage
+type Checksums struct {
+	Size   int64
+	SHA256 string // hex
+	MD5    string // hex
+	CRC32C uint32 // Castagnoli, as reported by GCS
+}
+
+// checksumReader computes Checksums of everything read through it
+type checksumReader struct {
+	reader io.Reader
+	size   int64
+	sha256 hash.Hash
+	md5    hash.Hash
+	crc32c hash.Hash32
+}
+
+func newChecksumReader(reader io.Reader) *checksumReader {
+	return &checksumReader{
+		reader: reader,
+		sha256: sha256.New(),
+		md5:    md5.New(),
+		crc32c: crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+	}
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if n > 0 {
+		c.size += int64(n)
+		c.sha256.Write(p[:n])
+		c.md5.Write(p[:n])
+		c.crc32c.Write(p[:n])
+	}
+	return n, err
+}
+
+// Checksums returns the checksums of the content read so far
+func (c *checksumReader) Checksums() Checksums {
+	return Checksums{
+		Size:   c.size,
+		SHA256: hex.EncodeToString(c.sha256.Sum(nil)),
+		MD5:    hex.EncodeToString(c.md5.Sum(nil)),
+		CRC32C: c.crc32c.Sum32(),
+	}
+}
+
+// streamedChecksums returns the checksums of what a writer has read from reader so far, if
+// reader is the one WriteImage passes to writers. Writers call it once they have consumed the
+// reader to verify the object their backend reports.
+func streamedChecksums(reader io.Reader) (Checksums, bool) {
+	c, ok := reader.(*checksumReader)
+	if !ok {
+		return Checksums{}, false
+	}
+	return c.Checksums(), true
+}
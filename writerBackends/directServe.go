@@ -4,8 +4,12 @@ package writerbackends
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,8 +17,55 @@ import (
 	"pixerve/logger"
 )
 
+// DirectServeType is the backend type of files served by Pixerve itself.
+// It is configured by the server and enabled with directHost rather than a storage key.
+const DirectServeType = "directServe"
+
+// DirectServeWriter writes to the local serve directory given as "baseDir" in accessInfo
+type DirectServeWriter struct{}
+
+func (DirectServeWriter) Write(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	return UploadToDirectServe(ctx, accessInfo, reader)
+}
+
+func (DirectServeWriter) Delete(ctx context.Context, accessInfo map[string]string) error {
+	return DeleteFromDirectServe(ctx, accessInfo)
+}
+
+func (DirectServeWriter) Stat(ctx context.Context, accessInfo map[string]string) (ObjectInfo, error) {
+	info, err := os.Stat(filepath.Join(accessInfo["baseDir"], accessInfo["folder"], accessInfo["filename"]))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// HealthCheck verifies that files can be created in the base directory
+func (DirectServeWriter) HealthCheck(ctx context.Context, accessInfo map[string]string) error {
+	baseDir := accessInfo["baseDir"]
+	if err := os.MkdirAll(baseDir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	f, err := os.CreateTemp(baseDir, ".healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("serve directory %s is not writable: %w", baseDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// RequiredFields is empty: the base directory comes from server configuration
+func (DirectServeWriter) RequiredFields() []string {
+	return nil
+}
+
 // UploadToDirectServe uploads content from an io.Reader to a local file system path,
-// which is served directly by the HTTP server.
+// which is served directly by the HTTP server. The content is written to a hidden temporary
+// file in the target directory and renamed into place once complete, so clients never
+// fetch a partially written file.
 func UploadToDirectServe(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
 	// Extract the target directory and filename from accessInfo
 	baseDir := accessInfo["baseDir"]   // Base directory where files are served from
@@ -30,22 +81,78 @@ func UploadToDirectServe(ctx context.Context, accessInfo map[string]string, read
 		return fmt.Errorf("failed to create directories: %w", err)
 	}
 
-	// Create or truncate the target file
-	file, err := os.Create(fullPath)
+	// Write to a temporary file next to the target, removed unless it is renamed into place
+	file, err := os.CreateTemp(fullDir, "."+filename+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", fullPath, err)
+		return fmt.Errorf("failed to create temporary file for %s: %w", fullPath, err)
 	}
-	defer file.Close()
+	tmpPath := file.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			file.Close()
+			os.Remove(tmpPath)
+		}
+	}()
 
 	// Copy the content from the reader to the file
 	if _, err := io.Copy(file, reader); err != nil {
 		return fmt.Errorf("failed to write to file %s: %w", fullPath, err)
 	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file %s: %w", fullPath, err)
+	}
+	if sums, ok := streamedChecksums(reader); ok {
+		if err := verifyLocalFile(file, sums); err != nil {
+			return fmt.Errorf("failed to verify file %s: %w", fullPath, err)
+		}
+	}
+	// CreateTemp makes the file private; served files are world-readable like os.Create's
+	if err := file.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to set permissions of file %s: %w", fullPath, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", fullPath, err)
+	}
+
+	if err := os.Rename(tmpPath, fullPath); err != nil {
+		return fmt.Errorf("failed to move file into place at %s: %w", fullPath, err)
+	}
+	committed = true
 
 	logger.Infof("Successfully saved file '%s' to '%s'", filename, fullPath)
 	return nil
 }
 
+// verifyLocalFile reads file back and checks it against the checksums of the streamed content
+func verifyLocalFile(file *os.File, sums Checksums) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); size != sums.Size || got != sums.SHA256 {
+		return fmt.Errorf("%w: wrote %d bytes with sha256 %s, file has %d bytes with sha256 %s",
+			ErrChecksumMismatch, sums.Size, sums.SHA256, size, got)
+	}
+	return nil
+}
+
+// DeleteFromDirectServe removes a file written by UploadToDirectServe
+func DeleteFromDirectServe(ctx context.Context, accessInfo map[string]string) error {
+	fullPath := filepath.Join(accessInfo["baseDir"], accessInfo["folder"], accessInfo["filename"])
+
+	if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove file %s: %w", fullPath, err)
+	}
+
+	logger.Infof("Removed file '%s'", fullPath)
+	return nil
+}
+
 func UseUploadToDirectServeExample() {
 	// Example usage of UploadToDirectServe
 	baseDir := "./public" // Base directory where files are served from
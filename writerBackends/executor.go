@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
+
+	"pixerve/config"
 )
 
 type WriteInstruction struct {
@@ -11,32 +14,85 @@ type WriteInstruction struct {
 	AccessInfo  map[string]string // e.g., credentials, bucket names, paths
 }
 
-func WriteImage(ctx context.Context, accessInfo map[string]string, reader io.Reader, backendType string) error {
-	// Implementation for writing an image
-	// we will switch based on the backend type, e.g., directServe, s3, gcs, sftp (files in same dir)
-	switch backendType {
-	case "directServe":
-		err := UploadToDirectServe(ctx, accessInfo, reader)
-		if err != nil {
-			return fmt.Errorf("failed to upload to direct serve: %w", err)
-		}
-	case "s3":
-		err := UploadToS3WithCreds(ctx, accessInfo, reader)
-		if err != nil {
-			return fmt.Errorf("failed to upload to S3: %w", err)
-		}
-	case "gcs":
-		err := UploadToGCSWithJSON(ctx, accessInfo, reader)
-		if err != nil {
-			return fmt.Errorf("failed to upload to GCS: %w", err)
-		}
-	case "sftp":
-		err := UploadToSFTPWithCreds(ctx, accessInfo, reader)
-		if err != nil {
-			return fmt.Errorf("failed to upload to SFTP: %w", err)
-		}
-	default:
+// contextReader stops reading once ctx is done, so a cancelled job aborts uploads that
+// copy from it instead of streaming the rest of the file
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
+// writerSlots limits the concurrent writes and deletes per backend type
+var (
+	writerSlotsMu sync.Mutex
+	writerSlots   = make(map[string]chan struct{})
+)
+
+// acquireWriterSlot waits until fewer than PIXERVE_WRITER_CONCURRENCY writes and deletes run
+// against backendType, then returns a function that frees the slot again
+func acquireWriterSlot(ctx context.Context, backendType string) (func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	writerSlotsMu.Lock()
+	slots, ok := writerSlots[backendType]
+	if !ok {
+		slots = make(chan struct{}, config.GetWriterConcurrency(backendType))
+		writerSlots[backendType] = slots
+	}
+	writerSlotsMu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// WriteImage writes reader to the backend registered for backendType and returns the checksums
+// of the written content, computed while streaming it. Built-in backends verify them against
+// the checksum or size they report for the stored object.
+func WriteImage(ctx context.Context, accessInfo map[string]string, reader io.Reader, backendType string) (Checksums, error) {
+	w, ok := Get(backendType)
+	if !ok {
+		return Checksums{}, fmt.Errorf("unknown backend type: %s", backendType)
+	}
+	release, err := acquireWriterSlot(ctx, backendType)
+	if err != nil {
+		return Checksums{}, fmt.Errorf("failed to upload to %s: %w", backendType, err)
+	}
+	defer release()
+
+	checksums := newChecksumReader(&contextReader{ctx: ctx, reader: reader})
+	if err := w.Write(ctx, accessInfo, checksums); err != nil {
+		return Checksums{}, fmt.Errorf("failed to upload to %s: %w", backendType, err)
+	}
+	return checksums.Checksums(), nil
+}
+
+// DeleteImage removes an object previously written by WriteImage with the same accessInfo.
+// Objects that do not exist (for example because the upload never finished) are not an error.
+func DeleteImage(ctx context.Context, accessInfo map[string]string, backendType string) error {
+	w, ok := Get(backendType)
+	if !ok {
 		return fmt.Errorf("unknown backend type: %s", backendType)
 	}
+	release, err := acquireWriterSlot(ctx, backendType)
+	if err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", backendType, err)
+	}
+	defer release()
+
+	if err := w.Delete(ctx, accessInfo); err != nil {
+		return fmt.Errorf("failed to delete from %s: %w", backendType, err)
+	}
 	return nil
 }
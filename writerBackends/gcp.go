@@ -3,6 +3,8 @@ package writerbackends
 import (
 	"context"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 
@@ -12,41 +14,165 @@ import (
 	"google.golang.org/api/option"
 )
 
-// uploadToGCSWithJSON uploads content from an io.Reader to a Google Cloud Storage object,
-// using a service account key provided as a byte slice.
+// GCSWriter writes to the Google Cloud Storage bucket in accessInfo
+type GCSWriter struct{}
 
-func UploadToGCSWithJSON(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
-	// Decode base64 credentials
-	credentialsJSON, err := base64.RawStdEncoding.DecodeString(accessInfo["credentialsJSON"])
+func (GCSWriter) Write(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	return UploadToGCSWithJSON(ctx, accessInfo, reader)
+}
+
+func (GCSWriter) Delete(ctx context.Context, accessInfo map[string]string) error {
+	return DeleteFromGCSWithJSON(ctx, accessInfo)
+}
+
+func (GCSWriter) Stat(ctx context.Context, accessInfo map[string]string) (ObjectInfo, error) {
+	client, release, err := pooledGCSClient(ctx, accessInfo)
 	if err != nil {
-		return fmt.Errorf("failed to decode base64 credentials: %w", err)
+		return ObjectInfo{}, err
 	}
+	defer release()
 
-	bucketName := accessInfo["bucket"]
-	objectName := accessInfo["object"]
-	client, err := storage.NewClient(ctx, option.WithCredentialsJSON(credentialsJSON))
+	attrs, err := client.Bucket(accessInfo["bucket"]).Object(accessInfo["objectPath"]).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("Object.Attrs: %w", err)
+	}
+	return ObjectInfo{Size: attrs.Size, ModTime: attrs.Updated}, nil
+}
+
+// HealthCheck verifies that the bucket exists and the service account may access it
+func (GCSWriter) HealthCheck(ctx context.Context, accessInfo map[string]string) error {
+	client, err := newGCSClient(ctx, accessInfo)
 	if err != nil {
-		return fmt.Errorf("storage.NewClient: %w", err)
+		return err
 	}
 	defer client.Close()
 
+	if _, err := client.Bucket(accessInfo["bucket"]).Attrs(ctx); err != nil {
+		return fmt.Errorf("Bucket.Attrs: %w", err)
+	}
+	return nil
+}
+
+func (GCSWriter) RequiredFields() []string {
+	return []string{"credentialsJSON", "bucket"}
+}
+
+// ValidateCredentials checks the optional pathTemplate, cacheControl and metadata fields
+func (GCSWriter) ValidateCredentials(accessInfo map[string]string) error {
+	return validateObjectFields(accessInfo)
+}
+
+// uploadToGCSWithJSON uploads content from an io.Reader to a Google Cloud Storage object,
+// using a service account key provided as a byte slice.
+// The object gets the content type, cacheControl and "metadata.*" fields of accessInfo.
+func UploadToGCSWithJSON(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	bucketName := accessInfo["bucket"]
+	objectName := accessInfo["objectPath"]
+	client, release, err := pooledGCSClient(ctx, accessInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Get a handle to the bucket and object.
 	bucket := client.Bucket(bucketName)
 	obj := bucket.Object(objectName)
 
 	// Create a writer to stream the data to the object.
 	wc := obj.NewWriter(ctx)
+	wc.ContentType = objectContentType(accessInfo)
+	wc.CacheControl = accessInfo["cacheControl"]
+	wc.Metadata = objectMetadata(accessInfo)
 
 	// Copy the content from the reader to the writer.
 	if _, err = io.Copy(wc, reader); err != nil {
 		return fmt.Errorf("io.Copy: %w", err)
 	}
 
-	// Close the writer to complete the upload.
+	// Close the writer to complete the upload; GCS only creates the object now.
 	if err := wc.Close(); err != nil {
 		return fmt.Errorf("Writer.Close: %w", err)
 	}
 
+	if sums, ok := streamedChecksums(reader); ok {
+		if err := verifyGCSObject(wc.Attrs(), sums); err != nil {
+			if delErr := obj.Delete(ctx); delErr != nil {
+				logger.Errorf("Failed to delete unverified object '%s' from bucket '%s': %v", objectName, bucketName, delErr)
+			}
+			return fmt.Errorf("failed to verify object %s in bucket %s: %w", objectName, bucketName, err)
+		}
+	}
+
 	logger.Infof("Successfully uploaded object '%s' to bucket '%s'", objectName, bucketName)
 	return nil
 }
+
+// verifyGCSObject checks the CRC32C, and the MD5 where GCS reports one, of an uploaded object
+// against the streamed checksums
+func verifyGCSObject(attrs *storage.ObjectAttrs, sums Checksums) error {
+	if attrs == nil {
+		return fmt.Errorf("no object attributes after upload")
+	}
+	if attrs.CRC32C != sums.CRC32C {
+		return fmt.Errorf("%w: CRC32C %08x, streamed %08x", ErrChecksumMismatch, attrs.CRC32C, sums.CRC32C)
+	}
+	if len(attrs.MD5) > 0 && hex.EncodeToString(attrs.MD5) != sums.MD5 {
+		return fmt.Errorf("%w: MD5 %x, streamed %s", ErrChecksumMismatch, attrs.MD5, sums.MD5)
+	}
+	return nil
+}
+
+// DeleteFromGCSWithJSON deletes an object written by UploadToGCSWithJSON.
+// A cancelled upload never creates its object, so a missing object is not an error.
+func DeleteFromGCSWithJSON(ctx context.Context, accessInfo map[string]string) error {
+	bucketName := accessInfo["bucket"]
+	objectName := accessInfo["objectPath"]
+	client, release, err := pooledGCSClient(ctx, accessInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = client.Bucket(bucketName).Object(objectName).Delete(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("Object.Delete: %w", err)
+	}
+
+	logger.Infof("Deleted object '%s' from bucket '%s'", objectName, bucketName)
+	return nil
+}
+
+// gcsClientFields are the accessInfo fields that identify a pooled GCS client
+var gcsClientFields = []string{"credentialsJSON"}
+
+// pooledGCSClient returns the pooled storage client for the service account in accessInfo.
+// Callers must call the returned release function.
+func pooledGCSClient(ctx context.Context, accessInfo map[string]string) (*storage.Client, func(), error) {
+	return acquireClient(ctx, "gcs", accessInfo, gcsClientFields, func(context.Context, func()) (*storage.Client, func(), error) {
+		// The client keeps the context it is created with for authentication, so it must
+		// not be one that ends with the first write
+		client, err := newGCSClient(context.Background(), accessInfo)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() { client.Close() }, nil
+	})
+}
+
+// newGCSClient creates a storage client from the base64 service account key in accessInfo
+func newGCSClient(ctx context.Context, accessInfo map[string]string) (*storage.Client, error) {
+	// Decode base64 credentials
+	credentialsJSON, err := base64.RawStdEncoding.DecodeString(accessInfo["credentialsJSON"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 credentials: %w", err)
+	}
+
+	client, err := storage.NewClient(ctx, option.WithCredentialsJSON(credentialsJSON))
+	if err != nil {
+		return nil, fmt.Errorf("storage.NewClient: %w", err)
+	}
+	return client, nil
+}
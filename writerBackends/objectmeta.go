@@ -0,0 +1,177 @@
+package writerbackends
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MetadataFieldPrefix prefixes the accessInfo fields that hold custom object metadata, so a
+// credential bundle field "metadata.team" stores the object metadata "team"
+const MetadataFieldPrefix = "metadata."
+
+// ProvenancePrefix prefixes the object metadata Pixerve sets itself (see ProvenanceMetadata);
+// custom metadata may not use it
+const ProvenancePrefix = "pixerve-"
+
+// maxMetadataSize bounds the custom metadata of a job or credential bundle, keys and values
+// together. Job and bundle metadata are merged with provenance, so the object's final set is
+// checked separately against maxObjectMetadataSize.
+const maxMetadataSize = 1024
+
+// maxObjectMetadataSize is the 2 KB of user-defined metadata S3 allows per object, keys and
+// values together
+const maxObjectMetadataSize = 2048
+
+// maxSourceMetadataLength bounds the escaped source filename in provenance metadata; longer
+// names are shortened (see sourceMetadataValue)
+const maxSourceMetadataLength = 256
+
+// metadataKeyPattern matches metadata names that are valid in both S3 headers and GCS; S3
+// stores names in lower case, so only lower case is accepted
+var metadataKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ContentTypeForExt returns the MIME type of files with extension ext (without the dot),
+// falling back to application/octet-stream
+func ContentTypeForExt(ext string) string {
+	if contentType := mime.TypeByExtension("." + strings.ToLower(ext)); ext != "" && contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// ProvenanceMetadata returns the object metadata recording where an output came from. The
+// source filename is percent-encoded, since S3 metadata must be ASCII, and shortened if very
+// long; dimensions are left out for kept originals.
+func ProvenanceMetadata(hash, sourceFile, encoder string, width, height int) map[string]string {
+	metadata := map[string]string{
+		ProvenancePrefix + "hash":    hash,
+		ProvenancePrefix + "source":  sourceMetadataValue(sourceFile),
+		ProvenancePrefix + "encoder": encoder,
+	}
+	if width > 0 && height > 0 {
+		metadata[ProvenancePrefix+"width"] = fmt.Sprint(width)
+		metadata[ProvenancePrefix+"height"] = fmt.Sprint(height)
+	}
+	return metadata
+}
+
+// sourceMetadataValue returns the escaped source filename, or for names escaping to more than
+// maxSourceMetadataLength bytes, a prefix of it followed by "..." and a hash of the full name
+func sourceMetadataValue(sourceFile string) string {
+	escaped := escapeMetadataValue(sourceFile)
+	if len(escaped) <= maxSourceMetadataLength {
+		return escaped
+	}
+	sum := sha256.Sum256([]byte(sourceFile))
+	suffix := "..." + hex.EncodeToString(sum[:8])
+	cut := maxSourceMetadataLength - len(suffix)
+	// Do not split a %XX escape
+	if i := strings.LastIndexByte(escaped[:cut], '%'); i >= cut-2 {
+		cut = i
+	}
+	return escaped[:cut] + suffix
+}
+
+// escapeMetadataValue percent-encodes s the way URL paths are, so any filename fits in a header
+func escapeMetadataValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c > ' ' && c < 0x7f && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// ValidateObjectMetadata checks custom metadata from a job or credential bundle: names are
+// lower case letters, digits, '-' and '_', values printable ASCII, and names must not use
+// ProvenancePrefix
+func ValidateObjectMetadata(metadata map[string]string) error {
+	size := 0
+	for name, value := range metadata {
+		if !metadataKeyPattern.MatchString(name) {
+			return fmt.Errorf("invalid metadata name %q: expected lower case letters, digits, '-' and '_'", name)
+		}
+		if strings.HasPrefix(name, ProvenancePrefix) {
+			return fmt.Errorf("metadata name %q uses the reserved prefix %q", name, ProvenancePrefix)
+		}
+		if !isPrintableASCII(value) {
+			return fmt.Errorf("invalid value for metadata %q: expected printable ASCII", name)
+		}
+		size += len(name) + len(value)
+	}
+	if size > maxMetadataSize {
+		return fmt.Errorf("metadata is %d bytes, at most %d are allowed", size, maxMetadataSize)
+	}
+	return nil
+}
+
+// ValidateObjectMetadataSize checks that the final "metadata.*" fields of accessInfo, merged
+// from the job, its credential bundle and provenance, fit within the 2 KB S3 allows per object
+func ValidateObjectMetadataSize(accessInfo map[string]string) error {
+	size := 0
+	for name, value := range objectMetadata(accessInfo) {
+		size += len(name) + len(value)
+	}
+	if size > maxObjectMetadataSize {
+		return fmt.Errorf("object metadata is %d bytes including provenance, at most %d are allowed; reduce the job or credential bundle metadata", size, maxObjectMetadataSize)
+	}
+	return nil
+}
+
+// ValidateCacheControl checks that value can be sent as a Cache-Control header
+func ValidateCacheControl(value string) error {
+	if !isPrintableASCII(value) {
+		return fmt.Errorf("invalid cacheControl %q: expected printable ASCII", value)
+	}
+	return nil
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// objectMetadata returns the object metadata in the "metadata.*" fields of accessInfo
+func objectMetadata(accessInfo map[string]string) map[string]string {
+	metadata := make(map[string]string)
+	for field, value := range accessInfo {
+		if name, ok := strings.CutPrefix(field, MetadataFieldPrefix); ok {
+			metadata[name] = value
+		}
+	}
+	return metadata
+}
+
+// objectContentType returns the "contentType" of accessInfo, or else the MIME type for the
+// extension of its object path
+func objectContentType(accessInfo map[string]string) string {
+	if contentType := accessInfo["contentType"]; contentType != "" {
+		return contentType
+	}
+	return ContentTypeForExt(strings.TrimPrefix(path.Ext(accessInfo["objectPath"]), "."))
+}
+
+// validateObjectFields checks the optional pathTemplate, cacheControl and "metadata.*" fields
+// of a credential bundle
+func validateObjectFields(accessInfo map[string]string) error {
+	if err := ValidatePathTemplate(accessInfo["pathTemplate"]); err != nil {
+		return err
+	}
+	if err := ValidateCacheControl(accessInfo["cacheControl"]); err != nil {
+		return err
+	}
+	return ValidateObjectMetadata(objectMetadata(accessInfo))
+}
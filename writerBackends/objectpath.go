@@ -0,0 +1,111 @@
+package writerbackends
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultPathTemplate names objects in remote backends when neither the job nor the
+// credential bundle sets a "pathTemplate"; it mirrors the directServe layout.
+const DefaultPathTemplate = "{prefix}/{subDir}/{filename}"
+
+// pathPlaceholder matches a {name} placeholder in a path template
+var pathPlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// PathVars are the values available to path templates for one output file
+type PathVars struct {
+	Prefix   string // "prefix" field of the credential bundle
+	SubDir   string // subDir of the job
+	Hash     string // job hash
+	Name     string // original filename without extension
+	Format   string // encoder name, "original" for kept originals
+	Width    int    // 0 for kept originals
+	Height   int    // 0 for kept originals
+	Ext      string // file extension without the dot
+	Filename string // generated output filename, as served by directServe
+}
+
+// value returns the value of placeholder name, or false if there is no such placeholder
+func (v PathVars) value(name string) (string, bool) {
+	switch name {
+	case "prefix":
+		return v.Prefix, true
+	case "subDir":
+		return v.SubDir, true
+	case "hash":
+		return v.Hash, true
+	case "name":
+		return v.Name, true
+	case "format":
+		return v.Format, true
+	case "width":
+		return strconv.Itoa(v.Width), true
+	case "height":
+		return strconv.Itoa(v.Height), true
+	case "ext":
+		return v.Ext, true
+	case "filename":
+		return v.Filename, true
+	}
+	return "", false
+}
+
+// RenderPath expands template with vars into an object path, using DefaultPathTemplate for
+// an empty template. Empty segments are dropped, so an unset {prefix} or {subDir} leaves no
+// stray slashes; "." and ".." segments are rejected.
+func RenderPath(template string, vars PathVars) (string, error) {
+	if template == "" {
+		template = DefaultPathTemplate
+	}
+
+	var unknown []string
+	rendered := pathPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+		name := match[1 : len(match)-1]
+		value, ok := vars.value(name)
+		if !ok {
+			unknown = append(unknown, match)
+		}
+		return value
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("path template %q has unknown placeholders: %s", template, strings.Join(unknown, ", "))
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(rendered, "/") {
+		switch segment {
+		case "":
+			continue
+		case ".", "..":
+			return "", fmt.Errorf("path template %q renders to invalid path %q", template, rendered)
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("path template %q renders to an empty path", template)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// ValidatePathTemplate checks that template only uses known placeholders and names
+// each output file distinctly, which requires {filename} or {hash} together with {format},
+// {width} and {height}
+func ValidatePathTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+	if _, err := RenderPath(template, PathVars{Hash: "h", Name: "n", Format: "f", Ext: "e", Filename: "file"}); err != nil {
+		return err
+	}
+	if strings.Contains(template, "{filename}") {
+		return nil
+	}
+	for _, required := range []string{"{hash}", "{format}", "{width}", "{height}"} {
+		if !strings.Contains(template, required) {
+			return fmt.Errorf("path template %q must contain {filename}, or {hash}, {format}, {width} and {height}", template)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package writerbackends
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"pixerve/config"
+	"pixerve/logger"
+)
+
+// clientDialTimeout bounds creating a pooled client. Creation does not use the context of the
+// write that triggered it, since the client outlives that write.
+const clientDialTimeout = 30 * time.Second
+
+// poolSweepInterval is how often idle pooled clients are looked for
+const poolSweepInterval = 30 * time.Second
+
+// pooledClient is a backend client shared by the writes of one credential bundle
+type pooledClient struct {
+	storageKey string
+	ready      chan struct{} // closed once client or err is set
+	client     any
+	closeFn    func()
+	err        error
+	refs       int
+	lastUsed   time.Time
+	removed    bool // no longer in the pool; closed when the last reference is released
+}
+
+// clientPool caches backend clients by backend type, storage key and connection fields, so the
+// files of a job reuse one SSH session or SDK client instead of connecting once per file
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+	sweeper sync.Once
+}
+
+var pool = &clientPool{clients: make(map[string]*pooledClient)}
+
+// poolKey identifies the client for accessInfo by backend type, storage key and the values of
+// the fields that determine the connection
+func poolKey(backendType string, accessInfo map[string]string, fields []string) string {
+	h := sha256.New()
+	for _, field := range fields {
+		fmt.Fprintf(h, "%s=%q\n", field, accessInfo[field])
+	}
+	return backendType + "\x00" + accessInfo["key"] + "\x00" + hex.EncodeToString(h.Sum(nil))
+}
+
+// acquireClient returns the pooled client of backendType for accessInfo, creating it with
+// newClient on first use; concurrent callers for the same bundle share one creation.
+// newClient gets a discard function to call when the client breaks (e.g. its connection drops).
+// The returned release function must be called once the caller is done with the client.
+func acquireClient[T any](ctx context.Context, backendType string, accessInfo map[string]string, fields []string,
+	newClient func(ctx context.Context, discard func()) (T, func(), error)) (T, func(), error) {
+	var zero T
+	key := poolKey(backendType, accessInfo, fields)
+	pool.sweeper.Do(func() { go pool.sweep() })
+
+	pool.mu.Lock()
+	entry, ok := pool.clients[key]
+	if !ok {
+		entry = &pooledClient{storageKey: accessInfo["key"], ready: make(chan struct{})}
+		pool.clients[key] = entry
+		go pool.create(key, entry, func(ctx context.Context, discard func()) (any, func(), error) {
+			return newClient(ctx, discard)
+		})
+	}
+	entry.refs++
+	pool.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+	case <-ctx.Done():
+		pool.release(entry)
+		return zero, nil, ctx.Err()
+	}
+	if entry.err != nil {
+		pool.release(entry)
+		return zero, nil, entry.err
+	}
+	return entry.client.(T), func() { pool.release(entry) }, nil
+}
+
+// create runs newClient for a new pool entry; failed creations are removed so the next
+// caller tries again
+func (p *clientPool) create(key string, entry *pooledClient, newClient func(ctx context.Context, discard func()) (any, func(), error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), clientDialTimeout)
+	defer cancel()
+
+	client, closeFn, err := newClient(ctx, func() { p.remove(key, entry) })
+
+	p.mu.Lock()
+	entry.client, entry.closeFn, entry.err = client, closeFn, err
+	entry.lastUsed = time.Now()
+	p.mu.Unlock()
+	if err != nil {
+		p.remove(key, entry)
+	}
+	close(entry.ready)
+}
+
+// release drops a reference to entry, closing it if it was removed from the pool meanwhile
+func (p *clientPool) release(entry *pooledClient) {
+	p.mu.Lock()
+	entry.refs--
+	entry.lastUsed = time.Now()
+	closeNow := entry.removed && entry.refs == 0
+	p.mu.Unlock()
+
+	if closeNow {
+		entry.close()
+	}
+}
+
+// remove takes entry out of the pool; it is closed now if unused, else on its last release
+func (p *clientPool) remove(key string, entry *pooledClient) {
+	p.mu.Lock()
+	if p.clients[key] == entry {
+		delete(p.clients, key)
+	}
+	closeNow := !entry.removed && entry.refs == 0
+	entry.removed = true
+	p.mu.Unlock()
+
+	if closeNow {
+		entry.close()
+	}
+}
+
+// close closes the client of a removed entry once it has been created
+func (c *pooledClient) close() {
+	go func() {
+		<-c.ready
+		if c.closeFn != nil {
+			c.closeFn()
+		}
+	}()
+}
+
+// sweep closes clients that have been idle longer than PIXERVE_WRITER_IDLE_TIMEOUT
+func (p *clientPool) sweep() {
+	ticker := time.NewTicker(poolSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		idleTimeout := config.GetWriterIdleTimeout()
+		idle := make(map[string]*pooledClient)
+		p.mu.Lock()
+		for key, entry := range p.clients {
+			if entry.refs == 0 && time.Since(entry.lastUsed) > idleTimeout {
+				idle[key] = entry
+			}
+		}
+		p.mu.Unlock()
+
+		for key, entry := range idle {
+			p.remove(key, entry)
+		}
+		if len(idle) > 0 {
+			logger.Debugf("Closed %d idle backend clients", len(idle))
+		}
+	}
+}
+
+// InvalidateClients closes the pooled clients of the credential bundle registered under
+// storageKey, e.g. after it was deregistered. Writes still using them finish first.
+func InvalidateClients(storageKey string) {
+	stale := make(map[string]*pooledClient)
+	pool.mu.Lock()
+	for key, entry := range pool.clients {
+		if entry.storageKey == storageKey {
+			stale[key] = entry
+		}
+	}
+	pool.mu.Unlock()
+
+	for key, entry := range stale {
+		pool.remove(key, entry)
+	}
+	if len(stale) > 0 {
+		logger.Infof("Closed %d pooled backend clients of storage key %s", len(stale), storageKey)
+	}
+}
@@ -0,0 +1,131 @@
+package writerbackends
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"pixerve/logger"
+)
+
+// ErrObjectNotFound is returned by Writer.Stat for objects that do not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Writer stores converted images in one kind of storage backend.
+// accessInfo is the backend's credential bundle merged with the per-file "filename" and
+// "folder" set by the job processor (see ResolveWriterJobs and prepareAccessInfo in job).
+type Writer interface {
+	// Write stores the content of reader as the object described by accessInfo. The object
+	// must not become visible under its name before it is complete.
+	Write(ctx context.Context, accessInfo map[string]string, reader io.Reader) error
+	// Delete removes the object; objects that do not exist are not an error
+	Delete(ctx context.Context, accessInfo map[string]string) error
+	// Stat reports the stored object, or ErrObjectNotFound
+	Stat(ctx context.Context, accessInfo map[string]string) (ObjectInfo, error)
+	// HealthCheck verifies the backend is reachable and usable with the credentials in accessInfo
+	HealthCheck(ctx context.Context, accessInfo map[string]string) error
+	// RequiredFields lists the accessInfo keys a credential bundle must provide.
+	// An entry of the form "a|b" is satisfied by either field.
+	RequiredFields() []string
+}
+
+// CredentialValidator is implemented by writers that check credential fields beyond their
+// presence, such as URLs and flags. ValidateAccessInfo calls it once RequiredFields are present.
+type CredentialValidator interface {
+	ValidateCredentials(accessInfo map[string]string) error
+}
+
+// Registry maps backend type → writer
+var Registry = map[string]Writer{}
+
+// Register adds the writer for a backend type, replacing any existing one.
+// Registration is not synchronized and belongs in init or startup code.
+func Register(backendType string, w Writer) {
+	Registry[backendType] = w
+	logger.Debugf("writer backend [%s] registered", backendType)
+}
+
+// Get looks up the writer for a backend type
+func Get(backendType string) (Writer, bool) {
+	w, ok := Registry[backendType]
+	return w, ok
+}
+
+// Types returns the registered backend types in sorted order
+func Types() []string {
+	types := make([]string, 0, len(Registry))
+	for backendType := range Registry {
+		types = append(types, backendType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// RegisterDefaults registers the built-in backends
+func RegisterDefaults() {
+	Register(DirectServeType, DirectServeWriter{})
+	Register("s3", S3Writer{})
+	Register("gcs", GCSWriter{})
+	Register("sftp", SFTPWriter{})
+}
+
+func init() {
+	RegisterDefaults()
+}
+
+// ValidateStorageType checks that a job's StorageKeys entry names a registered backend that
+// takes registered credentials. directServe is configured by the server and enabled with directHost.
+func ValidateStorageType(backendType string) error {
+	if backendType == DirectServeType {
+		return fmt.Errorf("%s does not use registered credentials; set directHost instead", DirectServeType)
+	}
+	if _, ok := Get(backendType); !ok {
+		return fmt.Errorf("unknown backend type: %s (available: %s)", backendType, strings.Join(Types(), ", "))
+	}
+	return nil
+}
+
+// ValidateAccessInfo checks that accessInfo contains every field the given backend requires.
+// The returned error names all missing fields so an incomplete credential bundle can be fixed in one go.
+func ValidateAccessInfo(backendType string, accessInfo map[string]string) error {
+	w, ok := Get(backendType)
+	if !ok {
+		return fmt.Errorf("unknown backend type: %s", backendType)
+	}
+
+	var missing []string
+	for _, field := range w.RequiredFields() {
+		alternatives := strings.Split(field, "|")
+		present := false
+		for _, alternative := range alternatives {
+			if accessInfo[alternative] != "" {
+				present = true
+				break
+			}
+		}
+		if !present {
+			missing = append(missing, strings.Join(alternatives, " or "))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("credential bundle is missing required fields: %s", strings.Join(missing, ", "))
+	}
+
+	if validator, ok := w.(CredentialValidator); ok {
+		if err := validator.ValidateCredentials(accessInfo); err != nil {
+			return fmt.Errorf("invalid credential bundle: %w", err)
+		}
+	}
+	return nil
+}
@@ -3,8 +3,13 @@ package writerbackends
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"pixerve/logger"
 
@@ -12,39 +17,272 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
-// uploadToS3WithCreds uploads content from an io.Reader to an S3 object
-// and is fully self-contained, initializing its own client.
-func UploadToS3WithCreds(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+// S3Writer writes to the S3 bucket in accessInfo
+type S3Writer struct{}
+
+func (S3Writer) Write(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	return UploadToS3WithCreds(ctx, accessInfo, reader)
+}
+
+func (S3Writer) Delete(ctx context.Context, accessInfo map[string]string) error {
+	return DeleteFromS3WithCreds(ctx, accessInfo)
+}
+
+func (S3Writer) Stat(ctx context.Context, accessInfo map[string]string) (ObjectInfo, error) {
+	client, release, err := pooledS3Client(ctx, accessInfo)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer release()
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(accessInfo["bucket"]),
+		Key:    aws.String(accessInfo["objectPath"]),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerHeaders(accessInfo)
+	out, err := client.HeadObject(ctx, input)
+	if err != nil {
+		var notFound *types.NotFound
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat object %s in bucket %s: %w", accessInfo["objectPath"], accessInfo["bucket"], err)
+	}
+	return ObjectInfo{Size: aws.ToInt64(out.ContentLength), ModTime: aws.ToTime(out.LastModified)}, nil
+}
+
+// HealthCheck verifies that the bucket exists and the credentials may access it
+func (S3Writer) HealthCheck(ctx context.Context, accessInfo map[string]string) error {
+	client, err := newS3Client(accessInfo)
+	if err != nil {
+		return err
+	}
+	_, err = client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(accessInfo["bucket"])})
+	if err != nil {
+		return fmt.Errorf("failed to access bucket %s: %w", accessInfo["bucket"], err)
+	}
+	return nil
+}
+
+func (S3Writer) RequiredFields() []string {
+	return []string{"accessKey", "secretKey", "region", "bucket"}
+}
+
+// ValidateCredentials checks the optional endpoint, usePathStyle, disableTLS, pathTemplate,
+// cacheControl, metadata and storage option fields
+func (S3Writer) ValidateCredentials(accessInfo map[string]string) error {
+	if _, err := newS3Client(accessInfo); err != nil {
+		return err
+	}
+	if err := validateS3StorageFields(accessInfo); err != nil {
+		return err
+	}
+	return validateObjectFields(accessInfo)
+}
+
+// s3ClientFields are the accessInfo fields that identify a pooled S3 client
+var s3ClientFields = []string{"accessKey", "secretKey", "sessionToken", "region", "endpoint", "usePathStyle", "disableTLS"}
+
+// pooledS3Client returns the pooled S3 client for the credentials in accessInfo, so writes with
+// the same bundle share its HTTP connections. Callers must call the returned release function.
+func pooledS3Client(ctx context.Context, accessInfo map[string]string) (*s3.Client, func(), error) {
+	return acquireClient(ctx, "s3", accessInfo, s3ClientFields, func(context.Context, func()) (*s3.Client, func(), error) {
+		client, err := newS3Client(accessInfo)
+		return client, nil, err
+	})
+}
+
+// newS3Client creates an S3 client from the credentials and region in accessInfo.
+// For S3-compatible services (MinIO, R2, Backblaze B2, ...) accessInfo may also set:
+//   - endpoint: base URL of the service, e.g. "https://minio.internal:9000" or "minio.internal:9000"
+//   - usePathStyle: "true" to address buckets as <endpoint>/<bucket> instead of <bucket>.<endpoint>
+//   - disableTLS: "true" to talk plain HTTP to an endpoint given without a scheme
+//   - sessionToken: session token for temporary credentials
+func newS3Client(accessInfo map[string]string) (*s3.Client, error) {
 	// Create a credentials provider from the provided keys.
-	creds := credentials.NewStaticCredentialsProvider(accessInfo["accessKey"], accessInfo["secretKey"], "")
-	key := accessInfo["key"]
-	bucket := accessInfo["bucket"]
-	// Create a new S3 client with the specific credentials and region.
-	s3Client := s3.New(s3.Options{
+	creds := credentials.NewStaticCredentialsProvider(accessInfo["accessKey"], accessInfo["secretKey"], accessInfo["sessionToken"])
+	opts := s3.Options{
 		Region:      accessInfo["region"],
 		Credentials: creds,
-	})
+	}
+
+	usePathStyle, err := parseBoolField(accessInfo, "usePathStyle")
+	if err != nil {
+		return nil, err
+	}
+	opts.UsePathStyle = usePathStyle
+
+	if accessInfo["endpoint"] != "" {
+		endpoint, err := s3Endpoint(accessInfo)
+		if err != nil {
+			return nil, err
+		}
+		opts.BaseEndpoint = aws.String(endpoint)
+		// Many S3-compatible services reject the checksum headers the SDK sends by default
+		opts.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		opts.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	}
+
+	// Create a new S3 client with the specific credentials and region.
+	return s3.New(opts), nil
+}
+
+// s3Endpoint returns the base URL of the custom endpoint in accessInfo. Endpoints without a
+// scheme use https, or http when disableTLS is set.
+func s3Endpoint(accessInfo map[string]string) (string, error) {
+	disableTLS, err := parseBoolField(accessInfo, "disableTLS")
+	if err != nil {
+		return "", err
+	}
+
+	endpoint := accessInfo["endpoint"]
+	if !strings.Contains(endpoint, "://") {
+		scheme := "https://"
+		if disableTLS {
+			scheme = "http://"
+		}
+		endpoint = scheme + endpoint
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("invalid endpoint %q: expected http(s)://host[:port]", accessInfo["endpoint"])
+	}
+	if disableTLS && u.Scheme == "https" {
+		return "", fmt.Errorf("endpoint %q uses https but disableTLS is set", accessInfo["endpoint"])
+	}
+	return strings.TrimSuffix(u.String(), "/"), nil
+}
+
+// parseBoolField parses an optional boolean credential field; empty means false
+func parseBoolField(accessInfo map[string]string, field string) (bool, error) {
+	value := accessInfo[field]
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s value %q: expected true or false", field, value)
+	}
+	return b, nil
+}
+
+// uploadToS3WithCreds uploads content from an io.Reader to an S3 object,
+// using the pooled client for the credentials in accessInfo.
+// The object gets the content type, cacheControl and "metadata.*" fields of accessInfo, and
+// its storage options (see ValidateS3Options).
+// S3 only creates the object once the upload completes. The object is then verified against
+// the streamed checksums and deleted again if it does not match.
+func UploadToS3WithCreds(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	key := accessInfo["objectPath"]
+	bucket := accessInfo["bucket"]
+	s3Client, release, err := pooledS3Client(ctx, accessInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
 
 	// Create an S3 Uploader instance.
 	uploader := manager.NewUploader(s3Client)
 
 	// Perform the upload.
-	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   reader,
-	})
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        reader,
+		ContentType: aws.String(objectContentType(accessInfo)),
+		Metadata:    objectMetadata(accessInfo),
+	}
+	if cacheControl := accessInfo["cacheControl"]; cacheControl != "" {
+		input.CacheControl = aws.String(cacheControl)
+	}
+	applyS3StorageOptions(input, accessInfo)
+	out, err := uploader.Upload(ctx, input)
 
 	if err != nil {
 		return fmt.Errorf("failed to upload object %s to bucket %s: %w", key, bucket, err)
 	}
 
+	if sums, ok := streamedChecksums(reader); ok {
+		if err := verifyS3Object(ctx, s3Client, input, out, sums); err != nil {
+			if _, delErr := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)}); delErr != nil {
+				logger.Errorf("Failed to delete unverified object %s from bucket %s: %v", key, bucket, delErr)
+			}
+			return fmt.Errorf("failed to verify object %s in bucket %s: %w", key, bucket, err)
+		}
+	}
+
 	logger.Infof("Successfully uploaded object '%s' to bucket '%s'", key, bucket)
 	return nil
 }
 
+// verifyS3Object checks an object uploaded with input against the streamed checksums. The ETag
+// of a single-part upload without KMS or customer key encryption is the MD5 of the content; for
+// other uploads (multipart, SSE-KMS, SSE-C, services with other ETags) the stored size is
+// compared instead.
+func verifyS3Object(ctx context.Context, client *s3.Client, input *s3.PutObjectInput, out *manager.UploadOutput, sums Checksums) error {
+	etag := strings.Trim(aws.ToString(out.ETag), `"`)
+	kms := out.SSEKMSKeyId != nil || strings.HasPrefix(string(out.ServerSideEncryption), "aws:kms") ||
+		strings.HasPrefix(string(input.ServerSideEncryption), "aws:kms")
+	if isMD5Hex(etag) && !kms && input.SSECustomerKey == nil {
+		if !strings.EqualFold(etag, sums.MD5) {
+			return fmt.Errorf("%w: ETag %s, streamed MD5 %s", ErrChecksumMismatch, etag, sums.MD5)
+		}
+		return nil
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+		SSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+	if size := aws.ToInt64(head.ContentLength); size != sums.Size {
+		return fmt.Errorf("%w: wrote %d bytes, object has %d", ErrChecksumMismatch, sums.Size, size)
+	}
+	return nil
+}
+
+// isMD5Hex reports whether s looks like a hex MD5 digest
+func isMD5Hex(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// DeleteFromS3WithCreds deletes an object written by UploadToS3WithCreds.
+// S3 reports success for keys that do not exist, so interrupted uploads need no special case;
+// the upload manager already aborts unfinished multipart uploads.
+func DeleteFromS3WithCreds(ctx context.Context, accessInfo map[string]string) error {
+	key := accessInfo["objectPath"]
+	bucket := accessInfo["bucket"]
+
+	client, release, err := pooledS3Client(ctx, accessInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object %s from bucket %s: %w", key, bucket, err)
+	}
+
+	logger.Infof("Deleted object '%s' from bucket '%s'", key, bucket)
+	return nil
+}
+
 func UseUploadToS3WithCredsExample() {
 	// Replace with your actual credentials and bucket details.
 	// NOTE: Hardcoding credentials is not recommended for production.
@@ -57,11 +295,11 @@ func UseUploadToS3WithCredsExample() {
 	content := "This is some data to upload to S3."
 
 	accessInfo := map[string]string{
-		"accessKey": myAccessKey,
-		"secretKey": mySecretKey,
-		"region":    myRegion,
-		"bucket":    myBucket,
-		"key":       myKey,
+		"accessKey":  myAccessKey,
+		"secretKey":  mySecretKey,
+		"region":     myRegion,
+		"bucket":     myBucket,
+		"objectPath": myKey,
 	}
 
 	// Create a reader from your content.
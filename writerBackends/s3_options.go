@@ -0,0 +1,226 @@
+package writerbackends
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3StorageFields are the accessInfo fields holding S3 storage options. A credential bundle
+// may also set them for the outputs of one format as "<format>.<field>", e.g.
+// "original.storageClass" for kept originals.
+var S3StorageFields = []string{"serverSideEncryption", "sseKmsKeyId", "sseCustomerKey", "storageClass", "acl", "tags"}
+
+// s3EncryptionFields are overridden together, so an override that sets any of them replaces
+// the encryption of the level below it entirely
+var s3EncryptionFields = []string{"serverSideEncryption", "sseKmsKeyId", "sseCustomerKey"}
+
+// S3 limits on object tags
+const (
+	maxS3Tags           = 10
+	maxS3TagKeyLength   = 128
+	maxS3TagValueLength = 256
+)
+
+// S3OutputOptions returns the "<format>.<field>" storage options of accessInfo for outputs of
+// format, keyed by field
+func S3OutputOptions(accessInfo map[string]string, format string) map[string]string {
+	options := make(map[string]string)
+	for _, field := range S3StorageFields {
+		if value, ok := accessInfo[format+"."+field]; ok {
+			options[field] = value
+		}
+	}
+	return options
+}
+
+// ApplyS3Options layers the storage options in overrides over those in accessInfo.
+// Encryption fields replace each other as a group, and tags are merged by key.
+func ApplyS3Options(accessInfo map[string]string, overrides map[string]string) error {
+	if slices.ContainsFunc(s3EncryptionFields, func(field string) bool { return overrides[field] != "" }) {
+		for _, field := range s3EncryptionFields {
+			delete(accessInfo, field)
+		}
+	}
+	for field, value := range overrides {
+		if value == "" {
+			continue
+		}
+		if field == "tags" {
+			merged, err := mergeS3Tags(accessInfo["tags"], value)
+			if err != nil {
+				return err
+			}
+			value = merged
+		}
+		accessInfo[field] = value
+	}
+	return nil
+}
+
+// mergeS3Tags returns the tags of base with those of overrides set over them, both in the
+// URL query format of the x-amz-tagging header ("team=web&tier=cold")
+func mergeS3Tags(base, overrides string) (string, error) {
+	tags, err := url.ParseQuery(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid tags %q: %w", base, err)
+	}
+	extra, err := url.ParseQuery(overrides)
+	if err != nil {
+		return "", fmt.Errorf("invalid tags %q: %w", overrides, err)
+	}
+	for key, values := range extra {
+		tags[key] = values
+	}
+	return tags.Encode(), nil
+}
+
+// EncodeS3Tags returns tags in the URL query format used by the "tags" field
+func EncodeS3Tags(tags map[string]string) string {
+	values := url.Values{}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values.Set(key, tags[key])
+	}
+	return values.Encode()
+}
+
+// ValidateS3Options checks storage options keyed by field name, as the S3 writer reads them
+// from accessInfo:
+//   - serverSideEncryption: "AES256" (SSE-S3), "aws:kms" or "aws:kms:dsse" (SSE-KMS)
+//   - sseKmsKeyId: KMS key for SSE-KMS; defaults to the bucket's AWS managed key
+//   - sseCustomerKey: base64 256-bit key for SSE-C, exclusive with serverSideEncryption
+//   - storageClass: e.g. "STANDARD_IA", "GLACIER_IR", "INTELLIGENT_TIERING"
+//   - acl: canned ACL, e.g. "private" or "public-read"
+//   - tags: URL query encoded tags, e.g. "team=web&tier=cold"
+func ValidateS3Options(options map[string]string) error {
+	sse := options["serverSideEncryption"]
+	if sse != "" && !slices.Contains(types.ServerSideEncryption("").Values(), types.ServerSideEncryption(sse)) {
+		return fmt.Errorf("invalid serverSideEncryption %q: expected AES256, aws:kms or aws:kms:dsse", sse)
+	}
+	if options["sseKmsKeyId"] != "" && !strings.HasPrefix(sse, "aws:kms") {
+		return fmt.Errorf("sseKmsKeyId requires serverSideEncryption aws:kms or aws:kms:dsse")
+	}
+	if customerKey := options["sseCustomerKey"]; customerKey != "" {
+		if sse != "" {
+			return fmt.Errorf("sseCustomerKey cannot be combined with serverSideEncryption")
+		}
+		if key, err := base64.StdEncoding.DecodeString(customerKey); err != nil || len(key) != 32 {
+			return fmt.Errorf("invalid sseCustomerKey: expected a base64 encoded 256-bit key")
+		}
+	}
+
+	if class := options["storageClass"]; class != "" && !slices.Contains(types.StorageClass("").Values(), types.StorageClass(class)) {
+		return fmt.Errorf("invalid storageClass %q", class)
+	}
+	if acl := options["acl"]; acl != "" && !slices.Contains(types.ObjectCannedACL("").Values(), types.ObjectCannedACL(acl)) {
+		return fmt.Errorf("invalid acl %q: expected a canned ACL such as private or public-read", acl)
+	}
+	return validateS3Tags(options["tags"])
+}
+
+// validateS3Tags checks URL query encoded tags against the S3 tag limits
+func validateS3Tags(encoded string) error {
+	if encoded == "" {
+		return nil
+	}
+	tags, err := url.ParseQuery(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid tags %q: %w", encoded, err)
+	}
+	if len(tags) > maxS3Tags {
+		return fmt.Errorf("%d tags given, S3 allows at most %d", len(tags), maxS3Tags)
+	}
+	for key, values := range tags {
+		if key == "" || len(key) > maxS3TagKeyLength {
+			return fmt.Errorf("invalid tag key %q: expected 1 to %d characters", key, maxS3TagKeyLength)
+		}
+		if len(values) != 1 {
+			return fmt.Errorf("tag %q is set more than once", key)
+		}
+		if len(values[0]) > maxS3TagValueLength {
+			return fmt.Errorf("value of tag %q is longer than %d characters", key, maxS3TagValueLength)
+		}
+	}
+	return nil
+}
+
+// validateS3StorageFields checks the storage options of a credential bundle, both as set and
+// as they apply to each format with "<format>.<field>" overrides
+func validateS3StorageFields(accessInfo map[string]string) error {
+	base := make(map[string]string)
+	formats := make(map[string]bool)
+	for field, value := range accessInfo {
+		if slices.Contains(S3StorageFields, field) {
+			base[field] = value
+			continue
+		}
+		if strings.HasPrefix(field, MetadataFieldPrefix) {
+			continue
+		}
+		if i := strings.LastIndex(field, "."); i > 0 && slices.Contains(S3StorageFields, field[i+1:]) {
+			formats[field[:i]] = true
+		}
+	}
+	if err := ValidateS3Options(base); err != nil {
+		return err
+	}
+
+	for format := range formats {
+		options := make(map[string]string, len(base))
+		for field, value := range base {
+			options[field] = value
+		}
+		if err := ApplyS3Options(options, S3OutputOptions(accessInfo, format)); err != nil {
+			return fmt.Errorf("%s outputs: %w", format, err)
+		}
+		if err := ValidateS3Options(options); err != nil {
+			return fmt.Errorf("%s outputs: %w", format, err)
+		}
+	}
+	return nil
+}
+
+// applyS3StorageOptions sets the storage options of accessInfo on an upload
+func applyS3StorageOptions(input *s3.PutObjectInput, accessInfo map[string]string) {
+	if sse := accessInfo["serverSideEncryption"]; sse != "" {
+		input.ServerSideEncryption = types.ServerSideEncryption(sse)
+	}
+	if keyID := accessInfo["sseKmsKeyId"]; keyID != "" {
+		input.SSEKMSKeyId = aws.String(keyID)
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = sseCustomerHeaders(accessInfo)
+	if class := accessInfo["storageClass"]; class != "" {
+		input.StorageClass = types.StorageClass(class)
+	}
+	if acl := accessInfo["acl"]; acl != "" {
+		input.ACL = types.ObjectCannedACL(acl)
+	}
+	if tags := accessInfo["tags"]; tags != "" {
+		input.Tagging = aws.String(tags)
+	}
+}
+
+// sseCustomerHeaders returns the SSE-C algorithm, key and key MD5 for the "sseCustomerKey" of
+// accessInfo, or nils without one. Reading an SSE-C object's metadata needs them as well.
+func sseCustomerHeaders(accessInfo map[string]string) (algorithm, key, keyMD5 *string) {
+	customerKey := accessInfo["sseCustomerKey"]
+	if customerKey == "" {
+		return nil, nil, nil
+	}
+	raw, _ := base64.StdEncoding.DecodeString(customerKey)
+	sum := md5.Sum(raw)
+	return aws.String("AES256"), aws.String(customerKey), aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+}
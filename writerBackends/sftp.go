@@ -2,7 +2,9 @@ package writerbackends
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net"
@@ -17,21 +19,239 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
+// SFTPWriter writes to the SFTP server in accessInfo
+type SFTPWriter struct{}
+
+func (SFTPWriter) Write(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
+	return UploadToSFTPWithCreds(ctx, accessInfo, reader)
+}
+
+func (SFTPWriter) Delete(ctx context.Context, accessInfo map[string]string) error {
+	return DeleteFromSFTPWithCreds(ctx, accessInfo)
+}
+
+func (SFTPWriter) Stat(ctx context.Context, accessInfo map[string]string) (ObjectInfo, error) {
+	sftpClient, _, release, err := pooledSFTP(ctx, accessInfo)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer release()
+
+	remotePath := sftpFilePath(accessInfo)
+	info, err := sftpClient.Stat(remotePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("stat remote file %s: %w", remotePath, err)
+	}
+	return ObjectInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// HealthCheck verifies on a new connection that the server accepts the credentials
+func (SFTPWriter) HealthCheck(ctx context.Context, accessInfo map[string]string) error {
+	sftpClient, addr, closeFn, err := dialSFTP(ctx, accessInfo)
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	if _, err := sftpClient.Getwd(); err != nil {
+		return fmt.Errorf("sftp session with %s: %w", addr, err)
+	}
+	return nil
+}
+
+func (SFTPWriter) RequiredFields() []string {
+	return []string{"host", "user", "remoteDir|remotePath", "password|privateKey", "knownHosts|hostKeyFingerprint|trustOnFirstUse"}
+}
+
+// ValidateCredentials checks the host key, target and pathTemplate fields
+func (SFTPWriter) ValidateCredentials(accessInfo map[string]string) error {
+	if err := validateHostKeyFields(accessInfo); err != nil {
+		return err
+	}
+	if accessInfo["remoteDir"] != "" && accessInfo["remotePath"] != "" {
+		return fmt.Errorf("set either remoteDir (base directory for object paths) or remotePath (single target file), not both")
+	}
+	if accessInfo["remoteDir"] == "" && accessInfo["pathTemplate"] != "" {
+		return fmt.Errorf("pathTemplate requires remoteDir; remotePath names a single target file")
+	}
+	return ValidatePathTemplate(accessInfo["pathTemplate"])
+}
+
+// sftpFilePath returns the remote file for accessInfo: the object path below the remoteDir
+// directory, or for bundles without remoteDir, the single file named by remotePath, which
+// every output overwrites as before object paths were introduced
+func sftpFilePath(accessInfo map[string]string) string {
+	if remoteDir := accessInfo["remoteDir"]; remoteDir != "" {
+		return path.Join(remoteDir, accessInfo["objectPath"])
+	}
+	return accessInfo["remotePath"]
+}
+
 // UploadToSFTPWithCreds uploads content from an io.Reader to a remote server via SFTP.
-// accessInfo should contain at least: host, user, remotePath. Optionally: port (default 22), password or privateKey (base64 or raw PEM).
+// accessInfo should contain at least: host, user, and remoteDir (base directory) with objectPath
+// or remotePath (target file).
+// Optionally: port (default 22), password or privateKey (base64 or raw PEM).
+// The server's host key is verified with knownHosts, hostKeyFingerprint or trustOnFirstUse.
+// The content goes to a hidden temporary file that is renamed over the target once its size
+// has been verified, so readers of the remote directory never see a partial file.
 func UploadToSFTPWithCreds(ctx context.Context, accessInfo map[string]string, reader io.Reader) error {
-	host := accessInfo["host"]
+	remotePath := sftpFilePath(accessInfo)
+
+	sftpClient, addr, release, err := pooledSFTP(ctx, accessInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Ensure remote directory exists
+	dir := path.Dir(remotePath)
+	if err := mkdirAllSFTP(sftpClient, dir); err != nil {
+		return fmt.Errorf("ensure remote dir %s: %w", dir, err)
+	}
+
+	// Create a temporary remote file next to the target and copy data
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	tmpPath := path.Join(dir, "."+path.Base(remotePath)+"."+hex.EncodeToString(suffix)+".tmp")
+	f, err := sftpClient.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create remote file %s: %w", tmpPath, err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			f.Close()
+			sftpClient.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := io.Copy(f, reader); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return fmt.Errorf("copy to remote file %s: %w", tmpPath, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("close remote file %s: %w", tmpPath, err)
+	}
+
+	// SFTP servers report no checksums, so verify the size of what arrived
+	if sums, ok := streamedChecksums(reader); ok {
+		info, err := sftpClient.Stat(tmpPath)
+		if err != nil {
+			return fmt.Errorf("stat remote file %s: %w", tmpPath, err)
+		}
+		if info.Size() != sums.Size {
+			return fmt.Errorf("%w: wrote %d bytes to %s, server has %d", ErrChecksumMismatch, sums.Size, tmpPath, info.Size())
+		}
+	}
+
+	if err := renameSFTP(sftpClient, tmpPath, remotePath); err != nil {
+		return fmt.Errorf("move remote file into place at %s: %w", remotePath, err)
+	}
+	committed = true
+
+	logger.Infof("Successfully uploaded '%s' to %s", remotePath, addr)
+	return nil
+}
+
+// DeleteFromSFTPWithCreds removes a file written by UploadToSFTPWithCreds
+func DeleteFromSFTPWithCreds(ctx context.Context, accessInfo map[string]string) error {
+	remotePath := sftpFilePath(accessInfo)
+
+	sftpClient, addr, release, err := pooledSFTP(ctx, accessInfo)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := sftpClient.Remove(remotePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove remote file %s: %w", remotePath, err)
+	}
+
+	logger.Infof("Removed '%s' from %s", remotePath, addr)
+	return nil
+}
+
+// sftpClientFields are the accessInfo fields that identify a pooled SFTP connection
+var sftpClientFields = []string{"host", "port", "user", "password", "privateKey", "knownHosts", "hostKeyFingerprint", "trustOnFirstUse"}
+
+// pooledSFTP returns the pooled SFTP client for the server and user in accessInfo, connecting
+// on first use. Cancelling ctx stops waiting for the connection but leaves it open for other
+// writes; transfers stop through their context-aware reader instead.
+// Callers must call the returned release function when done.
+func pooledSFTP(ctx context.Context, accessInfo map[string]string) (*sftp.Client, string, func(), error) {
+	sftpClient, release, err := acquireClient(ctx, "sftp", accessInfo, sftpClientFields,
+		func(ctx context.Context, discard func()) (*sftp.Client, func(), error) {
+			sshClient, sftpClient, _, err := connectSFTP(ctx, accessInfo)
+			if err != nil {
+				return nil, nil, err
+			}
+			// Drop the connection from the pool once the server closes it
+			go func() {
+				sshClient.Wait()
+				discard()
+			}()
+			return sftpClient, func() {
+				sftpClient.Close()
+				sshClient.Close()
+			}, nil
+		})
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return sftpClient, sftpAddr(accessInfo), release, nil
+}
+
+// dialSFTP opens a new connection to the SFTP server in accessInfo, bypassing the pool.
+// The connection is torn down when ctx is cancelled, which aborts any transfer in progress;
+// callers must call the returned close function when done.
+func dialSFTP(ctx context.Context, accessInfo map[string]string) (*sftp.Client, string, func(), error) {
+	sshClient, sftpClient, addr, err := connectSFTP(ctx, accessInfo)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Close the connection on cancellation; this unblocks any transfer
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			sshClient.Close()
+		case <-done:
+		}
+	}()
+
+	closeFn := func() {
+		close(done)
+		sftpClient.Close()
+		sshClient.Close()
+	}
+	return sftpClient, addr, closeFn, nil
+}
+
+// sftpAddr returns the host:port of the SFTP server in accessInfo
+func sftpAddr(accessInfo map[string]string) string {
 	port := accessInfo["port"]
 	if port == "" {
 		port = "22"
 	}
+	return net.JoinHostPort(accessInfo["host"], port)
+}
+
+// connectSFTP connects and authenticates to the SFTP server in accessInfo.
+// ctx bounds connecting and the handshake only; the connection stays open until closed.
+func connectSFTP(ctx context.Context, accessInfo map[string]string) (*ssh.Client, *sftp.Client, string, error) {
+	host := accessInfo["host"]
 	user := accessInfo["user"]
 	password := accessInfo["password"]
 	privateKey := accessInfo["privateKey"]
-	remotePath := accessInfo["remotePath"]
 
-	if host == "" || user == "" || remotePath == "" {
-		return fmt.Errorf("missing required accessInfo keys: host, user, remotePath")
+	if host == "" || user == "" || (accessInfo["remoteDir"] == "" && accessInfo["remotePath"] == "") {
+		return nil, nil, "", fmt.Errorf("missing required accessInfo keys: host, user, remoteDir or remotePath")
 	}
 
 	var auths []ssh.AuthMethod
@@ -43,64 +263,80 @@ func UploadToSFTPWithCreds(ctx context.Context, accessInfo map[string]string, re
 		}
 		signer, err := ssh.ParsePrivateKey(keyBytes)
 		if err != nil {
-			return fmt.Errorf("parse private key: %w", err)
+			return nil, nil, "", fmt.Errorf("parse private key: %w", err)
 		}
 		auths = append(auths, ssh.PublicKeys(signer))
 	} else if password != "" {
 		auths = append(auths, ssh.Password(password))
 	} else {
-		return fmt.Errorf("no auth method provided; set password or privateKey in accessInfo")
+		return nil, nil, "", fmt.Errorf("no auth method provided; set password or privateKey in accessInfo")
+	}
+
+	verifyHostKey, err := hostKeyCallback(accessInfo)
+	if err != nil {
+		return nil, nil, "", err
 	}
 
 	config := &ssh.ClientConfig{
 		User:            user,
 		Auth:            auths,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: verifyHostKey,
 		Timeout:         10 * time.Second,
 	}
 
-	addr := net.JoinHostPort(host, port)
+	addr := sftpAddr(accessInfo)
 
 	// Dial respecting context
 	d := net.Dialer{}
 	conn, err := d.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return fmt.Errorf("dial tcp %s: %w", addr, err)
+		return nil, nil, "", fmt.Errorf("dial tcp %s: %w", addr, err)
 	}
 
+	// Close the connection if ctx ends during the handshake
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
 	// perform SSH handshake on the established connection
 	clientConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
-		return fmt.Errorf("ssh handshake with %s: %w", addr, err)
+		close(stop)
+		conn.Close()
+		return nil, nil, "", fmt.Errorf("ssh handshake with %s: %w", addr, err)
 	}
 	sshClient := ssh.NewClient(clientConn, chans, reqs)
-	defer sshClient.Close()
 
 	sftpClient, err := sftp.NewClient(sshClient)
-	if err != nil {
-		return fmt.Errorf("create sftp client: %w", err)
+	close(stop)
+	<-stopped
+	if err == nil {
+		err = ctx.Err()
 	}
-	defer sftpClient.Close()
-
-	// Ensure remote directory exists
-	dir := path.Dir(remotePath)
-	if err := mkdirAllSFTP(sftpClient, dir); err != nil {
-		return fmt.Errorf("ensure remote dir %s: %w", dir, err)
-	}
-
-	// Create (or truncate) remote file and copy data
-	f, err := sftpClient.Create(remotePath)
 	if err != nil {
-		return fmt.Errorf("create remote file %s: %w", remotePath, err)
+		sshClient.Close()
+		return nil, nil, "", fmt.Errorf("create sftp client: %w", err)
 	}
-	defer f.Close()
+	return sshClient, sftpClient, addr, nil
+}
 
-	if _, err := io.Copy(f, reader); err != nil {
-		return fmt.Errorf("copy to remote file %s: %w", remotePath, err)
+// renameSFTP replaces newname with oldname, atomically if the server supports the
+// posix-rename extension; plain SFTP renames fail if the target exists
+func renameSFTP(client *sftp.Client, oldname, newname string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(oldname, newname)
 	}
-
-	logger.Infof("Successfully uploaded '%s' to %s", remotePath, addr)
-	return nil
+	if err := client.Remove(newname); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return client.Rename(oldname, newname)
 }
 
 // mkdirAllSFTP mimics os.MkdirAll for an SFTP server by creating each segment of the path.
@@ -124,6 +360,10 @@ func mkdirAllSFTP(client *sftp.Client, dir string) error {
 		if _, err := client.Stat(cur); err != nil {
 			if os.IsNotExist(err) {
 				if err := client.Mkdir(cur); err != nil {
+					// A concurrent upload may have created it meanwhile
+					if info, statErr := client.Stat(cur); statErr == nil && info.IsDir() {
+						continue
+					}
 					return fmt.Errorf("mkdir %s: %w", cur, err)
 				}
 			} else {
@@ -137,11 +377,13 @@ func mkdirAllSFTP(client *sftp.Client, dir string) error {
 func UseUploadToSFTPWithCredsExample() {
 	// Example values - do NOT hardcode credentials in production.
 	accessInfo := map[string]string{
-		"host":       "sftp.example.com",
-		"port":       "22",
-		"user":       "username",
-		"password":   "secret",
-		"remotePath": "/upload/example.txt",
+		"host":               "sftp.example.com",
+		"port":               "22",
+		"user":               "username",
+		"password":           "secret",
+		"remoteDir":          "/upload",
+		"objectPath":         "example.txt",
+		"hostKeyFingerprint": "SHA256:...",
 	}
 
 	content := "This is a test upload to SFTP."
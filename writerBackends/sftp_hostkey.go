@@ -0,0 +1,152 @@
+package writerbackends
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"pixerve/credentials"
+	"pixerve/logger"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrHostKeyMismatch is returned when an SFTP server presents a host key other than the one
+// pinned or recorded for it, which may be a man-in-the-middle attack
+var ErrHostKeyMismatch = errors.New("sftp host key mismatch")
+
+// ErrUnknownHostKey is returned when knownHosts has no entry for an SFTP server and
+// trustOnFirstUse is off
+var ErrUnknownHostKey = errors.New("sftp host key unknown")
+
+// validateHostKeyFields checks the host key fields of an SFTP credential bundle: at least one
+// of knownHosts, hostKeyFingerprint and trustOnFirstUse must be set
+func validateHostKeyFields(accessInfo map[string]string) error {
+	tofu, err := parseBoolField(accessInfo, "trustOnFirstUse")
+	if err != nil {
+		return err
+	}
+	fingerprint := accessInfo["hostKeyFingerprint"]
+	if fingerprint != "" && !strings.HasPrefix(fingerprint, "SHA256:") {
+		return fmt.Errorf("invalid hostKeyFingerprint %q: expected the SHA256:<base64> form printed by ssh-keygen -l", fingerprint)
+	}
+	if knownHosts := accessInfo["knownHosts"]; knownHosts != "" {
+		if _, err := knownHostsCallback(knownHosts); err != nil {
+			return fmt.Errorf("invalid knownHosts: %w", err)
+		}
+	}
+	if accessInfo["knownHosts"] == "" && fingerprint == "" && !tofu {
+		return fmt.Errorf("no host key verification configured; set knownHosts, hostKeyFingerprint or trustOnFirstUse")
+	}
+	return nil
+}
+
+// hostKeyCallback verifies SFTP server keys against the bundle in accessInfo.
+// A hostKeyFingerprint must match; knownHosts entries for the server must include the key.
+// A server without knownHosts entries is accepted if its fingerprint is pinned, or with
+// trustOnFirstUse, in which case its key is recorded in the bundle under the storage key.
+func hostKeyCallback(accessInfo map[string]string) (ssh.HostKeyCallback, error) {
+	if err := validateHostKeyFields(accessInfo); err != nil {
+		return nil, err
+	}
+	tofu, _ := parseBoolField(accessInfo, "trustOnFirstUse")
+	fingerprint := accessInfo["hostKeyFingerprint"]
+	knownHosts := accessInfo["knownHosts"]
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if fingerprint != "" && ssh.FingerprintSHA256(key) != fingerprint {
+			return fmt.Errorf("%w: %s presented %s %s, expected %s",
+				ErrHostKeyMismatch, hostname, key.Type(), ssh.FingerprintSHA256(key), fingerprint)
+		}
+
+		known, err := checkKnownHosts(knownHosts, hostname, remote, key)
+		switch {
+		case err != nil:
+			return err
+		case known, fingerprint != "":
+			return nil
+		case tofu:
+			return recordHostKey(accessInfo["key"], hostname, remote, key)
+		}
+		return fmt.Errorf("%w: no knownHosts entry for %s (%s %s)",
+			ErrUnknownHostKey, knownhosts.Normalize(hostname), key.Type(), ssh.FingerprintSHA256(key))
+	}, nil
+}
+
+// checkKnownHosts reports whether the known_hosts content lists key for hostname.
+// Returns false without error if it has no entry for hostname, and ErrHostKeyMismatch if its
+// entries for hostname hold other keys.
+func checkKnownHosts(content, hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	if content == "" {
+		return false, nil
+	}
+	callback, err := knownHostsCallback(content)
+	if err != nil {
+		return false, fmt.Errorf("invalid knownHosts: %w", err)
+	}
+
+	err = callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	var revokedErr *knownhosts.RevokedError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+		return false, nil
+	case errors.As(err, &keyErr):
+		return false, fmt.Errorf("%w: %s presented %s %s, which is not in knownHosts",
+			ErrHostKeyMismatch, knownhosts.Normalize(hostname), key.Type(), ssh.FingerprintSHA256(key))
+	case errors.As(err, &revokedErr):
+		return false, fmt.Errorf("%w: %s presented revoked key %s",
+			ErrHostKeyMismatch, knownhosts.Normalize(hostname), ssh.FingerprintSHA256(key))
+	}
+	return false, err
+}
+
+// knownHostsCallback parses known_hosts content. The knownhosts package only reads files,
+// so the content goes through a temporary file that is removed once parsed.
+func knownHostsCallback(content string) (ssh.HostKeyCallback, error) {
+	f, err := os.CreateTemp("", "pixerve-known-hosts-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return knownhosts.New(f.Name())
+}
+
+// recordHostKey appends the key of a first-seen server to the knownHosts field of the bundle
+// registered under storageKey. The stored bundle is checked again under the update lock, so
+// concurrent first connections agree on one key.
+func recordHostKey(storageKey, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	if storageKey == "" {
+		return fmt.Errorf("%w: trustOnFirstUse needs a registered credential bundle to record the key of %s",
+			ErrUnknownHostKey, knownhosts.Normalize(hostname))
+	}
+
+	return credentials.UpdateCredentials(storageKey, func(bundle map[string]string) error {
+		known, err := checkKnownHosts(bundle["knownHosts"], hostname, remote, key)
+		if err != nil || known {
+			return err
+		}
+
+		line := knownhosts.Line([]string{hostname}, key)
+		if existing := strings.TrimRight(bundle["knownHosts"], "\n"); existing != "" {
+			line = existing + "\n" + line
+		}
+		bundle["knownHosts"] = line + "\n"
+		logger.Warnf("Trusting SFTP host key %s of %s on first use for storage key %s",
+			ssh.FingerprintSHA256(key), knownhosts.Normalize(hostname), storageKey)
+		return nil
+	})
+}
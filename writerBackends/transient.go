@@ -0,0 +1,93 @@
+package writerbackends
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/aws/smithy-go"
+	"github.com/pkg/sftp"
+	"google.golang.org/api/googleapi"
+)
+
+// transientS3Codes are S3 error codes returned while the service is overloaded or briefly unavailable
+var transientS3Codes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+	"RequestTimeout":           true,
+	"InternalError":            true,
+	"ServiceUnavailable":       true,
+}
+
+// IsTransientError reports whether a backend error is known to be temporary, so writing
+// again later may succeed: network errors, timeouts, throttling and 5xx responses from S3
+// or GCS, lost SFTP connections, and checksum mismatches caused by corruption in transit.
+// Everything else, such as rejected credentials, missing buckets or invalid arguments,
+// is not transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// Timeouts
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Network errors: failed dials, resets and connections closed mid-response
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	for _, errno := range []syscall.Errno{syscall.ECONNREFUSED, syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE, syscall.ETIMEDOUT} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, sftp.ErrSSHFxConnectionLost) {
+		return true
+	}
+
+	// The content was damaged on the way; a fresh upload can succeed
+	if errors.Is(err, ErrChecksumMismatch) {
+		return true
+	}
+
+	// S3: throttling and server-side error codes, or else the HTTP status of the response
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && transientS3Codes[apiErr.ErrorCode()] {
+		return true
+	}
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		return isTransientStatus(statusErr.HTTPStatusCode())
+	}
+
+	// GCS
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		return isTransientStatus(gcsErr.Code)
+	}
+
+	return false
+}
+
+// isTransientStatus reports whether an HTTP status means the request may succeed later
+func isTransientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code >= 500
+}